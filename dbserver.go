@@ -6,20 +6,20 @@ import (
 	"net/http"
 	//"os"
 
-	"golang.org/x/net/netutil"
-
 	"./tinydatabase"
 )
 
 func main() {
 	db, err := tinydatabase.LoadDatabaseList("webdb", "json")
-	if err != nil {
+	if err == tinydatabase.ErrDatabaseNotExist {
 		db, err = tinydatabase.NewDatabaseList("webdb", "json")
-		if err != nil {
-			fmt.Printf("ERROR:%s", err)
-			return
-		}
 	}
+	if err != nil {
+		//Other process may be using the directory.
+		fmt.Printf("ERROR:%s", err)
+		return
+	}
+	defer db.Close()
 
 	webIf := tinydatabase.WebIF{}
 	webIf.Prefix = "/v1/"
@@ -32,13 +32,13 @@ func main() {
 		fmt.Printf("ERROR:%s", err)
 		return
 	}
-	limit_listener := netutil.LimitListener(listener, 1)
 	http_config := &http.Server{
 		Addr: port,
 	}
 
-	defer limit_listener.Close()
-	err = http_config.Serve(limit_listener)
+	//Requests are served at the same time. Databases and tables are locked by themselves.
+	defer listener.Close()
+	err = http_config.Serve(listener)
 	if err != nil {
 		fmt.Printf("ERROR:%s", err)
 		return
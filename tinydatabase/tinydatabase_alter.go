@@ -0,0 +1,347 @@
+package tinydatabase
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+/*
+ ColumnChange is a change of AlterTable.
+ ALTER_ADD adds Column. Existing rows get its default value, NULL or zero value.
+ ALTER_DROP drops the column Name.
+ ALTER_RENAME renames the column Name to NewName.
+ ALTER_WIDEN changes the size of string column Name to Size. Size 0 is variable size of dynamic table.
+*/
+type ColumnChange struct {
+	Op      string
+	Name    string
+	Column  ColumnType
+	NewName string
+	Size    int64
+}
+
+const (
+	ALTER_ADD    string = "add"
+	ALTER_DROP   string = "drop"
+	ALTER_RENAME string = "rename"
+	ALTER_WIDEN  string = "widen"
+)
+
+//alterSuffix is added to the names of new files of AlterTable until they are swapped in.
+const alterSuffix = ".alter"
+
+var (
+	ErrColumnExist        = errors.New("Specified column exists")
+	ErrInvalidAlter       = errors.New("Specified change of table is invalid")
+	ErrColumnInConstraint = errors.New("Specified column is used by constraint")
+)
+
+//alterableTable is a table whose columns can be changed.
+type alterableTable interface {
+	alterTable(changes []ColumnChange) error
+}
+
+/*
+ AlterTable func changes columns of the table.
+ The table files are rewritten with the new columns. Row numbers do not change.
+ Indexes on dropped columns are dropped. Columns of constraints can not be dropped.
+*/
+func (self *Database) AlterTable(tablename string, changes ...ColumnChange) error {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return err
+	}
+	alterable, ok := table.(alterableTable)
+	if ok == false {
+		return ErrInvalidTabletype
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	return alterable.alterTable(changes)
+}
+
+//**************************************************
+
+//alterPlan is the result of changes on table config.
+type alterPlan struct {
+	config       *tableConfig
+	sources      map[string]string //New column name to old column name
+	added        []ColumnType
+	IndexRenames map[string]string //Old index name to new index name
+	IndexDrops   []string
+}
+
+/*
+ planAlter applies changes on a copy of config.
+ variable is whether the table supports variable size columns.
+*/
+func planAlter(config *tableConfig, changes []ColumnChange, variable bool) (*alterPlan, error) {
+	result := &alterPlan{}
+	result.sources = map[string]string{}
+	result.IndexRenames = map[string]string{}
+	newConfig := *config
+	newConfig.Columns = append([]ColumnType{}, config.Columns...)
+	newConfig.PrimaryKey = append([]string{}, config.PrimaryKey...)
+	newConfig.Unique = [][]string{}
+	for _, v := range config.Unique {
+		newConfig.Unique = append(newConfig.Unique, append([]string{}, v...))
+	}
+	for _, v := range config.Columns {
+		result.sources[v.Name] = v.Name
+	}
+	//renamed is old column name to new column name. Dropped columns are "".
+	renamed := map[string]string{}
+	for _, v := range config.Columns {
+		renamed[v.Name] = v.Name
+	}
+
+	for _, change := range changes {
+		i := -1
+		for j, v := range newConfig.Columns {
+			if v.Name == change.Name {
+				i = j
+			}
+		}
+		if change.Op != ALTER_ADD && i < 0 {
+			return nil, ErrColumnNotExist
+		}
+		switch change.Op {
+		case ALTER_ADD:
+			column := change.Column
+			for _, v := range newConfig.Columns {
+				if v.Name == column.Name {
+					return nil, ErrColumnExist
+				}
+			}
+			if column.Name == "" || column.Identity || column.Default == DEFAULT_AUTOINCREMENT {
+				return nil, ErrInvalidAlter
+			}
+			err := column.checkDefault()
+			if err != nil {
+				return nil, err
+			}
+			newConfig.Columns = append(newConfig.Columns, column)
+			result.added = append(result.added, column)
+		case ALTER_DROP:
+			for _, columns := range append([][]string{newConfig.PrimaryKey}, newConfig.Unique...) {
+				for _, v := range columns {
+					if v == change.Name {
+						return nil, ErrColumnInConstraint
+					}
+				}
+			}
+			if len(newConfig.Columns) == 1 {
+				return nil, ErrInvalidAlter
+			}
+			newConfig.Columns = append(newConfig.Columns[:i:i], newConfig.Columns[i+1:]...)
+			for k, v := range renamed {
+				if v == change.Name {
+					renamed[k] = ""
+				}
+			}
+			delete(result.sources, change.Name)
+			for j, v := range result.added {
+				if v.Name == change.Name {
+					result.added = append(result.added[:j:j], result.added[j+1:]...)
+					break
+				}
+			}
+		case ALTER_RENAME:
+			if change.NewName == "" {
+				return nil, ErrInvalidAlter
+			}
+			for _, v := range newConfig.Columns {
+				if v.Name == change.NewName {
+					return nil, ErrColumnExist
+				}
+			}
+			newConfig.Columns[i].Name = change.NewName
+			for k, v := range renamed {
+				if v == change.Name {
+					renamed[k] = change.NewName
+				}
+			}
+			source, ok := result.sources[change.Name]
+			if ok {
+				delete(result.sources, change.Name)
+				result.sources[change.NewName] = source
+			}
+			for j, v := range result.added {
+				if v.Name == change.Name {
+					result.added[j].Name = change.NewName
+				}
+			}
+			renameColumns(newConfig.PrimaryKey, change.Name, change.NewName)
+			for _, v := range newConfig.Unique {
+				renameColumns(v, change.Name, change.NewName)
+			}
+		case ALTER_WIDEN:
+			column := &newConfig.Columns[i]
+			if column.Type != COLUMN_STRING || column.Size == 0 {
+				return nil, ErrInvalidAlter
+			}
+			if change.Size == 0 && variable == false {
+				return nil, ErrInvalidAlter
+			}
+			if change.Size != 0 && change.Size <= column.Size {
+				return nil, ErrInvalidAlter
+			}
+			column.Size = change.Size
+		default:
+			return nil, ErrInvalidAlter
+		}
+	}
+
+	newConfig.Indexes = []indexConfig{}
+	names := map[string]bool{}
+	for _, v := range config.Indexes {
+		names[v.Name] = true
+	}
+	for _, v := range config.Indexes {
+		columns := []string{}
+		for _, name := range v.Columns {
+			columns = append(columns, renamed[name])
+		}
+		if len(columns) != len(v.Columns) || containsString(columns, "") {
+			result.IndexDrops = append(result.IndexDrops, v.Name)
+			continue
+		}
+		c := indexConfig{Name: indexName(columns), Columns: columns, Unique: v.Unique}
+		if c.Name != v.Name {
+			//A file of other index must not be overwritten while files are renamed.
+			if names[c.Name] {
+				return nil, ErrInvalidAlter
+			}
+			result.IndexRenames[v.Name] = c.Name
+		}
+		newConfig.Indexes = append(newConfig.Indexes, c)
+	}
+	result.config = &newConfig
+	return result, nil
+}
+
+//renameColumns replaces name with newName in columns.
+func renameColumns(columns []string, name string, newName string) {
+	for i, v := range columns {
+		if v == name {
+			columns[i] = newName
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ convert returns the row of new columns from the row of old columns.
+ Added columns get their default values.
+*/
+func (self *alterPlan) convert(row Row) (Row, error) {
+	result := Row{}
+	for name, source := range self.sources {
+		result[name] = row[source]
+	}
+	return applyDefaults(self.added, result, map[string]int64{})
+}
+
+/*
+ commitAlter swaps the new files of AlterTable in.
+ The new config is written beside the old one, and the marker file commits the change.
+ Files of the table must be closed.
+*/
+func commitAlter(directory string, tablename string, plan *alterPlan) error {
+	basename := directory + tablename
+	err := saveTableConfig(basename+".config"+alterSuffix, plan.config)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	marker, err := os.OpenFile(basename+alterSuffix, os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = marker.Write(b)
+	if err == nil {
+		err = marker.Sync()
+	}
+	marker.Close()
+	if err != nil {
+		return err
+	}
+	err = syncDir(directory)
+	if err != nil {
+		return err
+	}
+	return recoverAlter(directory, tablename)
+}
+
+/*
+ recoverAlter finishes or discards the files of AlterTable.
+ When the marker file exists, index files are renamed or removed and the new files are moved
+ to the place of the old ones. Otherwise the new files are incomplete and removed.
+*/
+func recoverAlter(directory string, tablename string) error {
+	basename := directory + tablename
+	exts := []string{".config", ".table", ".index"}
+	b, err := ioutil.ReadFile(basename + alterSuffix)
+	if err != nil {
+		if os.IsNotExist(err) == false {
+			return err
+		}
+		for _, ext := range exts {
+			os.Remove(basename + ext + alterSuffix)
+		}
+		os.Remove(basename + ".config" + alterSuffix + tempSuffix)
+		return nil
+	}
+	plan := &alterPlan{}
+	err = json.Unmarshal(b, plan)
+	if err != nil {
+		return err
+	}
+	for _, name := range plan.IndexDrops {
+		err = os.Remove(indexFilename(directory, tablename, name))
+		if err != nil && os.IsNotExist(err) == false {
+			return err
+		}
+	}
+	for name, newName := range plan.IndexRenames {
+		_, err = os.Stat(indexFilename(directory, tablename, name))
+		if err == nil {
+			err = os.Rename(indexFilename(directory, tablename, name), indexFilename(directory, tablename, newName))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, ext := range exts {
+		_, err = os.Stat(basename + ext + alterSuffix)
+		if err == nil {
+			err = os.Rename(basename+ext+alterSuffix, basename+ext)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = syncDir(directory)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(basename + alterSuffix)
+	if err != nil {
+		return err
+	}
+	return syncDir(directory)
+}
@@ -0,0 +1,188 @@
+package tinydatabase
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test1_Alter_basicUsage(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "id", Type: COLUMN_INT64, Size: 64},
+			{Name: "name", Type: COLUMN_STRING, Size: 8},
+			{Name: "score", Type: COLUMN_FLOAT64, Size: 64},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		for i := 0; i < 5; i++ {
+			_, err = table.WriteRow(Row{"id": int64(i), "name": fmt.Sprintf("n%d", i), "score": float64(i) / 2})
+			if err != nil {
+				t.Errorf("Failed to insert row: %s", err)
+			}
+		}
+		table.DeleteRow(2)
+		err = db.SetPrimaryKey(tabletype, []string{"id"})
+		if err != nil {
+			t.Errorf("Failed to set primary key: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"name"}, false)
+		if err != nil {
+			t.Errorf("Failed to create index: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"score"}, false)
+		if err != nil {
+			t.Errorf("Failed to create index: %s", err)
+		}
+
+		err = db.AlterTable(tabletype, ColumnChange{Op: ALTER_DROP, Name: "id"})
+		if err != ErrColumnInConstraint {
+			t.Errorf("Failed to refuse drop of primary key: %v", err)
+		}
+		err = db.AlterTable(tabletype, ColumnChange{Op: ALTER_ADD, Column: ColumnType{Name: "name", Type: COLUMN_INT64}})
+		if err != ErrColumnExist {
+			t.Errorf("Failed to refuse same column: %v", err)
+		}
+		err = db.AlterTable(tabletype, ColumnChange{Op: ALTER_WIDEN, Name: "name", Size: 4})
+		if err != ErrInvalidAlter {
+			t.Errorf("Failed to refuse narrowing: %v", err)
+		}
+		err = db.AlterTable(tabletype, ColumnChange{Op: ALTER_ADD, Column: ColumnType{Name: "required", Type: COLUMN_INT64, NotNull: true}})
+		if err != ErrNotNull {
+			t.Errorf("Failed to refuse NOT NULL column without default: %v", err)
+		}
+		_, err = os.Stat(directoryJson + "database1/" + tabletype + ".table.alter")
+		if os.IsNotExist(err) == false {
+			t.Errorf("Failed to remove files of failed change: %v", err)
+		}
+
+		size := int64(16)
+		if tabletype == "dynamic" {
+			size = 0
+		}
+		err = db.AlterTable(tabletype,
+			ColumnChange{Op: ALTER_ADD, Column: ColumnType{Name: "level", Type: COLUMN_INT64, Default: int64(7)}},
+			ColumnChange{Op: ALTER_ADD, Column: ColumnType{Name: "memo", Type: COLUMN_STRING, Size: 8, Nullable: true}},
+			ColumnChange{Op: ALTER_DROP, Name: "score"},
+			ColumnChange{Op: ALTER_RENAME, Name: "name", NewName: "title"},
+			ColumnChange{Op: ALTER_WIDEN, Name: "title", Size: size},
+		)
+		if err != nil {
+			t.Fatalf("Failed to alter table: %s", err)
+		}
+		table, _ = db.GetTable(tabletype)
+		columns := table.GetColumns()
+		if len(columns) != 4 || columns[1].Name != "title" || columns[1].Size != size || columns[2].Name != "level" || columns[3].Name != "memo" {
+			t.Errorf("Failed to change columns: %v", columns)
+		}
+		row, err := table.ReadRow(3)
+		if err != nil || row["id"] != int64(3) || row["title"] != "n3" || row["level"] != int64(7) || row["memo"] != nil || len(row) != 4 {
+			t.Errorf("Failed to convert row: %v, %v", row, err)
+		}
+		_, err = table.ReadRow(2)
+		if err != ErrRowDeleted {
+			t.Errorf("Failed to keep deleted row: %v", err)
+		}
+		_, err = os.Stat(directoryJson + "database1/" + tabletype + ".score.btree")
+		if os.IsNotExist(err) == false {
+			t.Errorf("Failed to remove index of dropped column: %v", err)
+		}
+		_, err = db.GetIndex(tabletype, []string{"name"})
+		if err != ErrIndexNotExist {
+			t.Errorf("Failed to rename index: %v", err)
+		}
+		//Static table reuses the slot of deleted row.
+		expected := int64(2)
+		if tabletype == "dynamic" {
+			expected = 5
+		}
+		rowNum, err := table.WriteRow(Row{"id": int64(10), "title": "long title 10", "level": int64(1), "memo": "m"})
+		if err != nil || rowNum != expected {
+			t.Errorf("Failed to insert row after change: %d, %v", rowNum, err)
+		}
+		_, err = table.WriteRow(Row{"id": int64(1), "title": "dup", "level": int64(1)})
+		if err != ErrConstraintViolation {
+			t.Errorf("Failed to keep primary key: %v", err)
+		}
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ = dbList.Get("database1")
+	for _, tabletype := range []string{"static", "dynamic"} {
+		index, err := db.GetIndex(tabletype, []string{"title"})
+		if err != nil {
+			t.Fatalf("Failed to get renamed index: %s", err)
+		}
+		rows, _ := index.Lookup("n4")
+		if fmt.Sprint(rows) != "[4]" {
+			t.Errorf("Failed to look up renamed index: %v", rows)
+		}
+		rows, _ = index.Lookup("long title 10")
+		if len(rows) != 1 {
+			t.Errorf("Failed to look up new row: %v", rows)
+		}
+		row, _, err := db.ReadRowByKey(tabletype, 10)
+		if err != nil || row["memo"] != "m" {
+			t.Errorf("Failed to read row by key: %v, %v", row, err)
+		}
+	}
+	dbList.Close()
+}
+
+func Test2_Alter_recover(t *testing.T) {
+	directory := "./testdata/"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	table := &TableStatic{}
+	err := table.NewTable(directory, "table1", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	table.WriteRow(Row{"a": int64(1)})
+	table.Close()
+
+	//Files without the marker are discarded.
+	ioutil.WriteFile(directory+"table1.config.alter", []byte("[]"), 0666)
+	err = table.Open(directory, "table1")
+	if err != nil {
+		t.Fatalf("Failed to open table: %s", err)
+	}
+	_, err = os.Stat(directory + "table1.config.alter")
+	if os.IsNotExist(err) == false || len(table.GetColumns()) != 1 {
+		t.Errorf("Failed to discard new files: %v", err)
+	}
+
+	//Files with the marker are swapped in.
+	plan, _ := planAlter(table.config(), []ColumnChange{{Op: ALTER_RENAME, Name: "a", NewName: "b"}}, false)
+	table.Close()
+	saveTableConfig(directory+"table1.config.alter", plan.config)
+	ioutil.WriteFile(directory+"table1.alter", []byte("{}"), 0666)
+	err = table.Open(directory, "table1")
+	if err != nil {
+		t.Fatalf("Failed to open table: %s", err)
+	}
+	row, err := table.ReadRow(0)
+	if err != nil || row["b"] != int64(1) {
+		t.Errorf("Failed to finish change: %v, %v", row, err)
+	}
+	table.Close()
+}
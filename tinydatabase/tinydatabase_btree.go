@@ -0,0 +1,762 @@
+package tinydatabase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+ Index is a secondary B+tree index on columns of a table.
+ The index file is placed next to the table file and is updated with rows.
+*/
+type Index struct {
+	Name        string
+	Columns     []string
+	Unique      bool
+	tree        *btree
+	columnTypes []ColumnType
+	constraint  bool
+	primary     bool
+	lock        *sync.RWMutex //Lock of the table
+}
+
+//indexConfig is a definition of index saved in table config file.
+type indexConfig struct {
+	Name    string
+	Columns []string
+	Unique  bool `json:",omitempty" toml:",omitempty"`
+}
+
+//btree is a B+tree in a file. Page 0 is a header which has fileversion and root page.
+type btree struct {
+	file *dataFile
+}
+
+//btreeNode is a decoded page. Internal nodes have one more value (child page) than keys.
+type btreeNode struct {
+	leaf   bool
+	keys   [][]byte
+	values []int64
+	next   int64
+}
+
+var (
+	ErrDuplicateKey   = errors.New("Duplicate key in unique index")
+	ErrIndexExist     = errors.New("Specified index exists")
+	ErrIndexNotExist  = errors.New("Specified index is not existed")
+	ErrColumnNotExist = errors.New("Specified column is not existed")
+	ErrTooLongKey     = errors.New("Too long key for index")
+)
+
+const (
+	btreePageSize   = 4096
+	btreeNodeHeader = 11
+	btreeMaxKeySize = 1000
+	btreeLeaf       = byte(1)
+	btreeInternal   = byte(2)
+)
+
+/*
+ indexedTable is a table which has secondary indexes.
+ Both TableStatic and TableDynamic implement it.
+*/
+type indexedTable interface {
+	createIndex(columns []string, unique bool) error
+	getIndex(columns []string) (*Index, error)
+}
+
+//CreateIndex builds a secondary index on columns of the table.
+func (self *Database) CreateIndex(tablename string, columns []string, unique bool) error {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return err
+	}
+	indexed, ok := table.(indexedTable)
+	if ok == false {
+		return ErrInvalidTabletype
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	return indexed.createIndex(columns, unique)
+}
+
+//GetIndex returns the index on columns of the table.
+func (self *Database) GetIndex(tablename string, columns []string) (*Index, error) {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return nil, err
+	}
+	indexed, ok := table.(indexedTable)
+	if ok == false {
+		return nil, ErrInvalidTabletype
+	}
+	lock := tableLock(table)
+	lock.RLock()
+	defer lock.RUnlock()
+	return indexed.getIndex(columns)
+}
+
+/*
+ Lookup returns row numbers whose columns are equal to vals.
+ When vals are fewer than columns, they are compared with the first columns.
+*/
+func (self *Index) Lookup(vals ...interface{}) ([]int64, error) {
+	if self.lock != nil {
+		self.lock.RLock()
+		defer self.lock.RUnlock()
+	}
+	return self.lookup(vals...)
+}
+
+//lookup is Lookup without locking the table. The lock of table must be held.
+func (self *Index) lookup(vals ...interface{}) ([]int64, error) {
+	prefix, err := self.encodeValues(vals)
+	if err != nil {
+		return nil, err
+	}
+	return self.tree.scan(prefix, append(prefix, 0xFF))
+}
+
+/*
+ Range returns row numbers whose columns are between from and to in order of the index.
+ Both ends are included. A nil end is unbounded.
+*/
+func (self *Index) Range(from []interface{}, to []interface{}) ([]int64, error) {
+	if self.lock != nil {
+		self.lock.RLock()
+		defer self.lock.RUnlock()
+	}
+	return self.rangeScan(from, to)
+}
+
+//rangeScan is Range without locking the table. The lock of table must be held.
+func (self *Index) rangeScan(from []interface{}, to []interface{}) ([]int64, error) {
+	lower := []byte{}
+	var upper []byte
+	var err error
+	if from != nil {
+		lower, err = self.encodeValues(from)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if to != nil {
+		upper, err = self.encodeValues(to)
+		if err != nil {
+			return nil, err
+		}
+		upper = append(upper, 0xFF)
+	}
+	return self.tree.scan(lower, upper)
+}
+
+//encodeValues converts values of the first columns to a key prefix.
+func (self *Index) encodeValues(vals []interface{}) ([]byte, error) {
+	if len(vals) > len(self.columnTypes) {
+		return nil, errors.New("Too many values for index " + self.Name)
+	}
+	result := []byte{}
+	for i, v := range vals {
+		b, err := self.columnTypes[i].ConvertToKey(v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, b...)
+	}
+	return result, nil
+}
+
+//keyOfRow returns a key of the row. Row number is appended so that keys are unique in the tree.
+func (self *Index) keyOfRow(row Row, rowNum int64) ([]byte, []byte, error) {
+	vals := make([]interface{}, len(self.Columns))
+	for i, name := range self.Columns {
+		vals[i] = row[name]
+	}
+	prefix, err := self.encodeValues(vals)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(prefix)+8 > btreeMaxKeySize {
+		return nil, nil, ErrTooLongKey
+	}
+	key := make([]byte, len(prefix)+8)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(rowNum))
+	return key, prefix, nil
+}
+
+/*
+ insert adds the row to the index.
+ Rows which have NULL in columns are not checked by unique index, and are refused by primary key.
+*/
+func (self *Index) insert(row Row, rowNum int64) error {
+	key, prefix, err := self.keyOfRow(row, rowNum)
+	if err != nil {
+		return err
+	}
+	hasNull := false
+	for _, name := range self.Columns {
+		if row[name] == nil {
+			hasNull = true
+		}
+	}
+	if hasNull && self.primary {
+		return ErrConstraintViolation
+	}
+	if self.Unique && hasNull == false {
+		rowNums, err := self.tree.scan(prefix, append(prefix, 0xFF))
+		if err != nil {
+			return err
+		}
+		for _, v := range rowNums {
+			if v != rowNum && self.constraint {
+				return ErrConstraintViolation
+			}
+			if v != rowNum {
+				return ErrDuplicateKey
+			}
+		}
+	}
+	return self.tree.insert(key, rowNum)
+}
+
+//remove deletes the row from the index.
+func (self *Index) remove(row Row, rowNum int64) error {
+	key, _, err := self.keyOfRow(row, rowNum)
+	if err != nil {
+		return err
+	}
+	return self.tree.remove(key)
+}
+
+func (self *Index) config() indexConfig {
+	return indexConfig{Name: self.Name, Columns: self.Columns, Unique: self.Unique}
+}
+
+//**************************************************
+
+//indexName returns the name of index on columns. It is used in the file name.
+func indexName(columns []string) string {
+	return strings.Join(columns, "-")
+}
+
+//indexFilename returns the file name of index.
+func indexFilename(directory string, tablename string, name string) string {
+	return directory + tablename + "." + name + ".btree"
+}
+
+//openIndexes opens index files written in table config. Files are opened read-only when readOnly is true.
+func openIndexes(directory string, tablename string, configs []indexConfig, columnTypes []ColumnType, readOnly bool) ([]*Index, error) {
+	result := []*Index{}
+	for _, c := range configs {
+		index, err := newIndex(c, columnTypes)
+		if err != nil {
+			closeIndexes(result)
+			return nil, err
+		}
+		flag := os.O_RDWR
+		if readOnly {
+			flag = os.O_RDONLY
+		}
+		f, err := os.OpenFile(indexFilename(directory, tablename, c.Name), flag, 0666)
+		if err != nil {
+			closeIndexes(result)
+			return nil, err
+		}
+		index.tree, err = openBtree(f)
+		if err != nil {
+			f.Close()
+			closeIndexes(result)
+			return nil, err
+		}
+		result = append(result, index)
+	}
+	return result, nil
+}
+
+func newIndex(c indexConfig, columnTypes []ColumnType) (*Index, error) {
+	if len(c.Columns) == 0 {
+		return nil, ErrColumnNotExist
+	}
+	result := &Index{}
+	result.Name = c.Name
+	result.Columns = c.Columns
+	result.Unique = c.Unique
+	for _, name := range c.Columns {
+		found := false
+		for _, v := range columnTypes {
+			if v.Name == name {
+				if v.Type == COLUMN_JSON || v.isArray() {
+					return nil, errors.New("Column type " + v.Type + " can not be indexed: " + name)
+				}
+				result.columnTypes = append(result.columnTypes, v)
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return nil, ErrColumnNotExist
+		}
+	}
+	return result, nil
+}
+
+/*
+ buildIndex creates an index file and adds all rows of table.
+ The file is written without write-ahead log because it is not used until config is saved.
+*/
+func buildIndex(directory string, tablename string, indexes []*Index, columns []string, unique bool, columnTypes []ColumnType, table rowReader) (*Index, error) {
+	name := indexName(columns)
+	for _, v := range indexes {
+		if v.Name == name {
+			return nil, ErrIndexExist
+		}
+	}
+	index, err := newIndex(indexConfig{Name: name, Columns: columns, Unique: unique}, columnTypes)
+	if err != nil {
+		return nil, err
+	}
+	filename := indexFilename(directory, tablename, name)
+	f, err := os.OpenFile(filename, os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	index.tree, err = openBtree(f)
+	if err != nil {
+		f.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+	it, err := table.scan(nil)
+	if err == nil {
+		for it.Next() {
+			err = index.insert(it.Row(), it.RowNum())
+			if err != nil {
+				break
+			}
+			err = index.tree.file.apply()
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = it.Err()
+		}
+		it.Close()
+	}
+	if err == nil {
+		err = index.tree.file.Sync()
+	}
+	if err != nil {
+		index.tree.file.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+	return index, nil
+}
+
+func findIndex(indexes []*Index, columns []string) (*Index, error) {
+	name := indexName(columns)
+	for _, v := range indexes {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, ErrIndexNotExist
+}
+
+//insertIndexes adds the row to all indexes.
+func insertIndexes(indexes []*Index, row Row, rowNum int64) error {
+	for _, v := range indexes {
+		err := v.insert(row, rowNum)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//removeIndexes deletes the row from all indexes.
+func removeIndexes(indexes []*Index, row Row, rowNum int64) error {
+	for _, v := range indexes {
+		err := v.remove(row, rowNum)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexFiles(indexes []*Index) []*dataFile {
+	result := []*dataFile{}
+	for _, v := range indexes {
+		result = append(result, v.tree.file)
+	}
+	return result
+}
+
+func indexConfigs(indexes []*Index) []indexConfig {
+	result := []indexConfig{}
+	for _, v := range indexes {
+		result = append(result, v.config())
+	}
+	return result
+}
+
+func closeIndexes(indexes []*Index) error {
+	for _, v := range indexes {
+		err := v.tree.file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//**************************************************
+
+//openBtree opens B+tree file. An empty file is initialized with an empty root leaf.
+func openBtree(f *os.File) (*btree, error) {
+	result := &btree{}
+	result.file = newDataFile(f)
+	b := make([]byte, binary.MaxVarintLen64)
+	_, err := result.file.ReadAt(b, 0)
+	if err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+		header := make([]byte, btreePageSize)
+		binary.PutVarint(header, BTREE1)
+		binary.PutVarint(header[binary.MaxVarintLen64:], 1)
+		result.file.WriteAt(header, 0)
+		err = result.writeNode(1, &btreeNode{leaf: true})
+		if err != nil {
+			return nil, err
+		}
+		err = commitFiles(nil, result.file)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	v, num := binary.Varint(b)
+	if num < 1 {
+		return nil, errors.New("Failed to read fileversion")
+	}
+	if v != BTREE1 {
+		return nil, errors.New("Fileversion is not correct")
+	}
+	return result, nil
+}
+
+func (self *btree) root() (int64, error) {
+	b := make([]byte, binary.MaxVarintLen64)
+	_, err := self.file.ReadAt(b, int64(binary.MaxVarintLen64))
+	if err != nil {
+		return -1, err
+	}
+	v, num := binary.Varint(b)
+	if num < 1 {
+		return -1, errors.New("Failed to read root of index")
+	}
+	return v, nil
+}
+
+func (self *btree) setRoot(page int64) error {
+	b := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(b, page)
+	_, err := self.file.WriteAt(b, int64(binary.MaxVarintLen64))
+	return err
+}
+
+func (self *btree) allocPage() (int64, error) {
+	size, err := self.file.Size()
+	if err != nil {
+		return -1, err
+	}
+	return (size + btreePageSize - 1) / btreePageSize, nil
+}
+
+func (self *btree) readNode(page int64) (*btreeNode, error) {
+	b := make([]byte, btreePageSize)
+	_, err := self.file.ReadAt(b, page*btreePageSize)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBtreeNode(b)
+}
+
+func (self *btree) writeNode(page int64, node *btreeNode) error {
+	b, err := node.encode()
+	if err != nil {
+		return err
+	}
+	_, err = self.file.WriteAt(b, page*btreePageSize)
+	return err
+}
+
+//insert adds key. When the key exists, nothing is changed.
+func (self *btree) insert(key []byte, value int64) error {
+	root, err := self.root()
+	if err != nil {
+		return err
+	}
+	splitKey, newPage, err := self.insertAt(root, key, value)
+	if err != nil {
+		return err
+	}
+	if newPage == 0 {
+		return nil
+	}
+	node := &btreeNode{leaf: false, keys: [][]byte{splitKey}, values: []int64{root, newPage}}
+	page, err := self.allocPage()
+	if err != nil {
+		return err
+	}
+	err = self.writeNode(page, node)
+	if err != nil {
+		return err
+	}
+	return self.setRoot(page)
+}
+
+//insertAt adds key under the page. When the page is split, returns the first key and the page of the new node.
+func (self *btree) insertAt(page int64, key []byte, value int64) ([]byte, int64, error) {
+	node, err := self.readNode(page)
+	if err != nil {
+		return nil, 0, err
+	}
+	if node.leaf {
+		i := sort.Search(len(node.keys), func(i int) bool { return bytes.Compare(node.keys[i], key) >= 0 })
+		if i < len(node.keys) && bytes.Equal(node.keys[i], key) {
+			return nil, 0, nil
+		}
+		node.keys = insertKey(node.keys, i, key)
+		node.values = insertValue(node.values, i, value)
+	} else {
+		i := node.childIndex(key)
+		splitKey, newPage, err := self.insertAt(node.values[i], key, value)
+		if err != nil || newPage == 0 {
+			return nil, 0, err
+		}
+		node.keys = insertKey(node.keys, i, splitKey)
+		node.values = insertValue(node.values, i+1, newPage)
+	}
+	if node.size() <= btreePageSize {
+		return nil, 0, self.writeNode(page, node)
+	}
+
+	newPage, err := self.allocPage()
+	if err != nil {
+		return nil, 0, err
+	}
+	m := node.splitPoint()
+	right := &btreeNode{leaf: node.leaf}
+	var splitKey []byte
+	if node.leaf {
+		right.keys = append([][]byte{}, node.keys[m:]...)
+		right.values = append([]int64{}, node.values[m:]...)
+		right.next = node.next
+		node.keys = node.keys[:m]
+		node.values = node.values[:m]
+		node.next = newPage
+		splitKey = right.keys[0]
+	} else {
+		splitKey = node.keys[m]
+		right.keys = append([][]byte{}, node.keys[m+1:]...)
+		right.values = append([]int64{}, node.values[m+1:]...)
+		node.keys = node.keys[:m]
+		node.values = node.values[:m+1]
+	}
+	err = self.writeNode(newPage, right)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = self.writeNode(page, node)
+	if err != nil {
+		return nil, 0, err
+	}
+	return splitKey, newPage, nil
+}
+
+//remove deletes key from its leaf. Nodes are not merged.
+func (self *btree) remove(key []byte) error {
+	page, err := self.findLeaf(key)
+	if err != nil {
+		return err
+	}
+	node, err := self.readNode(page)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(node.keys), func(i int) bool { return bytes.Compare(node.keys[i], key) >= 0 })
+	if i >= len(node.keys) || bytes.Equal(node.keys[i], key) == false {
+		return nil
+	}
+	node.keys = append(node.keys[:i], node.keys[i+1:]...)
+	node.values = append(node.values[:i], node.values[i+1:]...)
+	return self.writeNode(page, node)
+}
+
+//findLeaf returns the leaf page where key is or should be.
+func (self *btree) findLeaf(key []byte) (int64, error) {
+	page, err := self.root()
+	if err != nil {
+		return -1, err
+	}
+	for {
+		node, err := self.readNode(page)
+		if err != nil {
+			return -1, err
+		}
+		if node.leaf {
+			return page, nil
+		}
+		page = node.values[node.childIndex(key)]
+	}
+}
+
+//scan returns values of keys from lower (included) to upper (excluded). A nil upper is unbounded.
+func (self *btree) scan(lower []byte, upper []byte) ([]int64, error) {
+	result := []int64{}
+	page, err := self.findLeaf(lower)
+	if err != nil {
+		return nil, err
+	}
+	for page != 0 {
+		node, err := self.readNode(page)
+		if err != nil {
+			return nil, err
+		}
+		for i, k := range node.keys {
+			if bytes.Compare(k, lower) < 0 {
+				continue
+			}
+			if upper != nil && bytes.Compare(k, upper) >= 0 {
+				return result, nil
+			}
+			result = append(result, node.values[i])
+		}
+		page = node.next
+	}
+	return result, nil
+}
+
+//childIndex returns the index of child which covers key.
+func (self *btreeNode) childIndex(key []byte) int {
+	return sort.Search(len(self.keys), func(i int) bool { return bytes.Compare(self.keys[i], key) > 0 })
+}
+
+func (self *btreeNode) size() int {
+	result := btreeNodeHeader
+	for _, k := range self.keys {
+		result += 2 + len(k) + 8
+	}
+	return result
+}
+
+//splitPoint returns the position which divides the node into halves by bytes.
+func (self *btreeNode) splitPoint() int {
+	half := self.size() / 2
+	total := btreeNodeHeader
+	for i, k := range self.keys {
+		total += 2 + len(k) + 8
+		if total > half {
+			if i < 1 {
+				return 1
+			}
+			if i >= len(self.keys)-1 {
+				return len(self.keys) - 1
+			}
+			return i
+		}
+	}
+	return len(self.keys) / 2
+}
+
+/*
+ encode converts node to a page.
+ Page: type(1 byte), number of keys(2 bytes), next leaf or first child(8 bytes), entries.
+ Entry: key size(2 bytes), key, row number or child page(8 bytes).
+*/
+func (self *btreeNode) encode() ([]byte, error) {
+	if self.size() > btreePageSize {
+		return nil, errors.New("Too large index node")
+	}
+	b := make([]byte, btreePageSize)
+	binary.LittleEndian.PutUint16(b[1:], uint16(len(self.keys)))
+	values := self.values
+	if self.leaf {
+		b[0] = btreeLeaf
+		binary.LittleEndian.PutUint64(b[3:], uint64(self.next))
+	} else {
+		b[0] = btreeInternal
+		binary.LittleEndian.PutUint64(b[3:], uint64(self.values[0]))
+		values = self.values[1:]
+	}
+	off := btreeNodeHeader
+	for i, k := range self.keys {
+		binary.LittleEndian.PutUint16(b[off:], uint16(len(k)))
+		off += 2
+		off += copy(b[off:], k)
+		binary.LittleEndian.PutUint64(b[off:], uint64(values[i]))
+		off += 8
+	}
+	return b, nil
+}
+
+func decodeBtreeNode(b []byte) (*btreeNode, error) {
+	if len(b) < btreeNodeHeader {
+		return nil, errors.New("Failed to read index node")
+	}
+	result := &btreeNode{}
+	count := int(binary.LittleEndian.Uint16(b[1:]))
+	first := int64(binary.LittleEndian.Uint64(b[3:]))
+	if b[0] == btreeLeaf {
+		result.leaf = true
+		result.next = first
+	} else if b[0] == btreeInternal {
+		result.values = append(result.values, first)
+	} else {
+		return nil, errors.New("Failed to read index node")
+	}
+	off := btreeNodeHeader
+	for i := 0; i < count; i++ {
+		if off+2 > len(b) {
+			return nil, errors.New("Failed to read index node")
+		}
+		size := int(binary.LittleEndian.Uint16(b[off:]))
+		off += 2
+		if off+size+8 > len(b) {
+			return nil, errors.New("Failed to read index node")
+		}
+		key := make([]byte, size)
+		copy(key, b[off:off+size])
+		off += size
+		result.keys = append(result.keys, key)
+		result.values = append(result.values, int64(binary.LittleEndian.Uint64(b[off:])))
+		off += 8
+	}
+	return result, nil
+}
+
+func insertKey(keys [][]byte, i int, key []byte) [][]byte {
+	keys = append(keys, nil)
+	copy(keys[i+1:], keys[i:])
+	keys[i] = key
+	return keys
+}
+
+func insertValue(values []int64, i int, value int64) []int64 {
+	values = append(values, 0)
+	copy(values[i+1:], values[i:])
+	values[i] = value
+	return values
+}
@@ -0,0 +1,293 @@
+package tinydatabase
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+)
+
+func Test1_btree_splitAndScan(t *testing.T) {
+	directory := "./testdata/"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	f, err := os.OpenFile(directory+"test.btree", os.O_RDWR+os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("Failed to create file: %s", err)
+	}
+	tree, err := openBtree(f)
+	if err != nil {
+		t.Fatalf("Failed to open btree: %s", err)
+	}
+	defer tree.file.Close()
+
+	//Keys are inserted in shuffled order and split pages many times.
+	num := 5000
+	for i := 0; i < num; i++ {
+		v := int64((i * 7919) % num)
+		key := make([]byte, 8, 108)
+		binary.BigEndian.PutUint64(key, uint64(v))
+		key = append(key, make([]byte, 100)...)
+		err = tree.insert(key, v)
+		if err != nil {
+			t.Fatalf("Failed to insert key %d: %s", v, err)
+		}
+		if i%100 == 0 {
+			err = commitFiles(nil, tree.file)
+			if err != nil {
+				t.Fatalf("Failed to commit: %s", err)
+			}
+		}
+	}
+	err = commitFiles(nil, tree.file)
+	if err != nil {
+		t.Fatalf("Failed to commit: %s", err)
+	}
+	root, _ := tree.root()
+	if root == 1 {
+		t.Errorf("Failed to split root")
+	}
+
+	values, err := tree.scan([]byte{}, nil)
+	if err != nil || len(values) != num {
+		t.Fatalf("Failed to scan all keys: %d, %v", len(values), err)
+	}
+	if sort.SliceIsSorted(values, func(i, j int) bool { return values[i] < values[j] }) == false {
+		t.Errorf("Failed to keep keys in order")
+	}
+
+	lower := make([]byte, 8)
+	binary.BigEndian.PutUint64(lower, 100)
+	upper := make([]byte, 8)
+	binary.BigEndian.PutUint64(upper, 200)
+	values, err = tree.scan(lower, upper)
+	if err != nil || len(values) != 100 || values[0] != 100 || values[99] != 199 {
+		t.Errorf("Failed to scan range: %v, %v", values, err)
+	}
+
+	for i := int64(100); i < 200; i++ {
+		key := make([]byte, 108)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		err = tree.remove(key)
+		if err != nil {
+			t.Errorf("Failed to remove key: %s", err)
+		}
+	}
+	values, _ = tree.scan(lower, upper)
+	if len(values) != 0 {
+		t.Errorf("Failed to remove keys: %v", values)
+	}
+	values, _ = tree.scan([]byte{}, nil)
+	if len(values) != num-100 {
+		t.Errorf("Failed to keep other keys: %d", len(values))
+	}
+}
+
+func Test2_ColumnType_ConvertToKey(t *testing.T) {
+	column := ColumnType{Name: "c", Type: COLUMN_INT64}
+	prev := []byte{}
+	for _, v := range []int64{-1 << 62, -10, -1, 0, 1, 10, 1 << 62} {
+		key, err := column.ConvertToKey(v)
+		if err != nil || string(prev) >= string(key) {
+			t.Errorf("Failed to keep order of int64: %d, %v", v, err)
+		}
+		prev = key
+	}
+	column = ColumnType{Name: "c", Type: COLUMN_FLOAT64}
+	prev = []byte{}
+	for _, v := range []float64{-1e10, -1.5, -0.5, 0, 0.5, 1.5, 1e10} {
+		key, err := column.ConvertToKey(v)
+		if err != nil || string(prev) >= string(key) {
+			t.Errorf("Failed to keep order of float64: %f, %v", v, err)
+		}
+		prev = key
+	}
+	column = ColumnType{Name: "c", Type: COLUMN_STRING}
+	prev = []byte{}
+	for _, v := range []string{"", "a", "a b", "ab", "b", "ba"} {
+		key, err := column.ConvertToKey(v)
+		if err != nil || string(prev) >= string(key) {
+			t.Errorf("Failed to keep order of string: %q, %v", v, err)
+		}
+		prev = key
+	}
+	column = ColumnType{Name: "c", Type: COLUMN_TIME}
+	prev = []byte{}
+	for _, v := range []string{"0001-01-01T00:00:00Z", "1900-01-01T00:00:00Z", "2000-01-01T00:00:00.5Z", "2000-01-01T09:00:01+09:00"} {
+		key, err := column.ConvertToKey(v)
+		if err != nil || string(prev) >= string(key) {
+			t.Errorf("Failed to keep order of time: %s, %v", v, err)
+		}
+		prev = key
+	}
+}
+
+func Test3_Index_maintenance(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		size := int64(16)
+		if tabletype == "dynamic" {
+			size = 0
+		}
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "id", Type: COLUMN_INT64, Size: 64},
+			{Name: "customer", Type: COLUMN_STRING, Size: size},
+			{Name: "price", Type: COLUMN_FLOAT64, Size: 64},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		for i := 0; i < 10; i++ {
+			_, err = table.WriteRow(Row{"id": int64(i), "customer": fmt.Sprintf("c%d", i%3), "price": float64(i)})
+			if err != nil {
+				t.Errorf("Failed to insert row: %s", err)
+			}
+		}
+		err = db.CreateIndex(tabletype, []string{"id"}, true)
+		if err != nil {
+			t.Errorf("Failed to create unique index: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"customer", "price"}, false)
+		if err != nil {
+			t.Errorf("Failed to create composite index: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"customer"}, true)
+		if err != ErrDuplicateKey {
+			t.Errorf("Failed to refuse unique index on duplicate values: %v", err)
+		}
+		_, err = os.Stat(directoryJson + "database1/" + tabletype + ".customer.btree")
+		if os.IsNotExist(err) == false {
+			t.Errorf("Failed to remove index file of failed index: %v", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"id"}, false)
+		if err != ErrIndexExist {
+			t.Errorf("Failed to refuse same index: %v", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"nocolumn"}, false)
+		if err != ErrColumnNotExist {
+			t.Errorf("Failed to check column name: %v", err)
+		}
+
+		_, err = table.WriteRow(Row{"id": int64(3), "customer": "dup"})
+		if err != ErrDuplicateKey {
+			t.Errorf("Failed to refuse duplicate key: %v", err)
+		}
+		count, _ := table.CountRows()
+		if count != 10 {
+			t.Errorf("Failed to roll back row of duplicate key: %d", count)
+		}
+		err = table.UpdateRow(4, Row{"id": int64(40), "customer": "c1", "price": 4.5})
+		if err != nil {
+			t.Errorf("Failed to update row: %s", err)
+		}
+		err = table.DeleteRow(5)
+		if err != nil {
+			t.Errorf("Failed to delete row: %s", err)
+		}
+
+		index, err := db.GetIndex(tabletype, []string{"id"})
+		if err != nil {
+			t.Fatalf("Failed to get index: %s", err)
+		}
+		rows, err := index.Lookup(40)
+		if err != nil || len(rows) != 1 || rows[0] != 4 {
+			t.Errorf("Failed to look up updated row: %v, %v", rows, err)
+		}
+		rows, _ = index.Lookup(4)
+		if len(rows) != 0 {
+			t.Errorf("Failed to remove old key: %v", rows)
+		}
+		rows, err = index.Range([]interface{}{2}, []interface{}{7})
+		if err != nil || fmt.Sprint(rows) != "[2 3 6 7]" {
+			t.Errorf("Failed to look up range: %v, %v", rows, err)
+		}
+		rows, _ = index.Range(nil, []interface{}{1})
+		if fmt.Sprint(rows) != "[0 1]" {
+			t.Errorf("Failed to look up range without lower end: %v", rows)
+		}
+
+		index, err = db.GetIndex(tabletype, []string{"customer", "price"})
+		if err != nil {
+			t.Fatalf("Failed to get index: %s", err)
+		}
+		rows, _ = index.Lookup("c1")
+		if fmt.Sprint(rows) != "[1 4 7]" {
+			t.Errorf("Failed to look up prefix of composite index: %v", rows)
+		}
+		rows, _ = index.Range([]interface{}{"c1", 2.0}, []interface{}{"c2", 2.0})
+		if fmt.Sprint(rows) != "[4 7 2]" {
+			t.Errorf("Failed to look up range of composite index: %v", rows)
+		}
+		_, err = db.GetIndex(tabletype, []string{"price"})
+		if err != ErrIndexNotExist {
+			t.Errorf("Failed to check index: %v", err)
+		}
+
+		//Indexes follow writes of transaction.
+		tx, _ := db.Begin()
+		rowNum, err := tx.WriteRow(tabletype, Row{"id": int64(100), "customer": "tx"})
+		if err != nil {
+			t.Errorf("Failed to write row in transaction: %s", err)
+		}
+		_, err = tx.WriteRow(tabletype, Row{"id": int64(100), "customer": "tx2"})
+		if err != ErrDuplicateKey {
+			t.Errorf("Failed to refuse duplicate key in transaction: %v", err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			t.Errorf("Failed to commit: %s", err)
+		}
+		index, _ = db.GetIndex(tabletype, []string{"id"})
+		rows, _ = index.Lookup(100)
+		if len(rows) != 1 || rows[0] != rowNum {
+			t.Errorf("Failed to index row of transaction: %v", rows)
+		}
+		tx, _ = db.Begin()
+		tx.WriteRow(tabletype, Row{"id": int64(101)})
+		tx.Rollback()
+		rows, _ = index.Lookup(101)
+		if len(rows) != 0 {
+			t.Errorf("Failed to roll back index: %v", rows)
+		}
+	}
+	err = db.Compact("dynamic")
+	if err != nil {
+		t.Errorf("Failed to compact table: %s", err)
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ = dbList.Get("database1")
+	for _, tabletype := range []string{"static", "dynamic"} {
+		index, err := db.GetIndex(tabletype, []string{"customer", "price"})
+		if err != nil {
+			t.Fatalf("Failed to load index: %s", err)
+		}
+		rows, _ := index.Lookup("c2")
+		if fmt.Sprint(rows) != "[2 8]" {
+			t.Errorf("Failed to look up loaded index: %v", rows)
+		}
+		table, _ := db.GetTable(tabletype)
+		_, err = table.WriteRow(Row{"id": int64(0)})
+		if err != ErrDuplicateKey {
+			t.Errorf("Failed to check loaded unique index: %v", err)
+		}
+	}
+	dbList.Close()
+}
@@ -0,0 +1,383 @@
+package tinydatabase
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+/*
+ catalogJournal records dropping or renaming of a table or a database.
+ It is written before any file is changed. When it is found on loading,
+ the operation is finished, so the config file and the files agree after a crash.
+*/
+type catalogJournal struct {
+	Op      string
+	Name    string
+	NewName string
+}
+
+const (
+	journalDrop   string = "drop"
+	journalRename string = "rename"
+)
+
+//journalFilename is a file name of catalogJournal in the directory of DatabaseList or Database.
+const journalFilename = "catalog.journal"
+
+/*
+ DropDatabase func closes the database and removes its directory.
+ All tables of the database are dropped.
+*/
+func (self *DatabaseList) DropDatabase(name string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+	db, ok := self.Databases[name]
+	if ok == false {
+		return ErrDatabaseNotExist
+	}
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+	delete(self.Databases, name)
+	return self.commitJournal(&catalogJournal{Op: journalDrop, Name: name})
+}
+
+/*
+ RenameDatabase func renames the database and moves its directory.
+ The Database of the old name is reopened with the new name.
+*/
+func (self *DatabaseList) RenameDatabase(name string, newName string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	db, ok := self.Databases[name]
+	if ok == false {
+		return ErrDatabaseNotExist
+	}
+	_, ok = self.Databases[newName]
+	if ok == true || newName == "" {
+		return ErrDatabaseExist
+	}
+	err := checkName(newName)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(self.directory + "/" + newName)
+	if err == nil {
+		return ErrDatabaseExist
+	}
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+	err = self.commitJournal(&catalogJournal{Op: journalRename, Name: name, NewName: newName})
+	if err != nil {
+		return err
+	}
+	delete(self.Databases, name)
+	self.Databases[newName] = db
+	return db.Load(self.directory+"/"+newName, self.filetype)
+}
+
+//commitJournal writes journal and finishes the operation.
+func (self *DatabaseList) commitJournal(journal *catalogJournal) error {
+	err := writeJournal(self.directory, journal)
+	if err != nil {
+		return err
+	}
+	return self.recoverJournal()
+}
+
+/*
+ recoverJournal finishes dropping or renaming of a database.
+ Each step can be repeated, so it is safe to run again after a crash.
+*/
+func (self *DatabaseList) recoverJournal() error {
+	journal, err := readJournal(self.directory)
+	if err != nil || journal == nil {
+		return err
+	}
+	dbNameList, err := self.loadNames()
+	if err != nil {
+		return err
+	}
+	result := []string{}
+	switch journal.Op {
+	case journalDrop:
+		err = os.RemoveAll(self.directory + "/" + journal.Name)
+		if err != nil {
+			return err
+		}
+		for _, v := range dbNameList {
+			if v != journal.Name {
+				result = append(result, v)
+			}
+		}
+	case journalRename:
+		_, err = os.Stat(self.directory + "/" + journal.Name)
+		if err == nil {
+			err = os.Rename(self.directory+"/"+journal.Name, self.directory+"/"+journal.NewName)
+			if err != nil {
+				return err
+			}
+		}
+		for _, v := range dbNameList {
+			if v == journal.Name {
+				v = journal.NewName
+			}
+			result = append(result, v)
+		}
+	default:
+		return ErrNotImplemented
+	}
+	err = syncDir(self.directory)
+	if err != nil {
+		return err
+	}
+	err = self.saveNames(result)
+	if err != nil {
+		return err
+	}
+	return removeJournal(self.directory)
+}
+
+/*
+ DropTable func closes the table and removes its files.
+ Index files of the table are also removed.
+*/
+func (self *Database) DropTable(tablename string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	err := checkName(tablename)
+	if err != nil {
+		return err
+	}
+	_, err = self.closeTable(tablename)
+	if err != nil {
+		return err
+	}
+	delete(self.tables, tablename)
+	return self.commitJournal(&catalogJournal{Op: journalDrop, Name: tablename})
+}
+
+/*
+ RenameTable func renames the table and its files.
+ The table is reopened with the new name.
+*/
+func (self *Database) RenameTable(tablename string, newName string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	_, ok := self.tables[newName]
+	if ok == true || newName == "" {
+		return ErrTableExist
+	}
+	err := checkName(newName)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(self.directory + "/" + newName + ".config")
+	if err == nil {
+		return ErrTableExist
+	}
+	table, err := self.closeTable(tablename)
+	if err != nil {
+		return err
+	}
+	err = self.commitJournal(&catalogJournal{Op: journalRename, Name: tablename, NewName: newName})
+	if err != nil {
+		return err
+	}
+	delete(self.tables, tablename)
+	err = table.Open(self.directory, newName)
+	if err != nil {
+		return err
+	}
+	self.setWriteAheadLog(table)
+	self.tables[newName] = table
+	return nil
+}
+
+/*
+ closeTable closes the table for dropping or renaming. A table in a transaction is not closed.
+ The check and closing are done under the lock of table, so a transaction can not start between them.
+ The lock of database must be held.
+*/
+func (self *Database) closeTable(tablename string) (TableInterface, error) {
+	table, err := self.getTable(tablename)
+	if err != nil {
+		return nil, err
+	}
+	closable, ok := table.(interface {
+		close() error
+	})
+	if ok == false {
+		return nil, ErrInvalidTabletype
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	txT, ok := table.(txTable)
+	if ok == true && txT.getTransaction() != nil {
+		return nil, ErrTableInTx
+	}
+	err = closable.close()
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+//commitJournal writes journal and finishes the operation.
+func (self *Database) commitJournal(journal *catalogJournal) error {
+	err := writeJournal(self.directory, journal)
+	if err != nil {
+		return err
+	}
+	return self.recoverJournal()
+}
+
+/*
+ recoverJournal finishes dropping or renaming of a table.
+ Each step can be repeated, so it is safe to run again after a crash.
+*/
+func (self *Database) recoverJournal() error {
+	journal, err := readJournal(self.directory)
+	if err != nil || journal == nil {
+		return err
+	}
+	tableNameMap, err := self.loadTableNames()
+	if err != nil {
+		return err
+	}
+	directory := self.directory + "/"
+	switch journal.Op {
+	case journalDrop:
+		config, _ := loadTableConfig(directory + journal.Name + ".config")
+		for _, filename := range tableFilenames(directory, journal.Name, config) {
+			err = os.Remove(filename)
+			if err != nil && os.IsNotExist(err) == false {
+				return err
+			}
+		}
+		delete(tableNameMap, journal.Name)
+	case journalRename:
+		//The config file may have been renamed already.
+		config, err := loadTableConfig(directory + journal.Name + ".config")
+		if err != nil {
+			config, _ = loadTableConfig(directory + journal.NewName + ".config")
+		}
+		newFilenames := tableFilenames(directory, journal.NewName, config)
+		for i, filename := range tableFilenames(directory, journal.Name, config) {
+			_, err = os.Stat(filename)
+			if err == nil {
+				err = os.Rename(filename, newFilenames[i])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		tabletype, ok := tableNameMap[journal.Name]
+		if ok == true {
+			delete(tableNameMap, journal.Name)
+			tableNameMap[journal.NewName] = tabletype
+		}
+	default:
+		return ErrNotImplemented
+	}
+	err = syncDir(self.directory)
+	if err != nil {
+		return err
+	}
+	err = self.saveTableNames(tableNameMap)
+	if err != nil {
+		return err
+	}
+	return removeJournal(self.directory)
+}
+
+//**************************************************
+
+/*
+ tableFilenames returns names of all files which a table can have.
+ Index files are listed from config. config can be nil when it can not be read.
+*/
+func tableFilenames(directory string, tablename string, config *tableConfig) []string {
+	result := []string{}
+	for _, ext := range []string{".config", ".table", ".index", ".free"} {
+		result = append(result, directory+tablename+ext)
+	}
+	result = append(result, recoveryFilenames(directory, tablename)...)
+	if config != nil {
+		for _, v := range config.Indexes {
+			result = append(result, indexFilename(directory, tablename, v.Name))
+		}
+	}
+	return result
+}
+
+//recoveryFilenames returns names of files which are left by a crash and recovered when the table is opened.
+func recoveryFilenames(directory string, tablename string) []string {
+	result := []string{}
+	exts := []string{".compact", ".table.compact", ".index.compact",
+		alterSuffix, ".config" + alterSuffix, ".table" + alterSuffix, ".index" + alterSuffix,
+		".config" + tempSuffix, ".config" + alterSuffix + tempSuffix}
+	for _, ext := range exts {
+		result = append(result, directory+tablename+ext)
+	}
+	return result
+}
+
+//writeJournal writes journal in directory.
+func writeJournal(directory string, journal *catalogJournal) error {
+	b, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(directory+"/"+journalFilename, b)
+}
+
+//readJournal reads journal in directory. Returns nil when there is no journal.
+func readJournal(directory string) (*catalogJournal, error) {
+	err := recoverFileAtomic(directory + "/" + journalFilename)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(directory + "/" + journalFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result := &catalogJournal{}
+	err = json.Unmarshal(b, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func removeJournal(directory string) error {
+	err := os.Remove(directory + "/" + journalFilename)
+	if err != nil {
+		return err
+	}
+	return syncDir(directory)
+}
@@ -0,0 +1,205 @@
+package tinydatabase
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test1_Catalog_dropRename(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	_, err = dbList.NewDatabase("database2")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		table.WriteRow(Row{"a": int64(1)})
+		err = db.CreateIndex(tabletype, []string{"a"}, false)
+		if err != nil {
+			t.Fatalf("Failed to create index: %s", err)
+		}
+	}
+
+	err = db.RenameTable("static", "dynamic")
+	if err != ErrTableExist {
+		t.Errorf("Failed to refuse existing name: %v", err)
+	}
+	for _, name := range []string{"../escaped", "a/b", "a\\b", "..", "."} {
+		err = db.RenameTable("static", name)
+		if err != ErrInvalidName {
+			t.Errorf("Failed to refuse invalid table name %s: %v", name, err)
+		}
+		err = dbList.RenameDatabase("database2", name)
+		if err != ErrInvalidName {
+			t.Errorf("Failed to refuse invalid database name %s: %v", name, err)
+		}
+		_, err = db.NewTable(name, "static", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != ErrInvalidName {
+			t.Errorf("Failed to refuse invalid new table name %s: %v", name, err)
+		}
+	}
+	err = db.DropTable("../database2")
+	if err != ErrInvalidName {
+		t.Errorf("Failed to refuse invalid table name to drop: %v", err)
+	}
+	_, err = os.Stat(directoryJson + "escaped")
+	if err == nil {
+		t.Errorf("Failed to keep table in directory of database")
+	}
+	tx, _ := db.Begin()
+	tx.WriteRow("static", Row{"a": int64(2)})
+	err = db.DropTable("static")
+	if err != ErrTableInTx {
+		t.Errorf("Failed to refuse table in transaction: %v", err)
+	}
+	tx.Rollback()
+
+	err = db.RenameTable("static", "renamed")
+	if err != nil {
+		t.Fatalf("Failed to rename table: %s", err)
+	}
+	_, err = db.GetTable("static")
+	if err != ErrTableNotExist {
+		t.Errorf("Failed to remove old name: %v", err)
+	}
+	table, err := db.GetTable("renamed")
+	if err != nil {
+		t.Fatalf("Failed to get renamed table: %s", err)
+	}
+	_, err = table.WriteRow(Row{"a": int64(2)})
+	if err != nil {
+		t.Errorf("Failed to write row to renamed table: %s", err)
+	}
+	index, err := db.GetIndex("renamed", []string{"a"})
+	if err != nil {
+		t.Fatalf("Failed to get index of renamed table: %s", err)
+	}
+	rows, err := index.Lookup(int64(2))
+	if err != nil || len(rows) != 1 || rows[0] != 1 {
+		t.Errorf("Failed to look up renamed table: %v, %v", rows, err)
+	}
+
+	err = db.DropTable("dynamic")
+	if err != nil {
+		t.Fatalf("Failed to drop table: %s", err)
+	}
+	for _, ext := range []string{".config", ".table", ".index", ".a.btree"} {
+		_, err = os.Stat(directoryJson + "database1/dynamic" + ext)
+		if os.IsNotExist(err) == false {
+			t.Errorf("Failed to remove file %s: %v", ext, err)
+		}
+	}
+
+	err = dbList.RenameDatabase("database1", "database3")
+	if err != nil {
+		t.Fatalf("Failed to rename database: %s", err)
+	}
+	err = dbList.DropDatabase("database2")
+	if err != nil {
+		t.Fatalf("Failed to drop database: %s", err)
+	}
+	_, err = os.Stat(directoryJson + "database2")
+	if os.IsNotExist(err) == false {
+		t.Errorf("Failed to remove directory: %v", err)
+	}
+	//The renamed database is still usable.
+	_, err = db.NewTable("dynamic", "dynamic", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+	if err != nil {
+		t.Errorf("Failed to create table with dropped name: %s", err)
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	if len(dbList.Databases) != 1 {
+		t.Errorf("Failed to save databases: %v", dbList.Databases)
+	}
+	db, err = dbList.Get("database3")
+	if err != nil {
+		t.Fatalf("Failed to get renamed database: %s", err)
+	}
+	table, err = db.GetTable("renamed")
+	if err != nil {
+		t.Fatalf("Failed to load renamed table: %s", err)
+	}
+	row, err := table.ReadRow(1)
+	if err != nil || row["a"] != int64(2) {
+		t.Errorf("Failed to read row: %v, %v", row, err)
+	}
+	table, err = db.GetTable("dynamic")
+	if err != nil {
+		t.Fatalf("Failed to load new table: %s", err)
+	}
+	count, err := table.CountRows()
+	if err != nil || count != 0 {
+		t.Errorf("Failed to create table with dropped name: %d, %v", count, err)
+	}
+}
+
+func Test2_Catalog_recover(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	table, err := db.NewTable("table1", "dynamic", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	table.WriteRow(Row{"a": int64(1)})
+	dbList.Close()
+
+	//Renaming stopped after the config file was moved is finished on loading.
+	os.Rename(directoryJson+"database1/table1.config", directoryJson+"database1/table2.config")
+	ioutil.WriteFile(directoryJson+"database1/"+journalFilename, []byte("{\"Op\":\"rename\",\"Name\":\"table1\",\"NewName\":\"table2\"}"), 0666)
+	ioutil.WriteFile(directoryJson+journalFilename, []byte("{\"Op\":\"rename\",\"Name\":\"database1\",\"NewName\":\"database2\"}"), 0666)
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, err = dbList.Get("database2")
+	if err != nil {
+		t.Fatalf("Failed to finish renaming database: %s", err)
+	}
+	table, err = db.GetTable("table2")
+	if err != nil {
+		t.Fatalf("Failed to finish renaming table: %s", err)
+	}
+	row, err := table.ReadRow(0)
+	if err != nil || row["a"] != int64(1) {
+		t.Errorf("Failed to read row: %v, %v", row, err)
+	}
+	for _, filename := range []string{journalFilename, "database2/" + journalFilename, "database2/table1.table"} {
+		_, err = os.Stat(directoryJson + filename)
+		if os.IsNotExist(err) == false {
+			t.Errorf("Failed to finish journal %s: %v", filename, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package tinydatabase
+
+import (
+	"errors"
+)
+
+/*
+ constraints are primary key and unique constraints of a table.
+ Each constraint is kept by a unique index on its columns.
+*/
+type constraints struct {
+	primaryKey []string
+	uniques    [][]string
+}
+
+/*
+ constrainedTable is a table which has constraints.
+ Both TableStatic and TableDynamic implement it.
+*/
+type constrainedTable interface {
+	addConstraint(columns []string, primary bool) error
+	readRowByKey(key []interface{}) (Row, int64, error)
+}
+
+var (
+	ErrConstraintViolation = errors.New("Constraint violation")
+	ErrConstraintExist     = errors.New("Specified constraint exists")
+	ErrNoPrimaryKey        = errors.New("Table has no primary key")
+	ErrKeyNotFound         = errors.New("Specified key is not found")
+)
+
+/*
+ SetPrimaryKey func makes columns the primary key of the table.
+ Rows in the table must already be unique on the columns.
+*/
+func (self *Database) SetPrimaryKey(tablename string, columns []string) error {
+	table, err := self.constrainedTable(tablename)
+	if err != nil {
+		return err
+	}
+	lock := tableLock(table.(TableInterface))
+	lock.Lock()
+	defer lock.Unlock()
+	return table.addConstraint(columns, true)
+}
+
+/*
+ AddUnique func adds a unique constraint on columns of the table.
+ With several columns, the combination of them must be unique.
+*/
+func (self *Database) AddUnique(tablename string, columns []string) error {
+	table, err := self.constrainedTable(tablename)
+	if err != nil {
+		return err
+	}
+	lock := tableLock(table.(TableInterface))
+	lock.Lock()
+	defer lock.Unlock()
+	return table.addConstraint(columns, false)
+}
+
+//ReadRowByKey func reads the row whose primary key is key, and returns it with its row number.
+func (self *Database) ReadRowByKey(tablename string, key ...interface{}) (Row, int64, error) {
+	table, err := self.constrainedTable(tablename)
+	if err != nil {
+		return nil, -1, err
+	}
+	lock := tableLock(table.(TableInterface))
+	lock.RLock()
+	defer lock.RUnlock()
+	return table.readRowByKey(key)
+}
+
+func (self *Database) constrainedTable(tablename string) (constrainedTable, error) {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := table.(constrainedTable)
+	if ok == false {
+		return nil, ErrInvalidTabletype
+	}
+	return result, nil
+}
+
+//**************************************************
+
+//newConstraints returns constraints written in table config.
+func newConstraints(config *tableConfig) constraints {
+	result := constraints{}
+	result.primaryKey = config.PrimaryKey
+	result.uniques = config.Unique
+	return result
+}
+
+//save writes constraints on table config.
+func (self *constraints) save(config *tableConfig) {
+	config.PrimaryKey = self.primaryKey
+	config.Unique = self.uniques
+}
+
+//all returns columns of all constraints.
+func (self *constraints) all() [][]string {
+	result := [][]string{}
+	if len(self.primaryKey) > 0 {
+		result = append(result, self.primaryKey)
+	}
+	return append(result, self.uniques...)
+}
+
+//check returns error when the constraint can not be added.
+func (self *constraints) check(columns []string, primary bool) error {
+	if len(columns) == 0 {
+		return ErrColumnNotExist
+	}
+	if primary && len(self.primaryKey) > 0 {
+		return ErrConstraintExist
+	}
+	for _, v := range self.all() {
+		if indexName(v) == indexName(columns) {
+			return ErrConstraintExist
+		}
+	}
+	return nil
+}
+
+func (self *constraints) add(columns []string, primary bool) {
+	if primary {
+		self.primaryKey = columns
+	} else {
+		self.uniques = append(self.uniques, columns)
+	}
+}
+
+/*
+ markConstraintIndexes marks indexes which keep constraints.
+ Returns error when an index of constraint is missing.
+*/
+func markConstraintIndexes(indexes []*Index, c constraints) error {
+	for _, columns := range c.all() {
+		index, err := findIndex(indexes, columns)
+		if err != nil {
+			return err
+		}
+		if index.Unique == false {
+			return errors.New("Index of constraint is not unique: " + index.Name)
+		}
+		index.constraint = true
+	}
+	if len(c.primaryKey) > 0 {
+		index, _ := findIndex(indexes, c.primaryKey)
+		index.primary = true
+	}
+	return nil
+}
+
+//readRowByKey finds the row by the index of primary key. The lock of table must be held.
+func readRowByKey(table rowReader, indexes []*Index, c constraints, key []interface{}) (Row, int64, error) {
+	if len(c.primaryKey) == 0 {
+		return nil, -1, ErrNoPrimaryKey
+	}
+	if len(key) != len(c.primaryKey) {
+		return nil, -1, errors.New("Number of key values is not correct")
+	}
+	index, err := findIndex(indexes, c.primaryKey)
+	if err != nil {
+		return nil, -1, err
+	}
+	rowNums, err := index.lookup(key...)
+	if err != nil {
+		return nil, -1, err
+	}
+	if len(rowNums) == 0 {
+		return nil, -1, ErrKeyNotFound
+	}
+	row, err := table.readRow(rowNums[0])
+	if err != nil {
+		return nil, -1, err
+	}
+	return row, rowNums[0], nil
+}
+
+//constraintTarget is a table which constraints are added to.
+type constraintTarget interface {
+	rowReader
+	indexedTable
+}
+
+/*
+ addConstraint adds a constraint to c and marks its index.
+ A unique index on columns is created unless it exists.
+ Columns of primary key must not have NULL.
+*/
+func addConstraint(table constraintTarget, c *constraints, columns []string, primary bool) error {
+	err := c.check(columns, primary)
+	if err != nil {
+		return err
+	}
+	if primary {
+		err = checkNotNull(table, columns)
+		if err != nil {
+			return err
+		}
+	}
+	index, err := table.getIndex(columns)
+	if err == ErrIndexNotExist {
+		err = table.createIndex(columns, true)
+		if err == ErrDuplicateKey {
+			return ErrConstraintViolation
+		}
+		if err != nil {
+			return err
+		}
+		index, err = table.getIndex(columns)
+	}
+	if err != nil {
+		return err
+	}
+	if index.Unique == false {
+		return ErrIndexExist
+	}
+	index.constraint = true
+	index.primary = primary
+	c.add(columns, primary)
+	return nil
+}
+
+//checkNotNull returns ErrConstraintViolation when a row has NULL in columns.
+func checkNotNull(table rowReader, columns []string) error {
+	for _, name := range columns {
+		found := false
+		for _, v := range table.columns() {
+			if v.Name == name {
+				found = true
+			}
+		}
+		if found == false {
+			return ErrColumnNotExist
+		}
+	}
+	it, err := table.scan(nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		for _, name := range columns {
+			if it.Row()[name] == nil {
+				return ErrConstraintViolation
+			}
+		}
+	}
+	return it.Err()
+}
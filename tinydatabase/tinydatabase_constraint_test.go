@@ -0,0 +1,183 @@
+package tinydatabase
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test1_Constraint_basicUsage(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		size := int64(16)
+		if tabletype == "dynamic" {
+			size = 0
+		}
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "id", Type: COLUMN_INT64, Size: 64},
+			{Name: "email", Type: COLUMN_STRING, Size: size},
+			{Name: "shop", Type: COLUMN_INT64, Size: 64},
+			{Name: "code", Type: COLUMN_STRING, Size: size},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		_, _, err = db.ReadRowByKey(tabletype, 1)
+		if err != ErrNoPrimaryKey {
+			t.Errorf("Failed to check primary key: %v", err)
+		}
+		_, err = table.WriteRow(Row{"id": int64(1), "email": "a@example.com", "shop": int64(1), "code": "x"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+		_, err = table.WriteRow(Row{"id": int64(1), "email": "b@example.com", "shop": int64(1), "code": "y"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+		err = db.SetPrimaryKey(tabletype, []string{"id"})
+		if err != ErrConstraintViolation {
+			t.Errorf("Failed to refuse primary key on duplicate rows: %v", err)
+		}
+		err = table.DeleteRow(1)
+		if err != nil {
+			t.Errorf("Failed to delete row: %s", err)
+		}
+
+		err = db.SetPrimaryKey(tabletype, []string{"id"})
+		if err != nil {
+			t.Errorf("Failed to set primary key: %s", err)
+		}
+		err = db.SetPrimaryKey(tabletype, []string{"email"})
+		if err != ErrConstraintExist {
+			t.Errorf("Failed to refuse second primary key: %v", err)
+		}
+		err = db.AddUnique(tabletype, []string{"email"})
+		if err != nil {
+			t.Errorf("Failed to add unique constraint: %s", err)
+		}
+		err = db.AddUnique(tabletype, []string{"shop", "code"})
+		if err != nil {
+			t.Errorf("Failed to add composite unique constraint: %s", err)
+		}
+		err = db.AddUnique(tabletype, []string{"email"})
+		if err != ErrConstraintExist {
+			t.Errorf("Failed to refuse same constraint: %v", err)
+		}
+
+		_, err = table.WriteRow(Row{"id": int64(1), "email": "c@example.com", "shop": int64(1), "code": "z"})
+		if err != ErrConstraintViolation {
+			t.Errorf("Failed to refuse duplicate primary key: %v", err)
+		}
+		_, err = table.WriteRow(Row{"id": int64(2), "email": "a@example.com", "shop": int64(1), "code": "z"})
+		if err != ErrConstraintViolation {
+			t.Errorf("Failed to refuse duplicate unique column: %v", err)
+		}
+		_, err = table.WriteRow(Row{"id": int64(2), "email": "c@example.com", "shop": int64(1), "code": "x"})
+		if err != ErrConstraintViolation {
+			t.Errorf("Failed to refuse duplicate composite unique columns: %v", err)
+		}
+		rowNum, err := table.WriteRow(Row{"id": int64(2), "email": "c@example.com", "shop": int64(2), "code": "x"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+		err = table.UpdateRow(rowNum, Row{"id": int64(1), "email": "c@example.com", "shop": int64(2), "code": "x"})
+		if err != ErrConstraintViolation {
+			t.Errorf("Failed to refuse update to duplicate primary key: %v", err)
+		}
+
+		row, num, err := db.ReadRowByKey(tabletype, 2)
+		if err != nil || num != rowNum || row["email"] != "c@example.com" {
+			t.Errorf("Failed to read row by primary key: %v, %d, %v", row, num, err)
+		}
+		_, _, err = db.ReadRowByKey(tabletype, 3)
+		if err != ErrKeyNotFound {
+			t.Errorf("Failed to check missing key: %v", err)
+		}
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ = dbList.Get("database1")
+	for _, tabletype := range []string{"static", "dynamic"} {
+		row, _, err := db.ReadRowByKey(tabletype, int64(1))
+		if err != nil || row["email"] != "a@example.com" {
+			t.Errorf("Failed to read row by loaded primary key: %v, %v", row, err)
+		}
+		table, _ := db.GetTable(tabletype)
+		_, err = table.WriteRow(Row{"id": int64(3), "email": "d@example.com", "shop": int64(2), "code": "x"})
+		if err != ErrConstraintViolation {
+			t.Errorf("Failed to check loaded constraint: %v", err)
+		}
+	}
+	dbList.Close()
+}
+
+func Test2_Constraint_readByKeyWithWriter(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{{Name: "id", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		_, err = table.WriteRow(Row{"id": int64(0)})
+		if err != nil {
+			t.Fatalf("Failed to insert row: %s", err)
+		}
+		err = db.SetPrimaryKey(tabletype, []string{"id"})
+		if err != nil {
+			t.Fatalf("Failed to set primary key: %s", err)
+		}
+
+		//readers hold the lock of table while a writer waits for it
+		done := make(chan bool)
+		go func() {
+			for i := 1; i <= 200; i++ {
+				table.WriteRow(Row{"id": int64(i)})
+			}
+			done <- true
+		}()
+		go func() {
+			for i := 0; i < 2000; i++ {
+				db.ReadRowByKey(tabletype, int64(0))
+			}
+			done <- true
+		}()
+		for i := 0; i < 2; i++ {
+			select {
+			case <-done:
+			case <-time.After(30 * time.Second):
+				t.Fatalf("Failed to finish reading by key with writer on %s table", tabletype)
+			}
+		}
+		row, _, err := db.ReadRowByKey(tabletype, int64(200))
+		if err != nil || row["id"] != int64(200) {
+			t.Errorf("Failed to read row by key: %v %v", row, err)
+		}
+	}
+	dbList.Close()
+}
@@ -4,26 +4,50 @@
 package tinydatabase
 
 import (
-	//"bytes"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
-//Database is a manager struct of tables.
+/*
+ Database is a manager struct of tables.
+ Its methods and methods of its tables can be called by goroutines at the same time.
+*/
 type Database struct {
-	filetype  string
-	directory string
-	tables    map[string]TableInterface
+	filetype    string
+	directory   string
+	tables      map[string]TableInterface
+	wal         *writeAheadLog
+	readOnly    bool
+	upgradeMode string
+	//Settings of group and periodic commits saved in durability.config
+	groupCommitWindow time.Duration
+	syncInterval      time.Duration
+	mutex             sync.RWMutex
 }
 
-//DatabaseList is a manager struct of databases.
+/*
+ DatabaseList is a manager struct of databases.
+ Its methods can be called by goroutines at the same time.
+ Databases must not be accessed directly while other goroutines use the list. Use Get and Names.
+ The directory is locked until Close, so other processes can not open it.
+*/
 type DatabaseList struct {
-	filetype  string
-	directory string
-	Databases map[string]*Database
+	filetype    string
+	directory   string
+	Databases   map[string]*Database
+	readOnly    bool
+	upgradeMode string
+	lock        *os.File
+	mutex       sync.RWMutex
 }
 
 var (
@@ -33,13 +57,15 @@ var (
 	ErrDatabaseNotExist = errors.New("Specified database is not existed")
 	ErrInvalidTabletype = errors.New("Specified table type is invalid")
 	ErrTableNotExist    = errors.New("Specified table is not existed")
+	ErrTableExist       = errors.New("Specified table exists")
 	ErrNotImplemented   = errors.New("Not Implemented")
+	ErrInvalidName      = errors.New("Specified name is invalid")
 	DirParmission       = 0755
 )
 
 //NewDatabaseList creates a new DatabaseList with directory.
 func NewDatabaseList(directory string, databaseType string) (result *DatabaseList, err error) {
-	if databaseType != "json" /*&& databaseType != "toml"*/ {
+	if databaseType != "json" && databaseType != "toml" {
 		return nil, ErrInvalidFiletype
 	}
 	err = createDir(directory)
@@ -47,8 +73,13 @@ func NewDatabaseList(directory string, databaseType string) (result *DatabaseLis
 		return nil, err
 	}
 	directory = strings.TrimSuffix(directory, "/")
+	lock, err := lockDirectory(directory, false)
+	if err != nil {
+		return nil, err
+	}
 	err = dbExistanceCheck(directory + "/databases.config")
 	if err != nil {
+		unlockDirectory(lock, false)
 		return nil, err
 	}
 
@@ -56,31 +87,94 @@ func NewDatabaseList(directory string, databaseType string) (result *DatabaseLis
 	result.directory = directory
 	result.filetype = databaseType
 	result.Databases = map[string]*Database{}
+	result.lock = lock
 	err = result.Save()
 	return result, err
 }
 
-//LoadDatabaseList loads DatabaseList from directory.
+/*
+ LoadDatabaseList loads DatabaseList from directory.
+ When other process has opened the directory, returns DatabaseInUseError.
+*/
 func LoadDatabaseList(directory string, databaseType string) (result *DatabaseList, err error) {
-	if databaseType != "json" /*&& databaseType != "toml"*/ {
+	return loadDatabaseList(directory, databaseType, false, UPGRADE_NONE)
+}
+
+/*
+ LoadDatabaseListWithUpgradeMode loads DatabaseList from directory like LoadDatabaseList.
+ Tables whose files are old versions are handled by upgradeMode. See UPGRADE_NONE.
+*/
+func LoadDatabaseListWithUpgradeMode(directory string, databaseType string, upgradeMode string) (result *DatabaseList, err error) {
+	err = checkUpgradeMode(upgradeMode)
+	if err != nil {
+		return nil, err
+	}
+	return loadDatabaseList(directory, databaseType, false, upgradeMode)
+}
+
+/*
+ LoadDatabaseListReadOnly loads DatabaseList from directory without writing any file.
+ Processes which open the directory read-only can run at the same time, but a process can not open it writable.
+ Writes return ErrReadOnly. When files are left by a crash, returns ErrRecoveryNeeded.
+*/
+func LoadDatabaseListReadOnly(directory string, databaseType string) (result *DatabaseList, err error) {
+	return loadDatabaseList(directory, databaseType, true, UPGRADE_NONE)
+}
+
+func loadDatabaseList(directory string, databaseType string, readOnly bool, upgradeMode string) (result *DatabaseList, err error) {
+	if databaseType != "json" && databaseType != "toml" {
 		return nil, ErrInvalidFiletype
 	}
 	directory = strings.TrimSuffix(directory, "/")
-	err = dbExistanceCheck(directory + "/databases.config")
-	if err != ErrDatabaseExist {
+	configfilename := directory + "/databases.config"
+	if dbExistanceCheck(configfilename) != ErrDatabaseExist && dbExistanceCheck(configfilename+tempSuffix) != ErrDatabaseExist {
 		return nil, ErrDatabaseNotExist
 	}
+	//The lock is taken before files are recovered.
+	lock, err := lockDirectory(directory, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		err = checkRecovered(configfilename + tempSuffix)
+	} else {
+		//databases.config may be left as the temporary file by a crash while it is saved.
+		err = recoverFileAtomic(configfilename)
+	}
+	if err == nil && dbExistanceCheck(configfilename) != ErrDatabaseExist {
+		err = ErrDatabaseNotExist
+	}
+	if err != nil {
+		unlockDirectory(lock, readOnly)
+		return nil, err
+	}
 
 	result = &DatabaseList{}
 	result.directory = directory
 	result.filetype = databaseType
 	result.Databases = map[string]*Database{}
+	result.readOnly = readOnly
+	result.upgradeMode = upgradeMode
+	result.lock = lock
 	err = result.Load()
-	return result, err
+	if err != nil {
+		result.Close()
+		return nil, err
+	}
+	return result, nil
 }
 
 //NewDatabase creates new Database under the DatabaseList directory.
 func (self *DatabaseList) NewDatabase(name string) (result *Database, err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return nil, ErrReadOnly
+	}
+	err = checkName(name)
+	if err != nil {
+		return nil, err
+	}
 	_, ok := self.Databases[name]
 	if ok == true {
 		return nil, ErrDatabaseExist
@@ -90,12 +184,14 @@ func (self *DatabaseList) NewDatabase(name string) (result *Database, err error)
 	if err != nil {
 		return nil, err
 	}
-	err = self.Save()
+	err = self.save()
 	return self.Databases[name], err
 }
 
 //Get returns specified Database or error.
 func (self *DatabaseList) Get(name string) (result *Database, err error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
 	result, ok := self.Databases[name]
 	if ok == false {
 		return nil, ErrDatabaseNotExist
@@ -103,13 +199,29 @@ func (self *DatabaseList) Get(name string) (result *Database, err error) {
 	return result, nil
 }
 
+//Names returns sorted names of databases.
+func (self *DatabaseList) Names() []string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	result := []string{}
+	for name := range self.Databases {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
 //Save saves all databases and tables.
-func (self *DatabaseList) Save() (err error) {
-	file, err := os.Create(self.directory + "/databases.config")
-	if err != nil {
-		return err
+func (self *DatabaseList) Save() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
 	}
-	defer file.Close()
+	return self.save()
+}
+
+func (self *DatabaseList) save() (err error) {
 	dbNameList := []string{}
 	for key, val := range self.Databases {
 		dbNameList = append(dbNameList, key)
@@ -118,57 +230,69 @@ func (self *DatabaseList) Save() (err error) {
 			return err
 		}
 	}
-	if self.filetype == "json" {
-		byteS, err := json.Marshal(dbNameList)
-		if err != nil {
-			return err
-		}
-		num := 0
-		for num < len(byteS) {
-			tempnum, err := file.Write(byteS[num:])
-			if err != nil {
-				return err
-			}
-			num += tempnum
-		}
-	} /*else if self.filetype == "toml" {
-		buf := new(bytes.Buffer)
-		err = toml.NewEncoder(buf).Encode(dbNameList)
-		if err != nil {
-			return err
-		}
-		byteS := buf.Bytes()
-		num := 0
-		for num < len(byteS) {
-			tempnum, err := file.Write(byteS[num:])
-			if err != nil {
-				return err
-			}
-			num += tempnum
-		}
-	}*/
-	return nil
+	return self.saveNames(dbNameList)
 }
 
-//Load loads DatabaseList.
-func (self *DatabaseList) Load() (err error) {
-	data, err := ioutil.ReadFile(self.directory + "/databases.config")
+//tomlDatabaseNames is a content of databases.config in toml, because toml can not have a list at the top.
+type tomlDatabaseNames struct {
+	Databases []string
+}
+
+//saveNames writes databases.config with names of databases.
+func (self *DatabaseList) saveNames(dbNameList []string) error {
+	var v interface{} = dbNameList
+	if self.filetype == "toml" {
+		v = tomlDatabaseNames{Databases: dbNameList}
+	}
+	byteS, err := encodeConfig(self.filetype, v)
 	if err != nil {
 		return err
 	}
+	return writeFileAtomic(self.directory+"/databases.config", byteS)
+}
+
+//loadNames reads names of databases from databases.config. Both json and toml are accepted.
+func (self *DatabaseList) loadNames() ([]string, error) {
+	data, err := ioutil.ReadFile(self.directory + "/databases.config")
+	if err != nil {
+		return nil, err
+	}
 	dbNameList := []string{}
-	if self.filetype == "json" {
+	if json.Valid(data) {
 		err = json.Unmarshal(data, &dbNameList)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	} /*else if self.filetype == "toml" {
-		_, err = toml.Decode(string(data), &dbNameList)
-		return ErrNotImplemented
-	}*/
+		return dbNameList, nil
+	}
+	names := tomlDatabaseNames{}
+	_, err = toml.Decode(string(data), &names)
+	if err != nil {
+		return nil, err
+	}
+	return append(dbNameList, names.Databases...), nil
+}
+
+//Load loads DatabaseList.
+func (self *DatabaseList) Load() (err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	//Dropping or renaming which was stopped by a crash is finished before databases are opened.
+	if self.readOnly {
+		err = checkRecovered(self.directory + "/" + journalFilename)
+	} else {
+		err = self.recoverJournal()
+	}
+	if err != nil {
+		return err
+	}
+	dbNameList, err := self.loadNames()
+	if err != nil {
+		return err
+	}
 	for i := 0; i < len(dbNameList); i++ {
-		self.Databases[dbNameList[i]] = &Database{}
-		err = self.Databases[dbNameList[i]].Load(self.directory+"/"+dbNameList[i], self.filetype)
+		self.Databases[dbNameList[i]] = &Database{readOnly: self.readOnly}
+		err = self.Databases[dbNameList[i]].LoadWithUpgradeMode(self.directory+"/"+dbNameList[i], self.filetype, self.upgradeMode)
 		if err != nil {
 			return err
 		}
@@ -176,22 +300,39 @@ func (self *DatabaseList) Load() (err error) {
 	return nil
 }
 
-//Close closes all Databases.
+//Close closes all Databases and releases the lock of the directory.
 func (self *DatabaseList) Close() (err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	err = self.closeDatabases()
+	//The lock is released even when a database fails to close, so it is not held until the process ends.
+	unlockErr := unlockDirectory(self.lock, self.readOnly)
+	self.lock = nil
+	if err != nil {
+		return err
+	}
+	return unlockErr
+}
+
+//closeDatabases closes all Databases while the directory is still locked. The first error is returned.
+func (self *DatabaseList) closeDatabases() error {
+	var result error
 	for _, val := range self.Databases {
-		err = val.Close()
-		if err != nil {
-			return err
+		err := val.Close()
+		if err != nil && result == nil {
+			result = err
 		}
 	}
-	return nil
+	return result
 }
 
 //New creates Database on the directory.
 func (self *Database) New(directory string, filetype string) error {
-	if filetype != "json" /*&& filetype != "toml"*/ {
+	if filetype != "json" && filetype != "toml" {
 		return ErrInvalidFiletype
 	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 
 	err := createDir(directory)
 	if err != nil {
@@ -204,65 +345,137 @@ func (self *Database) New(directory string, filetype string) error {
 	self.directory = directory
 	self.filetype = filetype
 	self.tables = map[string]TableInterface{}
-	err = self.Save()
+	self.groupCommitWindow = defaultGroupCommitWindow
+	self.syncInterval = defaultSyncInterval
+	self.wal, err = openWriteAheadLog(directory, self.groupCommitWindow, self.syncInterval)
+	if err != nil {
+		return err
+	}
+	err = self.save()
 
 	return err
 }
 
 //Save saves config and all tables.
 func (self *Database) Save() error {
-	file, err := os.Create(self.directory + "/tables.config")
-	if err != nil {
-		return err
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
 	}
-	defer file.Close()
+	return self.save()
+}
+
+func (self *Database) save() error {
 	tableNameMap := map[string]string{}
 	for key, val := range self.tables {
 		//tableNameList = append(tableNameList, key)
 		tableNameMap[key] = val.GetTableType()
 	}
-	if self.filetype == "json" {
-		bytes, err := json.Marshal(tableNameMap)
-		if err != nil {
-			return err
-		}
-		num := 0
-		for num < len(bytes) {
-			tempnum, err := file.Write(bytes[num:])
-			if err != nil {
-				return err
-			}
-			num += tempnum
-		}
-	} /*else if self.filetype == "toml" {
-		return ErrNotImplemented
-	}*/
-	return nil
+	return self.saveTableNames(tableNameMap)
+}
+
+//saveTableNames writes tables.config with names and types of tables.
+func (self *Database) saveTableNames(tableNameMap map[string]string) error {
+	bytes, err := encodeConfig(self.filetype, tableNameMap)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(self.directory+"/tables.config", bytes)
+}
+
+//loadTableNames reads names and types of tables from tables.config. Both json and toml are accepted.
+func (self *Database) loadTableNames() (map[string]string, error) {
+	data, err := ioutil.ReadFile(self.directory + "/tables.config")
+	if err != nil {
+		return nil, err
+	}
+	tableNameMap := map[string]string{}
+	err = decodeConfig(data, &tableNameMap)
+	if err != nil {
+		return nil, err
+	}
+	return tableNameMap, nil
 }
 
-//Load loads Database from directory.
+//Load loads Database from directory. The upgrade mode given by LoadWithUpgradeMode before is kept.
 func (self *Database) Load(directory string, filetype string) error {
-	if filetype != "json" /*&& filetype != "toml"*/ {
+	if filetype != "json" && filetype != "toml" {
+		return ErrInvalidFiletype
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.load(directory, filetype)
+}
+
+//LoadWithUpgradeMode loads Database from directory. Tables whose files are old versions are handled by upgradeMode.
+func (self *Database) LoadWithUpgradeMode(directory string, filetype string, upgradeMode string) error {
+	if filetype != "json" && filetype != "toml" {
 		return ErrInvalidFiletype
 	}
-	err := dbExistanceCheck(directory + "/tables.config")
+	err := checkUpgradeMode(upgradeMode)
+	if err != nil {
+		return err
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.upgradeMode = upgradeMode
+	return self.load(directory, filetype)
+}
+
+//load is Load while the lock is held.
+func (self *Database) load(directory string, filetype string) error {
+	var err error
+	if self.readOnly {
+		err = checkRecovered(directory+"/tables.config"+tempSuffix, directory+"/"+durabilityFilename+tempSuffix, directory+"/"+journalFilename)
+		if err == nil {
+			err = checkWriteAheadLog(directory)
+		}
+	} else {
+		err = recoverFileAtomic(directory + "/tables.config")
+		if err == nil {
+			err = recoverFileAtomic(directory + "/" + durabilityFilename)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	err = dbExistanceCheck(directory + "/tables.config")
 	if err != ErrDatabaseExist {
 		return ErrDatabaseNotExist
 	}
 	self.directory = directory
 	self.filetype = filetype
 	self.tables = map[string]TableInterface{}
+	err = self.openFiles()
+	if err != nil {
+		//Tables and the log opened before the error are closed, so a failed load holds no file.
+		self.close()
+		self.tables = map[string]TableInterface{}
+		return err
+	}
+	return nil
+}
 
-	data, err := ioutil.ReadFile(self.directory + "/tables.config")
+//openFiles opens the log and all tables. The lock must be held.
+func (self *Database) openFiles() error {
+	err := self.loadDurabilityConfig()
 	if err != nil {
 		return err
 	}
-	tableNameMap := map[string]string{}
-	if self.filetype == "json" {
-		err = json.Unmarshal(data, &tableNameMap)
-	} /* else if self.filetype == "toml" {
-		return ErrNotImplemented
-	}*/
+	if self.readOnly == false {
+		//Writes which may be torn by a crash are replayed before tables are opened.
+		self.wal, err = openWriteAheadLog(self.directory, self.groupCommitWindow, self.syncInterval)
+		if err != nil {
+			return err
+		}
+
+		err = self.recoverJournal()
+		if err != nil {
+			return err
+		}
+	}
+	tableNameMap, err := self.loadTableNames()
 	if err != nil {
 		return err
 	}
@@ -276,14 +489,24 @@ func (self *Database) Load(directory string, filetype string) error {
 		} else {
 			return ErrNotImplemented
 		}
+		self.setReadOnly(tableI)
+		if self.declinesUpgrade(key) {
+			tableI.(upgradableTable).setReadOnly(true)
+		}
 		err = tableI.Open(self.directory, key)
 		if err != nil {
+			//Files opened before the error are closed.
+			tableI.Close()
 			return err
 		}
+		self.setWriteAheadLog(tableI)
 		self.tables[key] = tableI
+		err = self.applyUpgradeMode(key, tableI)
+		if err != nil {
+			return err
+		}
 	}
-
-	return err
+	return nil
 }
 
 //NewTable creates table.
@@ -296,17 +519,39 @@ func (self *Database) NewTable(tablename string, tableType string, columnTypes [
 		return nil, ErrInvalidTabletype
 	}
 
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return nil, ErrReadOnly
+	}
+	err = checkName(tablename)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := self.tables[tablename]
+	if ok == true {
+		return nil, ErrTableExist
+	}
+	self.setFiletype(result)
 	err = result.NewTable(self.directory, tablename, columnTypes)
 	if err != nil {
 		return nil, err
 	}
+	self.setWriteAheadLog(result)
 	self.tables[tablename] = result
-	err = self.Save()
+	err = self.save()
 	return result, err
 }
 
 //GetTable returns table.
 func (self *Database) GetTable(name string) (TableInterface, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.getTable(name)
+}
+
+//getTable returns table while the lock of database is held.
+func (self *Database) getTable(name string) (TableInterface, error) {
 	result, ok := self.tables[name]
 	if ok == false {
 		return nil, ErrTableNotExist
@@ -314,18 +559,175 @@ func (self *Database) GetTable(name string) (TableInterface, error) {
 	return result, nil
 }
 
+//TableNames returns sorted names of tables.
+func (self *Database) TableNames() []string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	result := []string{}
+	for name := range self.tables {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+/*
+ Compact rewrites the files of the table without deleted rows.
+ Only dynamic tables can be compacted. Row numbers do not change.
+*/
+func (self *Database) Compact(tablename string) error {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return err
+	}
+	dynamic, ok := table.(*TableDynamic)
+	if ok == false {
+		return ErrInvalidTabletype
+	}
+	return dynamic.Compact()
+}
+
 //Close closes tables.
 func (self *Database) Close() (err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.close()
+}
+
+//close closes all tables and the log. All of them are closed even when one fails, and the first error is returned.
+func (self *Database) close() error {
+	var result error
 	for _, val := range self.tables {
-		err = val.Close()
+		err := val.Close()
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	if self.wal != nil {
+		err := self.wal.Close()
+		if err != nil && result == nil {
+			result = err
+		}
+		self.wal = nil
+	}
+	return result
+}
+
+//setWriteAheadLog makes the table write through the log of database.
+func (self *Database) setWriteAheadLog(table TableInterface) {
+	walTable, ok := table.(interface {
+		setWriteAheadLog(wal *writeAheadLog)
+	})
+	if ok == true {
+		walTable.setWriteAheadLog(self.wal)
+	}
+}
+
+//setReadOnly makes the table refuse writes when the database is read-only.
+func (self *Database) setReadOnly(table TableInterface) {
+	readOnlyTable, ok := table.(interface {
+		setReadOnly(readOnly bool)
+	})
+	if ok == true {
+		readOnlyTable.setReadOnly(self.readOnly)
+	}
+}
+
+//setFiletype makes the table write its config file in the file type of database.
+func (self *Database) setFiletype(table TableInterface) {
+	filetypeTable, ok := table.(interface {
+		setFiletype(filetype string)
+	})
+	if ok == true {
+		filetypeTable.setFiletype(self.filetype)
+	}
+}
+
+/*
+ ConvertDatabaseList rewrites all config files of DatabaseList in directory with filetype.
+ Table configs are rewritten first and databases.config last. Config files of both types can be read,
+ so the list can be loaded after the conversion is stopped, and the conversion can be run again.
+ The directory is locked while it is converted.
+*/
+func ConvertDatabaseList(directory string, filetype string) error {
+	if filetype != "json" && filetype != "toml" {
+		return ErrInvalidFiletype
+	}
+	list, err := LoadDatabaseList(directory, filetype)
+	if err != nil {
+		return err
+	}
+	defer list.Close()
+	//Loading has finished the recovery of files. Files are rewritten while they are closed.
+	err = list.closeDatabases()
+	if err != nil {
+		return err
+	}
+	dbNameList := []string{}
+	for name, db := range list.Databases {
+		dbNameList = append(dbNameList, name)
+		tableNameMap, err := db.loadTableNames()
+		if err != nil {
+			return err
+		}
+		for tablename := range tableNameMap {
+			configfilename := db.directory + "/" + tablename + ".config"
+			config, err := loadTableConfig(configfilename)
+			if err != nil {
+				return err
+			}
+			config.filetype = filetype
+			err = saveTableConfig(configfilename, config)
+			if err != nil {
+				return err
+			}
+		}
+		err = db.saveTableNames(tableNameMap)
 		if err != nil {
 			return err
 		}
+		_, err = os.Stat(db.directory + "/" + durabilityFilename)
+		if err == nil {
+			err = db.saveDurabilityConfig()
+			if err != nil {
+				return err
+			}
+		}
 	}
-	return nil
+	return list.saveNames(dbNameList)
+}
+
+//encodeConfig encodes content of config file in filetype.
+func encodeConfig(filetype string, v interface{}) ([]byte, error) {
+	if filetype == "toml" {
+		buf := new(bytes.Buffer)
+		err := toml.NewEncoder(buf).Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return json.Marshal(v)
+}
+
+//decodeConfig decodes content of config file. The file type is found from data.
+func decodeConfig(data []byte, v interface{}) error {
+	if json.Valid(data) {
+		return json.Unmarshal(data, v)
+	}
+	_, err := toml.Decode(string(data), v)
+	return err
 }
 
 //createDir create directory when not exist.
+//checkName returns ErrInvalidName when name of database or table can not be a file name in its directory.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return ErrInvalidName
+	}
+	return nil
+}
+
 func createDir(directory string) error {
 	fInfo, err := os.Stat(directory)
 	if err != nil {
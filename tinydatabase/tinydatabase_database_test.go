@@ -1,10 +1,15 @@
 package tinydatabase
 
 import (
+	"bytes"
+	"encoding/json"
 	//"fmt"
+	"io/ioutil"
 	"os"
 	//"path"
 	//"strings"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -181,3 +186,693 @@ func Test2_database_load_json(t *testing.T) {
 	dbAllInstJson.Close()
 
 }
+
+func Test3_database_compact(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbAllInstJson, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Errorf("Failed to create new database list:%s", err)
+	}
+	dbInst1, err := dbAllInstJson.NewDatabase("database1")
+	if err != nil {
+		t.Errorf("Failed to create new database:%s", err)
+	}
+	columnSet := []ColumnType{
+		{Name: "intline", Type: "int64", Size: 64},
+		{Name: "strline", Type: "string", Size: 0},
+	}
+	table1, err := dbInst1.NewTable("table1", "dynamic", columnSet)
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+	_, err = dbInst1.NewTable("table2", "static", []ColumnType{{Name: "intline", Type: "int64", Size: 64}})
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = table1.WriteRow(Row{"intline": int64(i), "strline": "aaaa"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	err = table1.DeleteRow(1)
+	if err != nil {
+		t.Errorf("Failed to delete row: %s", err)
+	}
+
+	err = dbInst1.Compact("table1")
+	if err != nil {
+		t.Errorf("Failed to compact table: %s", err)
+	}
+	row, err := table1.ReadRow(2)
+	if err != nil || row["intline"] != int64(2) {
+		t.Errorf("Failed to read row after compaction: %v, %v", row, err)
+	}
+	err = dbInst1.Compact("table2")
+	if err != ErrInvalidTabletype {
+		t.Errorf("Failed to refuse compacting static table: %v", err)
+	}
+	err = dbInst1.Compact("table3")
+	if err != ErrTableNotExist {
+		t.Errorf("Failed to refuse compacting unknown table: %v", err)
+	}
+	dbAllInstJson.Close()
+}
+
+func Test4_database_defaults(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	_, err = db.NewTable("invalid", "static", []ColumnType{{Name: "c", Type: COLUMN_STRING, Size: 8, Default: DEFAULT_NOW}})
+	if err == nil {
+		t.Errorf("Failed to refuse now() for string column")
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		size := int64(16)
+		if tabletype == "dynamic" {
+			size = 0
+		}
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "id", Type: COLUMN_INT64, Size: 64, Default: DEFAULT_AUTOINCREMENT},
+			{Name: "status", Type: COLUMN_STRING, Size: size, Default: "new"},
+			{Name: "price", Type: COLUMN_FLOAT64, Size: 64, Default: 1.5},
+			{Name: "created", Type: COLUMN_TIME, Size: 15, Default: DEFAULT_NOW},
+			{Name: "name", Type: COLUMN_STRING, Size: size, NotNull: true},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		before := time.Now()
+		rowNum, err := table.WriteRow(Row{"name": "a"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+		row, _ := table.ReadRow(rowNum)
+		if row["id"] != int64(1) || row["status"] != "new" || row["price"] != 1.5 || row["created"].(time.Time).Before(before.Truncate(time.Second)) {
+			t.Errorf("Failed to fill default values: %v", row)
+		}
+		_, err = table.WriteRow(Row{"id": int64(10), "name": "b"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+		rowNum, _ = table.WriteRow(Row{"name": "c", "status": "done"})
+		row, _ = table.ReadRow(rowNum)
+		if row["id"] != int64(11) || row["status"] != "done" {
+			t.Errorf("Failed to continue auto increment: %v", row)
+		}
+		_, err = table.WriteRow(Row{"status": "done"})
+		if err != ErrNotNull {
+			t.Errorf("Failed to refuse missing NOT NULL column: %v", err)
+		}
+		_, err = table.WriteRow(Row{"name": nil})
+		if err != ErrNotNull {
+			t.Errorf("Failed to refuse nil for NOT NULL column: %v", err)
+		}
+		err = table.UpdateRow(rowNum, Row{"id": int64(11)})
+		if err != ErrNotNull {
+			t.Errorf("Failed to refuse update without NOT NULL column: %v", err)
+		}
+		count, _ := table.CountRows()
+		if count != 3 {
+			t.Errorf("Failed to refuse rows: %d", count)
+		}
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ = dbList.Get("database1")
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, _ := db.GetTable(tabletype)
+		rowNum, err := table.WriteRow(Row{"name": "d"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+		row, _ := table.ReadRow(rowNum)
+		if row["id"] != int64(12) || row["price"] != 1.5 {
+			t.Errorf("Failed to load default values: %v", row)
+		}
+	}
+	dbList.Close()
+}
+
+func Test5_database_columnTypes(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	_, err = db.NewTable("invalid", "static", []ColumnType{{Name: "c", Type: COLUMN_BYTES, Size: 0}})
+	if err == nil {
+		t.Errorf("Failed to refuse variable bytes in static table")
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		size := int64(4)
+		if tabletype == "dynamic" {
+			size = 0
+		}
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "flag", Type: COLUMN_BOOL, Size: 1},
+			{Name: "hash", Type: COLUMN_BYTES, Size: size},
+			{Name: "counter", Type: COLUMN_UINT64, Size: 64},
+			{Name: "amount", Type: COLUMN_DECIMAL, Precision: 10, Scale: 2},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		for i, amount := range []string{"10.50", "-3.25", "7"} {
+			_, err = table.WriteRow(Row{"flag": i%2 == 0, "hash": []byte{byte(i), 0, 0, 1}, "counter": uint64(1<<63) + uint64(i), "amount": amount})
+			if err != nil {
+				t.Errorf("Failed to insert row: %s", err)
+			}
+		}
+		row, err := table.ReadRow(1)
+		if err != nil || row["flag"] != false || bytes.Equal(row["hash"].([]byte), []byte{1, 0, 0, 1}) == false || row["counter"] != uint64(1<<63)+1 || row["amount"] != (Decimal{Unscaled: -325, Scale: 2}) {
+			t.Errorf("Failed to read row: %v, %v", row, err)
+		}
+		row, _ = table.ReadRow(2)
+		if row["amount"].(Decimal).String() != "7.00" {
+			t.Errorf("Failed to read decimal: %v", row["amount"])
+		}
+
+		err = db.CreateIndex(tabletype, []string{"amount"}, false)
+		if err != nil {
+			t.Errorf("Failed to create index: %s", err)
+		}
+		index, _ := db.GetIndex(tabletype, []string{"amount"})
+		rows, err := index.Range([]interface{}{"-5"}, []interface{}{"8"})
+		if err != nil || len(rows) != 2 || rows[0] != 1 || rows[1] != 2 {
+			t.Errorf("Failed to look up decimal range: %v, %v", rows, err)
+		}
+	}
+	dbList.Close()
+}
+
+func Test6_database_identity(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	_, err = db.NewTable("invalid", "static", []ColumnType{{Name: "a", Type: COLUMN_INT64, Identity: true}, {Name: "b", Type: COLUMN_INT64, Identity: true}})
+	if err == nil {
+		t.Errorf("Failed to refuse two identity columns")
+	}
+	_, err = db.NewTable("invalid", "static", []ColumnType{{Name: "a", Type: COLUMN_STRING, Size: 8, Identity: true}})
+	if err == nil {
+		t.Errorf("Failed to refuse identity string column")
+	}
+	uuids := []string{"123e4567-e89b-12d3-a456-426614174000", "00000000-0000-0000-0000-000000000001"}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "id", Type: COLUMN_INT64, Size: 64, Identity: true},
+			{Name: "uuid", Type: COLUMN_UUID},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"uuid"}, true)
+		if err != nil {
+			t.Errorf("Failed to create index: %s", err)
+		}
+		for i, v := range uuids {
+			row := Row{"uuid": v}
+			rowNum, written, err := table.WriteRowReturning(row)
+			if err != nil || written["id"] != int64(i+1) || row["id"] != nil {
+				t.Errorf("Failed to assign identity: %v, %v, %v", written, row, err)
+			}
+			_, err = table.WriteRow(written)
+			if err != ErrIdentityValue {
+				t.Errorf("Failed to refuse value of identity column: %v", err)
+			}
+			read, err := table.ReadRow(rowNum)
+			if err != nil || read["id"] != int64(i+1) || read["uuid"].(UUID).String() != v {
+				t.Errorf("Failed to read row: %v, %v", read, err)
+			}
+		}
+		err = table.UpdateRow(0, Row{"uuid": uuids[0]})
+		if err != nil {
+			t.Errorf("Failed to update row: %s", err)
+		}
+		row, _ := table.ReadRow(0)
+		if row["id"] != int64(1) {
+			t.Errorf("Failed to keep identity: %v", row)
+		}
+		err = table.UpdateRow(0, Row{"id": int64(5), "uuid": uuids[0]})
+		if err != ErrIdentityValue {
+			t.Errorf("Failed to refuse change of identity: %v", err)
+		}
+		index, _ := db.GetIndex(tabletype, []string{"uuid"})
+		rows, err := index.Lookup(uuids[1])
+		if err != nil || len(rows) != 1 || rows[0] != 1 {
+			t.Errorf("Failed to look up uuid: %v, %v", rows, err)
+		}
+		err = table.DeleteRow(1)
+		if err != nil {
+			t.Errorf("Failed to delete row: %s", err)
+		}
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ = dbList.Get("database1")
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, _ := db.GetTable(tabletype)
+		_, row, err := table.WriteRowReturning(Row{"uuid": uuids[1]})
+		if err != nil || row["id"] != int64(3) {
+			t.Errorf("Failed to continue persisted counter: %v, %v", row, err)
+		}
+	}
+	dbList.Close()
+}
+
+func Test7_database_atomicSave(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	table, err := db.NewTable("table1", "static", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	table.WriteRow(Row{"a": int64(1)})
+	dbList.Close()
+	for _, filename := range []string{"databases.config.tmp", "database1/tables.config.tmp", "database1/table1.config.tmp"} {
+		_, err = os.Stat(directoryJson + filename)
+		if os.IsNotExist(err) == false {
+			t.Errorf("Failed to rename temporary file %s: %v", filename, err)
+		}
+	}
+
+	//A temporary file beside the config file was not renamed, and is discarded.
+	ioutil.WriteFile(directoryJson+"databases.config.tmp", []byte("[\"datab"), 0666)
+	//A temporary file without the config file is renamed.
+	os.Rename(directoryJson+"database1/tables.config", directoryJson+"database1/tables.config.tmp")
+	os.Rename(directoryJson+"database1/table1.config", directoryJson+"database1/table1.config.tmp")
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, err = dbList.Get("database1")
+	if err != nil {
+		t.Fatalf("Failed to get database: %s", err)
+	}
+	table, err = db.GetTable("table1")
+	if err != nil {
+		t.Fatalf("Failed to get table: %s", err)
+	}
+	row, err := table.ReadRow(0)
+	if err != nil || row["a"] != int64(1) {
+		t.Errorf("Failed to read row: %v, %v", row, err)
+	}
+	for _, filename := range []string{"databases.config.tmp", "database1/tables.config.tmp", "database1/table1.config.tmp"} {
+		_, err = os.Stat(directoryJson + filename)
+		if os.IsNotExist(err) == false {
+			t.Errorf("Failed to recover temporary file %s: %v", filename, err)
+		}
+	}
+
+	//A broken temporary file without the config file is removed.
+	dbList.Close()
+	os.Rename(directoryJson+"databases.config", directoryJson+"databases.config.tmp")
+	ioutil.WriteFile(directoryJson+"databases.config.tmp", []byte("[\"datab"), 0666)
+	_, err = LoadDatabaseList(directoryJson, "json")
+	if err != ErrDatabaseNotExist {
+		t.Errorf("Failed to discard broken temporary file: %v", err)
+	}
+}
+
+func Test8_database_toml(t *testing.T) {
+	directoryToml := "./testdata_toml/"
+	DirParmission = 0777
+	os.RemoveAll(directoryToml)
+	defer os.RemoveAll(directoryToml)
+
+	dbList, err := NewDatabaseList(directoryToml, "toml")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "id", Type: COLUMN_INT64, Size: 64, Identity: true},
+			{Name: "name", Type: COLUMN_STRING, Size: 16, Default: "none"},
+			{Name: "price", Type: COLUMN_FLOAT64, Size: 64, Nullable: true},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"name"}, false)
+		if err != nil {
+			t.Fatalf("Failed to create index: %s", err)
+		}
+		_, err = table.WriteRow(Row{"price": float64(1.5)})
+		if err != nil {
+			t.Errorf("Failed to write row: %s", err)
+		}
+	}
+	dbList.Close()
+	for _, filename := range []string{"databases.config", "database1/tables.config", "database1/static.config", "database1/dynamic.config"} {
+		data, _ := ioutil.ReadFile(directoryToml + filename)
+		if len(data) == 0 || json.Valid(data) {
+			t.Errorf("Failed to write toml %s: %s", filename, data)
+		}
+	}
+
+	dbList, err = LoadDatabaseList(directoryToml, "toml")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, err = dbList.Get("database1")
+	if err != nil {
+		t.Fatalf("Failed to get database: %s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.GetTable(tabletype)
+		if err != nil {
+			t.Fatalf("Failed to get table: %s", err)
+		}
+		rowNum, row, err := table.WriteRowReturning(Row{"name": "b"})
+		if err != nil || row["id"] != int64(2) {
+			t.Errorf("Failed to keep identity: %v, %v", row, err)
+		}
+		row, err = table.ReadRow(0)
+		if err != nil || row["name"] != "none" || row["price"] != float64(1.5) {
+			t.Errorf("Failed to read row: %v, %v", row, err)
+		}
+		row, err = table.ReadRow(rowNum)
+		if err != nil || row["price"] != nil {
+			t.Errorf("Failed to read nullable column: %v, %v", row, err)
+		}
+		index, err := db.GetIndex(tabletype, []string{"name"})
+		if err != nil {
+			t.Fatalf("Failed to get index: %s", err)
+		}
+		rows, err := index.Lookup("b")
+		if err != nil || len(rows) != 1 || rows[0] != rowNum {
+			t.Errorf("Failed to look up index: %v, %v", rows, err)
+		}
+	}
+}
+
+func Test9_database_convertToml(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	table, err := db.NewTable("table1", "dynamic", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64, Default: DEFAULT_AUTOINCREMENT}})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	table.WriteRow(Row{})
+	dbList.Close()
+
+	err = ConvertDatabaseList(directoryJson, "xml")
+	if err != ErrInvalidFiletype {
+		t.Errorf("Failed to refuse invalid file type: %v", err)
+	}
+	err = ConvertDatabaseList(directoryJson, "toml")
+	if err != nil {
+		t.Fatalf("Failed to convert database list: %s", err)
+	}
+	data, _ := ioutil.ReadFile(directoryJson + "database1/table1.config")
+	if json.Valid(data) {
+		t.Errorf("Failed to convert table config: %s", data)
+	}
+
+	dbList, err = LoadDatabaseList(directoryJson, "toml")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, _ = dbList.Get("database1")
+	table, err = db.GetTable("table1")
+	if err != nil {
+		t.Fatalf("Failed to get table: %s", err)
+	}
+	rowNum, _ := table.WriteRow(Row{})
+	row, err := table.ReadRow(rowNum)
+	if err != nil || row["a"] != int64(2) {
+		t.Errorf("Failed to keep default: %v, %v", row, err)
+	}
+}
+
+func Test10_database_concurrent(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	defer dbList.Close()
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		_, err = db.NewTable(tabletype, tabletype, []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"a"}, false)
+		if err != nil {
+			t.Fatalf("Failed to create index: %s", err)
+		}
+	}
+
+	writers := 4
+	count := 50
+	errs := make(chan error, 100)
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, tabletype := range []string{"static", "dynamic"} {
+				table, err := db.GetTable(tabletype)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for j := 0; j < count; j++ {
+					rowNum, err := table.WriteRow(Row{"a": int64(i*count + j)})
+					if err != nil {
+						errs <- err
+						return
+					}
+					row, err := table.ReadRow(rowNum)
+					if err != nil || row["a"] != int64(i*count+j) {
+						errs <- fmt.Errorf("row %d of %s: %v, %v", rowNum, tabletype, row, err)
+						return
+					}
+				}
+			}
+		}(i)
+		//Scanning, creating tables and listing run with writers.
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.NewTable(fmt.Sprintf("table%d", i), "dynamic", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, err = dbList.NewDatabase(fmt.Sprintf("database%d", i+2))
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 0; j < 10; j++ {
+				dbList.Names()
+				db.TableNames()
+				it, err := db.Find("static")
+				if err != nil {
+					errs <- err
+					return
+				}
+				for it.Next() {
+				}
+				it.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Failed to use database concurrently: %s", err)
+	}
+
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, _ := db.GetTable(tabletype)
+		rows, err := table.CountRows()
+		if err != nil || rows != int64(writers*count) {
+			t.Errorf("Failed to write all rows of %s: %d, %v", tabletype, rows, err)
+		}
+		index, _ := db.GetIndex(tabletype, []string{"a"})
+		rowNums, err := index.Range([]interface{}{int64(0)}, []interface{}{int64(writers * count)})
+		if err != nil || len(rowNums) != writers*count {
+			t.Errorf("Failed to index all rows of %s: %d, %v", tabletype, len(rowNums), err)
+		}
+	}
+	if len(db.TableNames()) != 2+writers || len(dbList.Names()) != 1+writers {
+		t.Errorf("Failed to create tables and databases: %v, %v", db.TableNames(), dbList.Names())
+	}
+
+	//A table which is dropped can not be used through the old reference.
+	table, _ := db.GetTable("table0")
+	err = db.DropTable("table0")
+	if err != nil {
+		t.Fatalf("Failed to drop table: %s", err)
+	}
+	_, err = table.WriteRow(Row{"a": int64(1)})
+	if err != ErrTableClosed {
+		t.Errorf("Failed to refuse closed table: %v", err)
+	}
+	_, err = table.ReadRow(0)
+	if err != ErrTableClosed {
+		t.Errorf("Failed to refuse closed table: %v", err)
+	}
+}
+
+func Test11_database_writeRows(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{
+			{Name: "id", Type: COLUMN_INT64, Size: 64, Identity: true},
+			{Name: "name", Type: COLUMN_STRING, Size: 16},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"name"}, true)
+		if err != nil {
+			t.Fatalf("Failed to create index: %s", err)
+		}
+		rows := []Row{{"name": "a"}, {"name": "b"}, {"name": "c"}}
+		rowNums, err := table.WriteRows(rows)
+		if err != nil || len(rowNums) != 3 {
+			t.Fatalf("Failed to write rows: %v, %v", rowNums, err)
+		}
+		for i, rowNum := range rowNums {
+			row, err := table.ReadRow(rowNum)
+			if err != nil || rowNum != int64(i) || row["id"] != int64(i+1) || row["name"] != rows[i]["name"] {
+				t.Errorf("Failed to read written row: %d, %v, %v", rowNum, row, err)
+			}
+		}
+
+		//A batch is written as a whole, so a violation in the middle drops all rows.
+		_, err = table.WriteRows([]Row{{"name": "d"}, {"name": "a"}, {"name": "e"}})
+		if err != ErrDuplicateKey {
+			t.Errorf("Failed to refuse duplicated row: %v", err)
+		}
+		count, err := table.CountRows()
+		if err != nil || count != 3 {
+			t.Errorf("Failed to drop rows of failed batch: %d, %v", count, err)
+		}
+		index, _ := db.GetIndex(tabletype, []string{"name"})
+		found, err := index.Lookup("d")
+		if err != nil || len(found) != 0 {
+			t.Errorf("Failed to drop index entries of failed batch: %v, %v", found, err)
+		}
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, _ = dbList.Get("database1")
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, _ := db.GetTable(tabletype)
+		rowNum, row, err := table.WriteRowReturning(Row{"name": "f"})
+		if err != nil || rowNum != 3 || row["id"].(int64) <= 3 {
+			t.Errorf("Failed to write row after batches: %d, %v, %v", rowNum, row, err)
+		}
+
+		rows := []Row{}
+		for i := 0; i < 1000; i++ {
+			rows = append(rows, Row{"name": fmt.Sprintf("n%d", i)})
+		}
+		rowNums, err := table.WriteRows(rows)
+		if err != nil || len(rowNums) != 1000 || rowNums[999] != 1003 {
+			t.Fatalf("Failed to write many rows: %d, %v", len(rowNums), err)
+		}
+		rowNum, err = table.WriteRow(Row{"name": "g"})
+		if err != nil || rowNum != 1004 {
+			t.Errorf("Failed to write row after many rows: %d, %v", rowNum, err)
+		}
+		for i, name := range map[int64]string{4: "n0", 1003: "n999", 1004: "g"} {
+			row, err := table.ReadRow(i)
+			if err != nil || row["name"] != name {
+				t.Errorf("Failed to read row %d: %v, %v", i, row, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package tinydatabase
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+/*
+ Durability of a table decides when its commits are synced on disk.
+ DURABILITY_SYNC syncs the write-ahead log on each commit. Table files are synced when the log is emptied.
+ DURABILITY_GROUP makes commits of concurrent writers within the group commit window share one sync of the log.
+ The lock of table is released while a commit waits for the sync, so writers of the same table share it too.
+ The commit is not lost after it returns, but other goroutines can read it before.
+ DURABILITY_PERIODIC syncs nothing on commit. The log is synced every sync interval or by Flush.
+ A crash loses the commits after the last sync, and the table is left as it was at the last sync or a later commit.
+ Group and periodic commits are kept in memory and written on table files after the log is synced,
+ so a crash never leaves a torn row.
+ Tables which are not in a database always sync.
+ The group commit window and the sync interval are settings of the database.
+*/
+const (
+	DURABILITY_SYNC     string = "sync"
+	DURABILITY_GROUP    string = "group"
+	DURABILITY_PERIODIC string = "periodic"
+)
+
+var (
+	ErrInvalidDurability = errors.New("Specified durability is invalid")
+	ErrInvalidDuration   = errors.New("Specified duration is invalid")
+)
+
+const (
+	defaultGroupCommitWindow = 2 * time.Millisecond
+	defaultSyncInterval      = time.Second
+)
+
+//durabilityFilename is a file name of durability settings in database directory. Default settings are not saved.
+const durabilityFilename = "durability.config"
+
+//durabilityConfig is the content of durability.config. Durations are written like "2ms".
+type durabilityConfig struct {
+	GroupCommitWindow string `json:",omitempty" toml:",omitempty"`
+	SyncInterval      string `json:",omitempty" toml:",omitempty"`
+}
+
+//maxHeldWrites is the number of held writes of a file which makes a checkpoint before the next sync.
+const maxHeldWrites = 1024
+
+//durabilityRank orders durabilities from the weakest.
+var durabilityRank = map[string]int{
+	DURABILITY_PERIODIC: 0,
+	DURABILITY_GROUP:    1,
+	DURABILITY_SYNC:     2,
+}
+
+//durableTable is a table whose durability can be changed.
+type durableTable interface {
+	setDurability(durability string) error
+	getDurability() string
+}
+
+//SetDurability func changes durability of the table. The setting is saved in the config file.
+func (self *Database) SetDurability(tablename string, durability string) error {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return err
+	}
+	durable, ok := table.(durableTable)
+	if ok == false {
+		return ErrInvalidTabletype
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	return durable.setDurability(durability)
+}
+
+//GetDurability returns durability of the table.
+func (self *Database) GetDurability(tablename string) (string, error) {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return "", err
+	}
+	durable, ok := table.(durableTable)
+	if ok == false {
+		return "", ErrInvalidTabletype
+	}
+	lock := tableLock(table)
+	lock.RLock()
+	defer lock.RUnlock()
+	return durable.getDurability(), nil
+}
+
+//SetGroupCommitWindow func changes the time which group commits wait for other commits. The setting is saved in durability.config.
+func (self *Database) SetGroupCommitWindow(window time.Duration) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if window < 0 {
+		return ErrInvalidDuration
+	}
+	old := self.groupCommitWindow
+	self.groupCommitWindow = window
+	err := self.saveDurabilityConfig()
+	if err != nil {
+		self.groupCommitWindow = old
+		return err
+	}
+	self.wal.setGroupCommitWindow(window)
+	return nil
+}
+
+//GetGroupCommitWindow returns the time which group commits wait for other commits.
+func (self *Database) GetGroupCommitWindow() time.Duration {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.groupCommitWindow
+}
+
+//SetSyncInterval func changes the interval of syncs for periodic commits. The setting is saved in durability.config.
+func (self *Database) SetSyncInterval(interval time.Duration) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if interval <= 0 {
+		return ErrInvalidDuration
+	}
+	old := self.syncInterval
+	self.syncInterval = interval
+	err := self.saveDurabilityConfig()
+	if err != nil {
+		self.syncInterval = old
+		return err
+	}
+	self.wal.setSyncInterval(interval)
+	return nil
+}
+
+//GetSyncInterval returns the interval of syncs for periodic commits.
+func (self *Database) GetSyncInterval() time.Duration {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.syncInterval
+}
+
+//saveDurabilityConfig writes durability.config. The lock must be held.
+func (self *Database) saveDurabilityConfig() error {
+	config := durabilityConfig{}
+	if self.groupCommitWindow != defaultGroupCommitWindow {
+		config.GroupCommitWindow = self.groupCommitWindow.String()
+	}
+	if self.syncInterval != defaultSyncInterval {
+		config.SyncInterval = self.syncInterval.String()
+	}
+	bytes, err := encodeConfig(self.filetype, config)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(self.directory+"/"+durabilityFilename, bytes)
+}
+
+//loadDurabilityConfig reads durability.config. Settings which are not in the file are the defaults.
+func (self *Database) loadDurabilityConfig() error {
+	self.groupCommitWindow = defaultGroupCommitWindow
+	self.syncInterval = defaultSyncInterval
+	data, err := ioutil.ReadFile(self.directory + "/" + durabilityFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	config := durabilityConfig{}
+	err = decodeConfig(data, &config)
+	if err != nil {
+		return err
+	}
+	if config.GroupCommitWindow != "" {
+		self.groupCommitWindow, err = time.ParseDuration(config.GroupCommitWindow)
+		if err != nil || self.groupCommitWindow < 0 {
+			return ErrInvalidDuration
+		}
+	}
+	if config.SyncInterval != "" {
+		self.syncInterval, err = time.ParseDuration(config.SyncInterval)
+		if err != nil || self.syncInterval <= 0 {
+			return ErrInvalidDuration
+		}
+	}
+	return nil
+}
+
+/*
+ Flush func syncs all commits of the database on disk.
+ After it returns, commits of group and periodic durability are not lost by a crash.
+*/
+func (self *Database) Flush() error {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if self.wal == nil {
+		return nil
+	}
+	return self.wal.Flush()
+}
+
+//checkDurability returns ErrInvalidDurability when durability is not known.
+func checkDurability(durability string) error {
+	_, ok := durabilityRank[durability]
+	if ok == false {
+		return ErrInvalidDurability
+	}
+	return nil
+}
+
+//strongerDurability returns the durability which syncs more of a and b.
+func strongerDurability(a string, b string) string {
+	if durabilityRank[b] > durabilityRank[a] {
+		return b
+	}
+	return a
+}
+
+/*
+ commitFilesWith writes staged writes of files with durability, and returns the record to wait for by waitCommit.
+ Writes of group and periodic durability are logged without syncing the log, and held in memory until the next checkpoint.
+ Table files are written only after the log is synced, so a crash does not leave a torn commit in them.
+ Only group commits return a record to wait for. Others return 0.
+*/
+func commitFilesWith(wal *writeAheadLog, durability string, files ...*dataFile) (int64, error) {
+	if wal == nil || (durability != DURABILITY_GROUP && durability != DURABILITY_PERIODIC) {
+		return 0, commitFiles(wal, files...)
+	}
+	targets := stagedFiles(files)
+	if len(targets) == 0 {
+		return 0, nil
+	}
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+	err := wal.write(targets)
+	if err != nil {
+		rollbackFiles(targets...)
+		return 0, err
+	}
+	full := false
+	for _, f := range targets {
+		f.hold()
+		wal.dirty[f] = true
+		full = full || f.heldWrites() > maxHeldWrites
+	}
+	full = full || wal.size >= maxWalSize
+	if full {
+		//Reads overlay all held writes, so they are written on files before they become too many.
+		err = wal.checkpoint()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if durability == DURABILITY_GROUP {
+		return wal.appended, nil
+	}
+	return 0, nil
+}
+
+/*
+ waitCommit waits until the record of a group commit is synced on the log.
+ It is called after the lock of table is released, so other writers of the table can join the sync.
+*/
+func (self *writeAheadLog) waitCommit(seq int64) error {
+	if seq == 0 {
+		return nil
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.waitSynced(seq)
+}
+
+/*
+ waitSynced waits until records up to seq are synced on the log. The mutex must be held.
+ The first waiter syncs records for all waiters. When other commits are running,
+ it collects records written in the group commit window before the sync. A lone commit syncs at once.
+*/
+func (self *writeAheadLog) waitSynced(seq int64) error {
+	self.waiting++
+	defer func() { self.waiting-- }()
+	for self.synced < seq {
+		if self.syncing {
+			self.cond.Wait()
+			continue
+		}
+		self.syncing = true
+		if self.waiting > 1 || self.appended > seq {
+			window := self.window
+			self.mutex.Unlock()
+			time.Sleep(window)
+			self.mutex.Lock()
+		}
+		var err error
+		//A checkpoint may have synced the log while the first waiter slept.
+		if self.synced < self.appended {
+			target := self.appended
+			err = self.file.Sync()
+			if err == nil {
+				self.synced = target
+			}
+		}
+		self.syncing = false
+		self.cond.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Flush syncs all files written by commits and empties the log.
+func (self *writeAheadLog) Flush() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return nil
+	}
+	return self.flush()
+}
+
+//flush is Flush while the mutex is held. Nothing is written when the log is empty.
+func (self *writeAheadLog) flush() error {
+	if len(self.dirty) == 0 && self.size == 0 {
+		return nil
+	}
+	return self.checkpoint()
+}
+
+//setGroupCommitWindow changes the time which the first waiter of group commits sleeps.
+func (self *writeAheadLog) setGroupCommitWindow(window time.Duration) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.window = window
+}
+
+//setSyncInterval restarts the periodic sync with interval.
+func (self *writeAheadLog) setSyncInterval(interval time.Duration) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return
+	}
+	close(self.done)
+	self.done = make(chan bool)
+	go self.syncPeriodically(interval, self.done)
+}
+
+//syncPeriodically flushes the log every interval until done is closed.
+func (self *writeAheadLog) syncPeriodically(interval time.Duration, done chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			self.Flush()
+		case <-done:
+			return
+		}
+	}
+}
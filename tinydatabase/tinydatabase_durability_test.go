@@ -0,0 +1,185 @@
+package tinydatabase
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test1_Durability_modes(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	//The periodic sync does not run during the test.
+	err = db.SetSyncInterval(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to set sync interval: %s", err)
+	}
+	err = db.SetSyncInterval(0)
+	if err != ErrInvalidDuration {
+		t.Errorf("Failed to refuse invalid sync interval: %v", err)
+	}
+	err = db.SetGroupCommitWindow(-time.Millisecond)
+	if err != ErrInvalidDuration {
+		t.Errorf("Failed to refuse invalid group commit window: %v", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		_, err = db.NewTable(tabletype, tabletype, []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+	}
+	durability, err := db.GetDurability("static")
+	if err != nil || durability != DURABILITY_SYNC {
+		t.Errorf("Failed to get default durability: %s, %v", durability, err)
+	}
+	err = db.SetDurability("static", "never")
+	if err != ErrInvalidDurability {
+		t.Errorf("Failed to refuse invalid durability: %v", err)
+	}
+	err = db.SetDurability("static", DURABILITY_PERIODIC)
+	if err != nil {
+		t.Fatalf("Failed to set durability: %s", err)
+	}
+	err = db.SetDurability("dynamic", DURABILITY_GROUP)
+	if err != nil {
+		t.Fatalf("Failed to set durability: %s", err)
+	}
+	walFile := directoryJson + "database1/" + walFilename
+
+	//Commits of periodic durability stay in the log until Flush, and the table file is not written before.
+	static, _ := db.GetTable("static")
+	tableFile := directoryJson + "database1/static.table"
+	before, _ := os.Stat(tableFile)
+	_, err = static.WriteRow(Row{"a": int64(1)})
+	if err != nil {
+		t.Errorf("Failed to write row: %s", err)
+	}
+	info, _ := os.Stat(walFile)
+	if info.Size() == 0 {
+		t.Errorf("Failed to keep periodic commit in the log")
+	}
+	info, _ = os.Stat(tableFile)
+	if info.Size() != before.Size() {
+		t.Errorf("Failed to hold periodic commit until the log is synced: %d, %d", info.Size(), before.Size())
+	}
+	row, err := static.ReadRow(0)
+	if err != nil || row["a"] != int64(1) {
+		t.Errorf("Failed to read held row: %v, %v", row, err)
+	}
+	err = db.Flush()
+	if err != nil {
+		t.Errorf("Failed to flush: %s", err)
+	}
+	info, _ = os.Stat(walFile)
+	if info.Size() != 0 {
+		t.Errorf("Failed to empty the log by flush: %d", info.Size())
+	}
+	info, _ = os.Stat(tableFile)
+	if info.Size() == before.Size() {
+		t.Errorf("Failed to write held row by flush")
+	}
+
+	//Concurrent commits of group durability share syncs of the log.
+	dynamic, _ := db.GetTable("dynamic")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := dynamic.WriteRow(Row{"a": int64(i)})
+			if err != nil {
+				t.Errorf("Failed to write row: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	db.wal.mutex.Lock()
+	if db.wal.synced != db.wal.appended {
+		t.Errorf("Failed to sync group commits: %d, %d", db.wal.synced, db.wal.appended)
+	}
+	db.wal.mutex.Unlock()
+	count, err := dynamic.CountRows()
+	if err != nil || count != 8 {
+		t.Errorf("Failed to count rows of group commits: %d, %v", count, err)
+	}
+
+	//Writers of the same table do not hold its lock while waiting, so they share the window.
+	err = db.SetGroupCommitWindow(100 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to set group commit window: %s", err)
+	}
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := dynamic.WriteRow(Row{"a": int64(i)})
+			if err != nil {
+				t.Errorf("Failed to write row: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if elapsed > 400*time.Millisecond {
+		t.Errorf("Failed to share syncs between writers of the same table: %v", elapsed)
+	}
+
+	//A lone writer does not wait for the window.
+	err = db.SetGroupCommitWindow(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to set group commit window: %s", err)
+	}
+	start = time.Now()
+	_, err = dynamic.WriteRow(Row{"a": int64(8)})
+	if err != nil {
+		t.Errorf("Failed to write row: %s", err)
+	}
+	elapsed = time.Since(start)
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("Failed to sync lone group commit at once: %v", elapsed)
+	}
+
+	//A transaction is synced as the strongest table.
+	tx, _ := db.Begin()
+	tx.WriteRow("static", Row{"a": int64(2)})
+	err = tx.Commit()
+	if err != nil {
+		t.Errorf("Failed to commit transaction: %s", err)
+	}
+	dbList.Close()
+	info, _ = os.Stat(walFile)
+	if info.Size() != 0 {
+		t.Errorf("Failed to flush on closing: %d", info.Size())
+	}
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, _ = dbList.Get("database1")
+	durability, err = db.GetDurability("dynamic")
+	if err != nil || durability != DURABILITY_GROUP {
+		t.Errorf("Failed to save durability: %s, %v", durability, err)
+	}
+	if db.GetSyncInterval() != time.Hour || db.GetGroupCommitWindow() != time.Second {
+		t.Errorf("Failed to save durability settings: %v, %v", db.GetSyncInterval(), db.GetGroupCommitWindow())
+	}
+	static, _ = db.GetTable("static")
+	count, err = static.CountRows()
+	if err != nil || count != 2 {
+		t.Errorf("Failed to count rows after loading: %d, %v", count, err)
+	}
+}
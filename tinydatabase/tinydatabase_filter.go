@@ -0,0 +1,390 @@
+package tinydatabase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+ Filter is a condition on a column of rows.
+ For json column, Path selects a value in the document such as "$.customer.id".
+ NULL and missing values only match FILTER_EQ with nil Value.
+ FILTER_CONTAINS matches an array column or JSON array which has Value as an element.
+*/
+type Filter struct {
+	Column string
+	Path   string
+	Op     string
+	Value  interface{}
+}
+
+const (
+	FILTER_EQ string = "="
+	FILTER_NE string = "!="
+	FILTER_LT string = "<"
+	FILTER_LE string = "<="
+	FILTER_GT string = ">"
+	FILTER_GE string = ">="
+
+	FILTER_CONTAINS string = "contains"
+)
+
+var (
+	ErrInvalidFilter   = errors.New("Specified filter is invalid")
+	ErrInvalidJSONPath = errors.New("Specified JSON path is invalid")
+)
+
+//Find func returns rows of the table which match all filters.
+func (self *Database) Find(tablename string, filters ...Filter) (RowIterator, error) {
+	table, err := self.GetTable(tablename)
+	if err != nil {
+		return nil, err
+	}
+	return FindRows(table, filters...)
+}
+
+/*
+ FindRows returns rows of table which match all filters.
+ Rows are scanned from the first to the last.
+*/
+func FindRows(table TableInterface, filters ...Filter) (RowIterator, error) {
+	compiled := []*compiledFilter{}
+	for _, v := range filters {
+		c, err := compileFilter(table.GetColumns(), v)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	it, err := table.Scan()
+	if err != nil {
+		return nil, err
+	}
+	return &filterIterator{it: it, filters: compiled}, nil
+}
+
+//**************************************************
+
+//compiledFilter is a filter whose value is converted for the column.
+type compiledFilter struct {
+	column ColumnType
+	path   []interface{}
+	op     string
+	key    []byte      //Key of value for column other than json
+	value  interface{} //Decoded JSON of value for json column
+}
+
+func compileFilter(columnTypes []ColumnType, filter Filter) (*compiledFilter, error) {
+	result := &compiledFilter{op: filter.Op}
+	switch filter.Op {
+	case FILTER_EQ, FILTER_NE, FILTER_LT, FILTER_LE, FILTER_GT, FILTER_GE, FILTER_CONTAINS:
+	default:
+		return nil, ErrInvalidFilter
+	}
+	found := false
+	for _, v := range columnTypes {
+		if v.Name == filter.Column {
+			result.column = v
+			found = true
+		}
+	}
+	if found == false {
+		return nil, ErrColumnNotExist
+	}
+	if result.column.Type != COLUMN_JSON {
+		if filter.Path != "" {
+			return nil, ErrInvalidJSONPath
+		}
+		//Elements of array column are compared with the value.
+		if result.column.isArray() != (filter.Op == FILTER_CONTAINS) {
+			return nil, ErrInvalidFilter
+		}
+		column := result.column
+		if column.isArray() {
+			column = column.elementType()
+		}
+		key, err := column.ConvertToKey(filter.Value)
+		if err != nil {
+			return nil, err
+		}
+		result.key = key
+		return result, nil
+	}
+	path, err := parseJSONPath(filter.Path)
+	if err != nil {
+		return nil, err
+	}
+	result.path = path
+	if filter.Value != nil {
+		b, err := result.column.ConvertToBytes(filter.Value)
+		if err != nil {
+			return nil, err
+		}
+		result.value, err = result.column.ConvertToVal(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+//match returns whether row satisfies the filter.
+func (self *compiledFilter) match(row Row) (bool, error) {
+	val := row[self.column.Name]
+	if self.column.isArray() {
+		return self.matchElement(val)
+	}
+	if self.column.Type != COLUMN_JSON {
+		if val == nil || self.key[0] == 0 {
+			return self.matchNull(val == nil && self.key[0] == 0), nil
+		}
+		key, err := self.column.ConvertToKey(val)
+		if err != nil {
+			return false, err
+		}
+		return self.matchOrder(bytes.Compare(key, self.key)), nil
+	}
+	//JSON null is treated as NULL.
+	val, ok := extractJSONPath(val, self.path)
+	if ok == false || val == nil || self.value == nil {
+		return self.matchNull(ok && val == nil && self.value == nil), nil
+	}
+	if self.op == FILTER_CONTAINS {
+		elements, ok := val.([]interface{})
+		if ok == false {
+			return false, nil
+		}
+		for _, v := range elements {
+			cmp, _ := compareJSON(v, self.value)
+			if cmp == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	cmp, ordered := compareJSON(val, self.value)
+	if ordered == false && self.op != FILTER_EQ && self.op != FILTER_NE {
+		return false, nil
+	}
+	return self.matchOrder(cmp), nil
+}
+
+//matchElement returns whether array of row has the value of filter.
+func (self *compiledFilter) matchElement(val interface{}) (bool, error) {
+	if val == nil {
+		return false, nil
+	}
+	element := self.column.elementType()
+	v := reflect.ValueOf(val)
+	for i := 0; i < v.Len(); i++ {
+		key, err := element.ConvertToKey(v.Index(i).Interface())
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(key, self.key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//matchNull returns the result when NULL is compared.
+func (self *compiledFilter) matchNull(bothNull bool) bool {
+	return self.op == FILTER_EQ && bothNull
+}
+
+//matchOrder returns the result from the comparison of row value with filter value.
+func (self *compiledFilter) matchOrder(cmp int) bool {
+	switch self.op {
+	case FILTER_EQ:
+		return cmp == 0
+	case FILTER_NE:
+		return cmp != 0
+	case FILTER_LT:
+		return cmp < 0
+	case FILTER_LE:
+		return cmp <= 0
+	case FILTER_GT:
+		return cmp > 0
+	case FILTER_GE:
+		return cmp >= 0
+	}
+	return false
+}
+
+//filterIterator skips rows which do not match filters.
+type filterIterator struct {
+	it      RowIterator
+	filters []*compiledFilter
+	err     error
+}
+
+func (self *filterIterator) Next() bool {
+	if self.err != nil {
+		return false
+	}
+	for self.it.Next() {
+		matched := true
+		for _, v := range self.filters {
+			ok, err := v.match(self.it.Row())
+			if err != nil {
+				self.err = err
+				return false
+			}
+			if ok == false {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *filterIterator) Row() Row {
+	return self.it.Row()
+}
+
+func (self *filterIterator) RowNum() int64 {
+	return self.it.RowNum()
+}
+
+func (self *filterIterator) Err() error {
+	if self.err != nil {
+		return self.err
+	}
+	return self.it.Err()
+}
+
+func (self *filterIterator) Close() error {
+	return self.it.Close()
+}
+
+/*
+ parseJSONPath parses a path such as "$.customer.id" or "$.items[0].name".
+ An empty path is the whole document.
+ Elements of result are string for object member and int for array index.
+*/
+func parseJSONPath(path string) ([]interface{}, error) {
+	result := []interface{}{}
+	if path == "" || path == "$" {
+		return result, nil
+	}
+	if strings.HasPrefix(path, "$") == false {
+		return nil, ErrInvalidJSONPath
+	}
+	rest := path[1:]
+	for len(rest) > 0 {
+		if rest[0] == '.' {
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return nil, ErrInvalidJSONPath
+			}
+			result = append(result, name)
+			rest = rest[end+1:]
+		} else if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, ErrInvalidJSONPath
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return nil, ErrInvalidJSONPath
+			}
+			result = append(result, n)
+			rest = rest[end+1:]
+		} else {
+			return nil, ErrInvalidJSONPath
+		}
+	}
+	return result, nil
+}
+
+//extractJSONPath returns the value at path in decoded JSON. Returns false when it is not found.
+func extractJSONPath(val interface{}, path []interface{}) (interface{}, bool) {
+	for _, p := range path {
+		switch vi := val.(type) {
+		case map[string]interface{}:
+			name, ok := p.(string)
+			if ok == false {
+				return nil, false
+			}
+			val, ok = vi[name]
+			if ok == false {
+				return nil, false
+			}
+		case []interface{}:
+			n, ok := p.(int)
+			if ok == false || n >= len(vi) {
+				return nil, false
+			}
+			val = vi[n]
+		default:
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+/*
+ compareJSON compares decoded JSON values.
+ Numbers, strings and booleans of the same kind are ordered.
+ Other values are only equal or not, and ordered is false.
+*/
+func compareJSON(a interface{}, b interface{}) (cmp int, ordered bool) {
+	switch ai := a.(type) {
+	case json.Number:
+		bi, ok := b.(json.Number)
+		if ok == false {
+			return 1, false
+		}
+		ia, errA := ai.Int64()
+		ib, errB := bi.Int64()
+		if errA == nil && errB == nil {
+			return compareOrder(ia < ib, ia > ib), true
+		}
+		fa, errA := ai.Float64()
+		fb, errB := bi.Float64()
+		if errA != nil || errB != nil {
+			return 1, false
+		}
+		return compareOrder(fa < fb, fa > fb), true
+	case string:
+		bi, ok := b.(string)
+		if ok == false {
+			return 1, false
+		}
+		return strings.Compare(ai, bi), true
+	case bool:
+		bi, ok := b.(bool)
+		if ok == false {
+			return 1, false
+		}
+		return compareOrder(ai == false && bi, ai && bi == false), true
+	}
+	ba, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	if errA != nil || errB != nil || bytes.Equal(ba, bb) == false {
+		return 1, false
+	}
+	return 0, false
+}
+
+func compareOrder(less bool, greater bool) int {
+	if less {
+		return -1
+	} else if greater {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package tinydatabase
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test1_Filter_basicUsage(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	_, err = db.NewTable("invalid", "static", []ColumnType{{Name: "payload", Type: COLUMN_JSON}})
+	if err == nil {
+		t.Errorf("Failed to refuse json column in static table")
+	}
+	table, err := db.NewTable("orders", "dynamic", []ColumnType{
+		{Name: "id", Type: COLUMN_INT64, Size: 64},
+		{Name: "payload", Type: COLUMN_JSON, Nullable: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	payloads := []interface{}{
+		map[string]interface{}{"customer": map[string]interface{}{"id": 1, "name": "alice"}, "items": []interface{}{"apple", "banana"}},
+		json.RawMessage(`{"customer": {"id": 2, "name": "bob"}, "items": ["cherry"], "gift": true}`),
+		json.RawMessage(`{"customer": {"id": 12345678901234567, "name": "carol"}, "items": []}`),
+		nil,
+		"text",
+	}
+	for i, v := range payloads {
+		_, err = table.WriteRow(Row{"id": int64(i), "payload": v})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	_, err = table.WriteRow(Row{"id": int64(9), "payload": json.RawMessage(`{"broken":`)})
+	if err == nil {
+		t.Errorf("Failed to refuse invalid JSON")
+	}
+	err = db.CreateIndex("orders", []string{"payload"}, false)
+	if err == nil {
+		t.Errorf("Failed to refuse index on json column")
+	}
+
+	row, err := table.ReadRow(1)
+	if err != nil {
+		t.Fatalf("Failed to read row: %s", err)
+	}
+	doc, ok := row["payload"].(map[string]interface{})
+	if ok == false || doc["gift"] != true || doc["customer"].(map[string]interface{})["id"] != json.Number("2") {
+		t.Errorf("Failed to decode JSON: %v", row["payload"])
+	}
+	row, _ = table.ReadRow(2)
+	b, _ := json.Marshal(row["payload"])
+	if string(b) != `{"customer":{"id":12345678901234567,"name":"carol"},"items":[]}` {
+		t.Errorf("Failed to keep JSON: %s", b)
+	}
+
+	tests := []struct {
+		filters []Filter
+		rows    []int64
+	}{
+		{[]Filter{{Column: "payload", Path: "$.customer.id", Op: FILTER_EQ, Value: 2}}, []int64{1}},
+		{[]Filter{{Column: "payload", Path: "$.customer.id", Op: FILTER_GT, Value: 1}}, []int64{1, 2}},
+		{[]Filter{{Column: "payload", Path: "$.customer.id", Op: FILTER_EQ, Value: int64(12345678901234567)}}, []int64{2}},
+		{[]Filter{{Column: "payload", Path: "$.customer.name", Op: FILTER_LE, Value: "bob"}}, []int64{0, 1}},
+		{[]Filter{{Column: "payload", Path: "$.items[0]", Op: FILTER_EQ, Value: "cherry"}}, []int64{1}},
+		{[]Filter{{Column: "payload", Path: "$.gift", Op: FILTER_EQ, Value: true}}, []int64{1}},
+		{[]Filter{{Column: "payload", Path: "$", Op: FILTER_EQ, Value: "text"}}, []int64{4}},
+		{[]Filter{{Column: "payload", Op: FILTER_EQ, Value: nil}}, []int64{3}},
+		{[]Filter{{Column: "payload", Path: "$.items", Op: FILTER_EQ, Value: []string{"cherry"}}}, []int64{1}},
+		{[]Filter{{Column: "payload", Path: "$.customer.id", Op: FILTER_NE, Value: 2}, {Column: "id", Op: FILTER_LT, Value: 2}}, []int64{0}},
+		{[]Filter{{Column: "id", Op: FILTER_GE, Value: 3}}, []int64{3, 4}},
+	}
+	for i, v := range tests {
+		it, err := db.Find("orders", v.filters...)
+		if err != nil {
+			t.Errorf("Failed to find rows %d: %s", i, err)
+			continue
+		}
+		rows := []int64{}
+		for it.Next() {
+			rows = append(rows, it.RowNum())
+		}
+		if it.Err() != nil || len(rows) != len(v.rows) {
+			t.Errorf("Failed to find rows %d: %v, %v", i, rows, it.Err())
+		} else {
+			for j := range rows {
+				if rows[j] != v.rows[j] {
+					t.Errorf("Failed to find rows %d: %v", i, rows)
+					break
+				}
+			}
+		}
+		it.Close()
+	}
+
+	_, err = db.Find("orders", Filter{Column: "payload", Path: "customer", Op: FILTER_EQ, Value: 1})
+	if err != ErrInvalidJSONPath {
+		t.Errorf("Failed to check JSON path: %v", err)
+	}
+	_, err = db.Find("orders", Filter{Column: "id", Path: "$.a", Op: FILTER_EQ, Value: 1})
+	if err != ErrInvalidJSONPath {
+		t.Errorf("Failed to refuse JSON path for int64 column: %v", err)
+	}
+	_, err = db.Find("orders", Filter{Column: "id", Op: "like", Value: 1})
+	if err != ErrInvalidFilter {
+		t.Errorf("Failed to check operator: %v", err)
+	}
+	_, err = db.Find("orders", Filter{Column: "nocolumn", Op: FILTER_EQ, Value: 1})
+	if err != ErrColumnNotExist {
+		t.Errorf("Failed to check column name: %v", err)
+	}
+	dbList.Close()
+}
+
+func Test2_Filter_contains(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	_, err = db.NewTable("invalid", "static", []ColumnType{{Name: "tags", Type: COLUMN_STRING_ARRAY}})
+	if err == nil {
+		t.Errorf("Failed to refuse array column in static table")
+	}
+	table, err := db.NewTable("posts", "dynamic", []ColumnType{
+		{Name: "ids", Type: COLUMN_INT64_ARRAY},
+		{Name: "tags", Type: COLUMN_STRING_ARRAY, Nullable: true},
+		{Name: "times", Type: COLUMN_TIME_ARRAY},
+		{Name: "payload", Type: COLUMN_JSON},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	t1 := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	rows := []Row{
+		{"ids": []int64{1, 2, 3}, "tags": []string{"go", "db"}, "times": []time.Time{t1}, "payload": json.RawMessage(`{"tags":["go"]}`)},
+		{"ids": []int64{}, "tags": nil, "times": []interface{}{"2020-01-02T03:04:05Z"}, "payload": json.RawMessage(`{"tags":[]}`)},
+		{"ids": []interface{}{3, 4}, "tags": []interface{}{"db", ""}, "times": []time.Time{}, "payload": json.RawMessage(`{"tags":"go"}`)},
+	}
+	for _, v := range rows {
+		_, err = table.WriteRow(v)
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	row, err := table.ReadRow(0)
+	if err != nil || len(row["ids"].([]int64)) != 3 || row["tags"].([]string)[1] != "db" || row["times"].([]time.Time)[0].Equal(t1) == false {
+		t.Errorf("Failed to read arrays: %v, %v", row, err)
+	}
+	row, _ = table.ReadRow(1)
+	if len(row["ids"].([]int64)) != 0 || row["tags"] != nil {
+		t.Errorf("Failed to read empty array and NULL: %v", row)
+	}
+	err = table.UpdateRow(1, Row{"ids": []int64{5, 6, 7, 8, 9, 10}, "tags": []string{"long tag", "go"}, "times": []time.Time{t1, t1}, "payload": nil})
+	if err != nil {
+		t.Errorf("Failed to update row: %s", err)
+	}
+	row, _ = table.ReadRow(1)
+	if len(row["ids"].([]int64)) != 6 || row["tags"].([]string)[0] != "long tag" || len(row["times"].([]time.Time)) != 2 {
+		t.Errorf("Failed to read updated arrays: %v", row)
+	}
+
+	tests := []struct {
+		filter Filter
+		rows   string
+	}{
+		{Filter{Column: "ids", Op: FILTER_CONTAINS, Value: 3}, "[0 2]"},
+		{Filter{Column: "tags", Op: FILTER_CONTAINS, Value: "go"}, "[0 1]"},
+		{Filter{Column: "tags", Op: FILTER_CONTAINS, Value: ""}, "[2]"},
+		{Filter{Column: "times", Op: FILTER_CONTAINS, Value: "2020-01-02T03:04:05.000000006Z"}, "[0 1]"},
+		{Filter{Column: "payload", Path: "$.tags", Op: FILTER_CONTAINS, Value: "go"}, "[0]"},
+	}
+	for i, v := range tests {
+		it, err := db.Find("posts", v.filter)
+		if err != nil {
+			t.Errorf("Failed to find rows %d: %s", i, err)
+			continue
+		}
+		found := []int64{}
+		for it.Next() {
+			found = append(found, it.RowNum())
+		}
+		it.Close()
+		if it.Err() != nil || fmt.Sprint(found) != v.rows {
+			t.Errorf("Failed to find rows %d: %v, %v", i, found, it.Err())
+		}
+	}
+	_, err = db.Find("posts", Filter{Column: "ids", Op: FILTER_EQ, Value: 3})
+	if err != ErrInvalidFilter {
+		t.Errorf("Failed to refuse comparison of array: %v", err)
+	}
+	_, err = db.Find("posts", Filter{Column: "ids", Op: FILTER_CONTAINS, Value: "x"})
+	if err == nil {
+		t.Errorf("Failed to check type of value")
+	}
+	dbList.Close()
+}
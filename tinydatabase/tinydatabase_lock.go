@@ -0,0 +1,122 @@
+package tinydatabase
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ DatabaseInUseError is returned when the directory of DatabaseList is locked by other process.
+ Pid is the process which opened the list writable. Pid is 0 when the list is opened read-only.
+*/
+type DatabaseInUseError struct {
+	Pid int
+}
+
+func (self *DatabaseInUseError) Error() string {
+	if self.Pid == 0 {
+		return "Database is in use by read-only process"
+	}
+	return fmt.Sprintf("Database is in use by pid %d", self.Pid)
+}
+
+var (
+	ErrReadOnly       = errors.New("Database is opened read-only")
+	ErrRecoveryNeeded = errors.New("Database needs recovery. Open it writable")
+	errLocked         = errors.New("File is locked")
+)
+
+//lockFilename is a file name of the lock of DatabaseList in its directory.
+const lockFilename = "databases.lock"
+
+/*
+ lockDirectory takes the advisory lock of DatabaseList directory.
+ The writable list takes the exclusive lock and writes its pid in the lock file.
+ The read-only list takes the shared lock, so read-only processes can open the list at the same time.
+*/
+func lockDirectory(directory string, readOnly bool) (*os.File, error) {
+	flag := os.O_RDWR + os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY + os.O_CREATE
+	}
+	f, err := os.OpenFile(directory+"/"+lockFilename, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(f, readOnly)
+	if err == errLocked {
+		pid := 0
+		//When the shared lock can be taken, the list is held by read-only processes.
+		if readOnly == true || lockFile(f, true) == errLocked {
+			pid = readLockPid(f)
+		}
+		f.Close()
+		return nil, &DatabaseInUseError{Pid: pid}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if readOnly == false {
+		err = f.Truncate(0)
+		if err == nil {
+			_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+//unlockDirectory releases the lock taken by lockDirectory.
+func unlockDirectory(f *os.File, readOnly bool) error {
+	if f == nil {
+		return nil
+	}
+	if readOnly == false {
+		//The pid is removed, so it is not reported after this process ends.
+		f.Truncate(0)
+	}
+	return f.Close()
+}
+
+//readLockPid returns the pid written in the lock file. Returns 0 when it can not be read.
+func readLockPid(f *os.File) int {
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+//checkRecovered returns ErrRecoveryNeeded when any of files which are left by a crash exists.
+func checkRecovered(filenames ...string) error {
+	for _, filename := range filenames {
+		_, err := os.Stat(filename)
+		if err == nil {
+			return ErrRecoveryNeeded
+		}
+	}
+	return nil
+}
+
+//openFlag returns the flag to open files of a table.
+func openFlag(readOnly bool) int {
+	if readOnly {
+		return os.O_RDONLY
+	}
+	return os.O_RDWR + os.O_CREATE
+}
@@ -0,0 +1,253 @@
+package tinydatabase
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test1_Lock_inUse(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	table, err := db.NewTable("table1", "static", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	table.WriteRow(Row{"a": int64(1)})
+
+	for _, load := range []func(string, string) (*DatabaseList, error){LoadDatabaseList, LoadDatabaseListReadOnly} {
+		_, err = load(directoryJson, "json")
+		inUse, ok := err.(*DatabaseInUseError)
+		if ok == false || inUse.Pid != os.Getpid() {
+			t.Errorf("Failed to refuse database in use: %v", err)
+		}
+	}
+	dbList.Close()
+
+	//Read-only processes share the directory.
+	readOnly1, err := LoadDatabaseListReadOnly(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list read-only:%s", err)
+	}
+	readOnly2, err := LoadDatabaseListReadOnly(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list read-only twice:%s", err)
+	}
+	_, err = LoadDatabaseList(directoryJson, "json")
+	inUse, ok := err.(*DatabaseInUseError)
+	if ok == false || inUse.Pid != 0 {
+		t.Errorf("Failed to refuse database opened read-only: %v", err)
+	}
+	readOnly2.Close()
+	readOnly1.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list after it is closed:%s", err)
+	}
+	dbList.Close()
+}
+
+func Test2_Lock_readOnly(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		table.WriteRow(Row{"a": int64(1)})
+		db.CreateIndex(tabletype, []string{"a"}, false)
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseListReadOnly(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list read-only:%s", err)
+	}
+	db, err = dbList.Get("database1")
+	if err != nil {
+		t.Fatalf("Failed to get database: %s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.GetTable(tabletype)
+		if err != nil {
+			t.Fatalf("Failed to get table: %s", err)
+		}
+		row, err := table.ReadRow(0)
+		if err != nil || row["a"] != int64(1) {
+			t.Errorf("Failed to read row of %s: %v, %v", tabletype, row, err)
+		}
+		_, err = table.WriteRow(Row{"a": int64(2)})
+		if err != ErrReadOnly {
+			t.Errorf("Failed to refuse writing %s: %v", tabletype, err)
+		}
+		err = table.DeleteRow(0)
+		if err != ErrReadOnly {
+			t.Errorf("Failed to refuse deleting %s: %v", tabletype, err)
+		}
+		index, err := db.GetIndex(tabletype, []string{"a"})
+		if err != nil {
+			t.Fatalf("Failed to get index: %s", err)
+		}
+		rows, err := index.Lookup(int64(1))
+		if err != nil || len(rows) != 1 {
+			t.Errorf("Failed to look up index of %s: %v, %v", tabletype, rows, err)
+		}
+	}
+	_, err = db.NewTable("table2", "static", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+	if err != ErrReadOnly {
+		t.Errorf("Failed to refuse creating table: %v", err)
+	}
+	_, err = db.Begin()
+	if err != ErrReadOnly {
+		t.Errorf("Failed to refuse transaction: %v", err)
+	}
+	err = db.DropTable("static")
+	if err != ErrReadOnly {
+		t.Errorf("Failed to refuse dropping table: %v", err)
+	}
+	_, err = dbList.NewDatabase("database2")
+	if err != ErrReadOnly {
+		t.Errorf("Failed to refuse creating database: %v", err)
+	}
+	dbList.Close()
+
+	//Files left by a crash are not recovered by read-only loading.
+	ioutil.WriteFile(directoryJson+"database1/"+journalFilename, []byte("{\"Op\":\"drop\",\"Name\":\"static\"}"), 0666)
+	_, err = LoadDatabaseListReadOnly(directoryJson, "json")
+	if err != ErrRecoveryNeeded {
+		t.Errorf("Failed to refuse database to recover: %v", err)
+	}
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to recover database list:%s", err)
+	}
+	dbList.Close()
+	dbList, err = LoadDatabaseListReadOnly(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load recovered database list read-only:%s", err)
+	}
+	defer dbList.Close()
+}
+
+//copyDirectory copies files of src to dst recursively.
+func copyDirectory(t *testing.T, src string, dst string) {
+	os.MkdirAll(dst, 0777)
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %s", err)
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			copyDirectory(t, src+"/"+info.Name(), dst+"/"+info.Name())
+			continue
+		}
+		err = copyFile(src+"/"+info.Name(), dst+"/"+info.Name())
+		if err != nil {
+			t.Fatalf("Failed to copy file: %s", err)
+		}
+	}
+}
+
+func Test3_Lock_readOnlyBaseline(t *testing.T) {
+	//testdata_baseline is a database made before tables had a free list and a write-ahead log.
+	directory, err := ioutil.TempDir("", "tinydatabase")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	copyDirectory(t, "./testdata_baseline", directory)
+
+	dbList, err := LoadDatabaseListReadOnly(directory, "json")
+	if err != nil {
+		t.Fatalf("Failed to load baseline database list read-only:%s", err)
+	}
+	defer dbList.Close()
+	db, err := dbList.Get("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to get baseline database: %s", err)
+	}
+	table, err := db.GetTable("testtable")
+	if err != nil {
+		t.Fatalf("Failed to get baseline table: %s", err)
+	}
+	count, err := table.CountRows()
+	if err != nil || count != 1 {
+		t.Errorf("Failed to count rows of baseline table: %d, %v", count, err)
+	}
+	_, err = table.WriteRow(Row{"column1": int64(1)})
+	if err != ErrReadOnly {
+		t.Errorf("Failed to refuse write to read-only table: %v", err)
+	}
+	_, err = os.Stat(directory + "/testdatabase/testtable.free")
+	if err == nil {
+		t.Errorf("Failed to keep baseline table without writing")
+	}
+}
+
+func Test4_Lock_loadFailed(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, name := range []string{"table1", "table2", "table3"} {
+		_, err = db.NewTable(name, "static", []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+	}
+	dbList.Close()
+
+	err = ioutil.WriteFile(directoryJson+"database1/table2.table", []byte("broken table header, not a file version of any table"), 0666)
+	if err != nil {
+		t.Fatalf("Failed to break table: %s", err)
+	}
+	files, _ := ioutil.ReadDir("/proc/self/fd")
+	//Tables opened before the broken one are closed and the lock is released, so loading again is not refused.
+	for i := 0; i < 2; i++ {
+		_, err = LoadDatabaseList(directoryJson, "json")
+		if err == nil {
+			t.Fatalf("Failed to refuse broken table")
+		}
+		_, inUse := err.(*DatabaseInUseError)
+		if inUse {
+			t.Fatalf("Failed to release lock of failed load: %v", err)
+		}
+	}
+	if files != nil {
+		after, _ := ioutil.ReadDir("/proc/self/fd")
+		if len(after) != len(files) {
+			t.Errorf("Failed to close files of failed load: %d files before, %d after", len(files), len(after))
+		}
+	}
+	os.RemoveAll(directoryJson)
+}
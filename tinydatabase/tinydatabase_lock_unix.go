@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package tinydatabase
+
+import (
+	"os"
+	"syscall"
+)
+
+//lockFile takes the lock of f without waiting. Returns errLocked when other process holds it.
+func lockFile(f *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(f.Fd()), how+syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
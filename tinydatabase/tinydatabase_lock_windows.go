@@ -0,0 +1,40 @@
+//go:build windows
+// +build windows
+
+package tinydatabase
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+	errorLockViolation      = syscall.Errno(33)
+	/*
+	 lockOffsetHigh is the high word of offset of the locked byte.
+	 Locked range can not be read by other processes, so the byte is far beyond the pid in the lock file.
+	*/
+	lockOffsetHigh = 0x40000000
+)
+
+//lockFile takes the lock of f without waiting. Returns errLocked when other process holds it.
+func lockFile(f *os.File, shared bool) error {
+	flags := uint32(lockfileFailImmediately)
+	if shared == false {
+		flags |= lockfileExclusiveLock
+	}
+	overlapped := &syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+	r, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLocked
+	}
+	return err
+}
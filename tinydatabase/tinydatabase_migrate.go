@@ -0,0 +1,232 @@
+package tinydatabase
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+/*
+ Upgrade mode decides what Database.Load does with tables whose files are old versions.
+ It is given by LoadDatabaseListWithUpgradeMode or Database.LoadWithUpgradeMode, and kept by the database.
+ UPGRADE_NONE opens them as they are. UPGRADE_BACKUP upgrades them after copying their files to the backup directory.
+ UPGRADE_READONLY opens them read-only without writing any file, so they are not changed until Database.Upgrade is called.
+*/
+const (
+	UPGRADE_NONE     string = "none"
+	UPGRADE_BACKUP   string = "backup"
+	UPGRADE_READONLY string = "readonly"
+)
+
+var (
+	ErrInvalidUpgradeMode = errors.New("Specified upgrade mode is invalid")
+)
+
+//backupDirname is the directory in the directory of Database where files of upgraded tables are copied.
+const backupDirname = "backup"
+
+/*
+ migrations maps an old version of table file to the version which it is upgraded to.
+ Tables are upgraded by rewriting their files like AlterTable, so row numbers and indexes do not change.
+*/
+var migrations = map[int64]int64{
+	STATIC1:        STATIC3,
+	STATIC2:        STATIC3,
+	DYNAMIC1_TABLE: DYNAMIC3_TABLE,
+	DYNAMIC2_TABLE: DYNAMIC3_TABLE,
+}
+
+//upgradableTable is a table whose files can be rewritten in the latest version.
+type upgradableTable interface {
+	getFileVersion() int64
+	setReadOnly(readOnly bool)
+	open(directory string, tablename string) error
+	alterTable(changes []ColumnChange) error
+}
+
+//needsUpgrade returns whether files of the table are an old version.
+func needsUpgrade(table TableInterface) bool {
+	upgradable, ok := table.(upgradableTable)
+	if ok == false {
+		return false
+	}
+	lock := tableLock(table)
+	lock.RLock()
+	defer lock.RUnlock()
+	_, ok = migrations[upgradable.getFileVersion()]
+	return ok
+}
+
+//OldTables returns sorted names of tables whose files are old versions.
+func (self *Database) OldTables() []string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	result := []string{}
+	for name, table := range self.tables {
+		if needsUpgrade(table) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+/*
+ Upgrade func rewrites files of the tables in the latest version.
+ When no table is specified, all tables of old versions are upgraded.
+ Files of each table are copied to the backup directory of the database before they are rewritten.
+ Tables opened read-only by UPGRADE_READONLY become writable.
+*/
+func (self *Database) Upgrade(tablenames ...string) error {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if len(tablenames) == 0 {
+		for name, table := range self.tables {
+			if needsUpgrade(table) {
+				tablenames = append(tablenames, name)
+			}
+		}
+		sort.Strings(tablenames)
+	}
+	for _, name := range tablenames {
+		table, err := self.getTable(name)
+		if err != nil {
+			return err
+		}
+		err = self.upgradeTable(name, table)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ upgradeTable copies the files of the table to the backup directory and rewrites them.
+ A table of the latest version is not changed.
+*/
+func (self *Database) upgradeTable(tablename string, table TableInterface) error {
+	upgradable, ok := table.(upgradableTable)
+	if ok == false {
+		return ErrInvalidTabletype
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	version := upgradable.getFileVersion()
+	_, ok = migrations[version]
+	if ok == false {
+		return nil
+	}
+	txT, ok := table.(txTable)
+	if ok == true && txT.getTransaction() != nil {
+		return ErrTableInTx
+	}
+	err := self.backupTable(tablename, version)
+	if err != nil {
+		return err
+	}
+	//A table opened read-only by UPGRADE_READONLY is reopened writable.
+	upgradable.setReadOnly(false)
+	err = upgradable.open(self.directory, tablename)
+	if err != nil {
+		return err
+	}
+	return upgradable.alterTable(nil)
+}
+
+/*
+ backupTable copies files of the table to the backup directory.
+ Names of the copies have the version of the table file, e.g. backup/table1.table.v2.
+*/
+func (self *Database) backupTable(tablename string, version int64) error {
+	directory := self.directory + "/"
+	backupDirectory := directory + backupDirname
+	err := os.MkdirAll(backupDirectory, os.FileMode(DirParmission))
+	if err != nil {
+		return err
+	}
+	config, err := loadTableConfig(directory + tablename + ".config")
+	if err != nil {
+		return err
+	}
+	suffix := ".v" + strconv.FormatInt(version, 10)
+	for _, filename := range tableFilenames(directory, tablename, config) {
+		_, err = os.Stat(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		err = copyFile(filename, backupDirectory+"/"+filename[len(directory):]+suffix)
+		if err != nil {
+			return err
+		}
+	}
+	return syncDir(backupDirectory)
+}
+
+//copyFile copies src to dst and syncs dst.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+//checkUpgradeMode returns ErrInvalidUpgradeMode when upgradeMode is not known.
+func checkUpgradeMode(upgradeMode string) error {
+	if upgradeMode != UPGRADE_NONE && upgradeMode != UPGRADE_BACKUP && upgradeMode != UPGRADE_READONLY {
+		return ErrInvalidUpgradeMode
+	}
+	return nil
+}
+
+/*
+ declinesUpgrade returns whether the table is opened read-only, because its files are an old version and
+ the upgrade mode is UPGRADE_READONLY. The version is read before the table is opened, so no file is written.
+*/
+func (self *Database) declinesUpgrade(tablename string) bool {
+	if self.readOnly || self.upgradeMode != UPGRADE_READONLY {
+		return false
+	}
+	f, err := os.Open(self.directory + "/" + tablename + ".table")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	version, err := readHeader(f)
+	if err != nil {
+		return false
+	}
+	_, ok := migrations[version]
+	return ok
+}
+
+/*
+ applyUpgradeMode upgrades the opened table when the upgrade mode is UPGRADE_BACKUP.
+ The lock of database must be held.
+*/
+func (self *Database) applyUpgradeMode(tablename string, table TableInterface) error {
+	if self.readOnly || self.upgradeMode != UPGRADE_BACKUP || needsUpgrade(table) == false {
+		return nil
+	}
+	return self.upgradeTable(tablename, table)
+}
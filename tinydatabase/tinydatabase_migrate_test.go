@@ -0,0 +1,206 @@
+package tinydatabase
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//createOldDatabase creates database1 with tables of old fileversions and a row in each table.
+func createOldDatabase(t *testing.T, directoryJson string) {
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		_, err = db.NewTable(tabletype, tabletype, []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+	}
+	dbList.Close()
+
+	b := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(b, STATIC1)
+	ioutil.WriteFile(directoryJson+"database1/static.table", b, 0666)
+	os.Remove(directoryJson + "database1/static.free")
+	binary.PutVarint(b, DYNAMIC1_TABLE)
+	ioutil.WriteFile(directoryJson+"database1/dynamic.table", b, 0666)
+	os.Remove(directoryJson + "database1/dynamic.index")
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, _ = dbList.Get("database1")
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, _ := db.GetTable(tabletype)
+		_, err = table.WriteRow(Row{"a": int64(1)})
+		if err != nil {
+			t.Fatalf("Failed to write row of old fileversion: %s", err)
+		}
+	}
+}
+
+func Test1_Migrate_upgrade(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	createOldDatabase(t, directoryJson)
+
+	//Upgrade is declined, so old tables can only be read.
+	dbList, err := LoadDatabaseListWithUpgradeMode(directoryJson, "json", UPGRADE_READONLY)
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, _ := dbList.Get("database1")
+	names := db.OldTables()
+	if len(names) != 2 || names[0] != "dynamic" || names[1] != "static" {
+		t.Errorf("Failed to list old tables: %v", names)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, _ := db.GetTable(tabletype)
+		row, err := table.ReadRow(0)
+		if err != nil || row["a"] != int64(1) {
+			t.Errorf("Failed to read row of old table: %v, %v", row, err)
+		}
+		_, err = table.WriteRow(Row{"a": int64(2)})
+		if err != ErrReadOnly {
+			t.Errorf("Failed to refuse write to old table: %v", err)
+		}
+	}
+
+	err = db.Upgrade()
+	if err != nil {
+		t.Fatalf("Failed to upgrade: %s", err)
+	}
+	if len(db.OldTables()) != 0 {
+		t.Errorf("Failed to upgrade all tables: %v", db.OldTables())
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, _ := db.GetTable(tabletype)
+		row, err := table.ReadRow(0)
+		if err != nil || row["a"] != int64(1) {
+			t.Errorf("Failed to read row of upgraded table: %v, %v", row, err)
+		}
+		rowNum, err := table.WriteRow(Row{"a": int64(2)})
+		if err != nil || rowNum != 1 {
+			t.Errorf("Failed to write row to upgraded table: %d, %v", rowNum, err)
+		}
+	}
+	version, _ := readHeader(db.tables["static"].(*TableStatic).tablefile)
+	if version != STATIC3 {
+		t.Errorf("Failed to upgrade static table: %d", version)
+	}
+	version, _ = readHeader(db.tables["dynamic"].(*TableDynamic).tablefile)
+	if version != DYNAMIC3_TABLE {
+		t.Errorf("Failed to upgrade dynamic table: %d", version)
+	}
+	for _, filename := range []string{"static.table.v1", "static.config.v1", "dynamic.table.v2", "dynamic.index.v2"} {
+		_, err = os.Stat(directoryJson + "database1/" + backupDirname + "/" + filename)
+		if err != nil {
+			t.Errorf("Failed to back up %s: %s", filename, err)
+		}
+	}
+}
+
+func Test2_Migrate_loadBackup(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	createOldDatabase(t, directoryJson)
+
+	dbList, err := LoadDatabaseListWithUpgradeMode(directoryJson, "json", UPGRADE_BACKUP)
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ := dbList.Get("database1")
+	if len(db.OldTables()) != 0 {
+		t.Errorf("Failed to upgrade tables on loading: %v", db.OldTables())
+	}
+	dbList.Close()
+
+	//Backup files can be opened as the table of old fileversion.
+	backup := directoryJson + "database1/" + backupDirname + "/"
+	for _, ext := range []string{".config", ".table", ".free"} {
+		os.Rename(backup+"static"+ext+".v1", backup+"static"+ext)
+	}
+	table := &TableStatic{}
+	err = table.Open(backup, "static")
+	if err != nil {
+		t.Fatalf("Failed to open backup: %s", err)
+	}
+	defer table.Close()
+	if table.getFileVersion() != STATIC1 {
+		t.Errorf("Failed to keep old fileversion in backup: %d", table.getFileVersion())
+	}
+	row, err := table.ReadRow(0)
+	if err != nil || row["a"] != int64(1) {
+		t.Errorf("Failed to read row of backup: %v, %v", row, err)
+	}
+}
+
+//readDirectory returns contents of files in directory by their names.
+func readDirectory(t *testing.T, directory string) map[string]string {
+	infos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %s", err)
+	}
+	result := map[string]string{}
+	for _, info := range infos {
+		b, _ := ioutil.ReadFile(directory + "/" + info.Name())
+		result[info.Name()] = string(b)
+	}
+	return result
+}
+
+func Test3_Migrate_declineUnchanged(t *testing.T) {
+	directory, err := ioutil.TempDir("", "tinydatabase")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	copyDirectory(t, "./testdata_baseline", directory)
+	before := readDirectory(t, directory+"/testdatabase")
+
+	_, err = LoadDatabaseListWithUpgradeMode(directory, "json", "later")
+	if err != ErrInvalidUpgradeMode {
+		t.Errorf("Failed to refuse invalid upgrade mode: %v", err)
+	}
+	dbList, err := LoadDatabaseListWithUpgradeMode(directory, "json", UPGRADE_READONLY)
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ := dbList.Get("testdatabase")
+	names := db.OldTables()
+	if len(names) != 1 || names[0] != "testtable" {
+		t.Errorf("Failed to list old tables: %v", names)
+	}
+	table, _ := db.GetTable("testtable")
+	count, err := table.CountRows()
+	if err != nil || count != 1 {
+		t.Errorf("Failed to count rows of old table: %d, %v", count, err)
+	}
+	_, err = table.WriteRow(Row{"column1": int64(1)})
+	if err != ErrReadOnly {
+		t.Errorf("Failed to refuse write to old table: %v", err)
+	}
+	dbList.Close()
+
+	//Declined upgrade does not create or change any file of the database.
+	after := readDirectory(t, directory+"/testdatabase")
+	if len(after) != len(before) {
+		t.Errorf("Failed to keep files of database: %d, %d", len(after), len(before))
+	}
+	for name, content := range before {
+		if after[name] != content {
+			t.Errorf("Failed to keep %s unchanged", name)
+		}
+	}
+}
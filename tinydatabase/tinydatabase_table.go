@@ -2,24 +2,48 @@ package tinydatabase
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/binary"
-	//"encoding/json"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	//"fmt"
-	//"io"
-	//"io/ioutil"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"math"
-	//"os"
-	//"path"
-	//"strconv"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 //ColumnType stores column information.
 type ColumnType struct {
-	Name string
-	Type string
-	Size int64 //When Size is 0, size of the column can be variable
+	Name      string
+	Type      string
+	Size      int64       //When Size is 0, size of the column can be variable
+	Precision int64       `json:",omitempty" toml:",omitzero"`  //Number of digits of decimal column
+	Scale     int64       `json:",omitempty" toml:",omitzero"`  //Number of digits after the decimal point of decimal column
+	Nullable  bool        `json:",omitempty" toml:",omitempty"` //When Nullable is true, a missing or nil value is stored as NULL
+	NotNull   bool        `json:",omitempty" toml:",omitempty"` //When NotNull is true, a missing value without default is refused
+	Default   interface{} `json:",omitempty" toml:",omitempty"` //Literal value, DEFAULT_NOW or DEFAULT_AUTOINCREMENT
+	Identity  bool        `json:",omitempty" toml:",omitempty"` //When Identity is true, the table assigns the value from its counter
+}
+
+//tableConfig is a content of table config file.
+type tableConfig struct {
+	Columns          []ColumnType
+	DisableSlotReuse bool          `json:",omitempty" toml:",omitempty"`
+	Indexes          []indexConfig `json:",omitempty" toml:",omitempty"`
+	PrimaryKey       []string      `json:",omitempty" toml:",omitempty"`
+	Unique           [][]string    `json:",omitempty" toml:",omitempty"`
+	NextIdentity     int64         `json:",omitempty" toml:",omitzero"`
+	Durability       string        `json:",omitempty" toml:",omitempty"`
+	filetype         string        //"json" or "toml". It is found from the content when the file is read.
 }
 
 //Row interface is a one line of table.
@@ -32,18 +56,61 @@ type TableInterface interface {
 	Close() error
 	ReadRow(rowNum int64) (Row, error)
 	WriteRow(row Row) (int64, error)
+	WriteRows(rows []Row) ([]int64, error)
+	WriteRowReturning(row Row) (int64, Row, error)
+	UpdateRow(rowNum int64, row Row) error
 	DeleteRow(rowNum int64) error
+	Scan() (RowIterator, error)
+	CountRows() (int64, error)
 	GetTableType() string
 	GetColumns() []ColumnType
+	Verify(repair bool) ([]Problem, error)
+}
+
+/*
+ RowIterator is a cursor over rows of a table.
+ Call Next before reading the first row and check Err after Next returns false.
+ Rows updated or deleted while iterating are read as they are when Next reaches them.
+ Next fails with ErrIteratorInvalidated after the table is closed, compacted or altered.
+*/
+type RowIterator interface {
+	Next() bool
+	Row() Row
+	RowNum() int64
+	Err() error
+	Close() error
 }
 
+var (
+	ErrOutOfRowIndex       = errors.New("Out of Row index")
+	ErrRowDeleted          = errors.New("Deleted row")
+	ErrNotNull             = errors.New("Value is required for NOT NULL column")
+	ErrIdentityValue       = errors.New("Value of identity column is assigned by table")
+	ErrTableClosed         = errors.New("Table is closed")
+	ErrCorruptRow          = errors.New("Row is corrupted")
+	ErrCorruptHeader       = errors.New("Header of table file is corrupted")
+	ErrIteratorInvalidated = errors.New("Table was closed or its files were replaced while iterating")
+)
+
+//scanBufferSize is a buffer size for reading files sequentially.
+const scanBufferSize = 64 * 1024
+
 const (
 	UNKNOWN        int64 = 0
 	STATIC1        int64 = 1
 	DYNAMIC1_TABLE int64 = 2
 	DYNAMIC1_INDEX int64 = 3
+	STATIC1_FREE   int64 = 4
+	BTREE1         int64 = 5
+	STATIC2        int64 = 6 //STATIC1 with null bitmap
+	DYNAMIC2_TABLE int64 = 7 //DYNAMIC1_TABLE with null bitmap
+	STATIC3        int64 = 8 //STATIC2 with checksums of header and rows
+	DYNAMIC3_TABLE int64 = 9 //DYNAMIC2_TABLE with checksums of header and rows
 )
 
+//checksumBytes is the size of CRC32C after the header and each row of table file.
+const checksumBytes = 4
+
 const (
 	ROW_DELETED byte = 0
 	ROW_NORMAL  byte = 1
@@ -54,8 +121,551 @@ const (
 	COLUMN_FLOAT64 string = "float64"
 	COLUMN_STRING  string = "string"
 	COLUMN_TIME    string = "time"
+	COLUMN_BOOL    string = "bool"
+	COLUMN_BYTES   string = "bytes"
+	COLUMN_UINT64  string = "uint64"
+	COLUMN_DECIMAL string = "decimal"
+	COLUMN_JSON    string = "json"
+	COLUMN_UUID    string = "uuid"
+
+	COLUMN_INT64_ARRAY   string = "[]int64"
+	COLUMN_FLOAT64_ARRAY string = "[]float64"
+	COLUMN_STRING_ARRAY  string = "[]string"
+	COLUMN_TIME_ARRAY    string = "[]time"
+)
+
+//arrayElementTypes are Go types of elements which ReadRow returns for array columns.
+var arrayElementTypes = map[string]reflect.Type{
+	COLUMN_INT64:   reflect.TypeOf(int64(0)),
+	COLUMN_FLOAT64: reflect.TypeOf(float64(0)),
+	COLUMN_STRING:  reflect.TypeOf(""),
+	COLUMN_TIME:    reflect.TypeOf(time.Time{}),
+}
+
+//maxDecimalPrecision is the largest precision of decimal column which fits in int64.
+const maxDecimalPrecision = 18
+
+/*
+ Decimal is an exact number of decimal column.
+ The value is Unscaled / 10^Scale.
+*/
+type Decimal struct {
+	Unscaled int64
+	Scale    int64
+}
+
+//UUID is a value of uuid column.
+type UUID [16]byte
+
+const (
+	DEFAULT_NOW           string = "now()"           //Current time for time column
+	DEFAULT_AUTOINCREMENT string = "autoincrement()" //Largest value + 1 for int64 column
 )
 
+/*
+ loadTableConfig reads table config file.
+ Old config files which have only column list are also accepted.
+ A config file left as the temporary file by a crash is recovered before reading.
+*/
+func loadTableConfig(configfilename string) (*tableConfig, error) {
+	err := recoverFileAtomic(configfilename)
+	if err != nil {
+		return nil, err
+	}
+	jsonString, err := ioutil.ReadFile(configfilename)
+	if err != nil {
+		return nil, err
+	}
+	result := &tableConfig{filetype: "json"}
+	if json.Valid(jsonString) == false {
+		result.filetype = "toml"
+		err = decodeConfig(jsonString, result)
+	} else if len(bytes.TrimSpace(jsonString)) > 0 && bytes.TrimSpace(jsonString)[0] == '[' {
+		err = json.Unmarshal(jsonString, &result.Columns)
+	} else {
+		err = json.Unmarshal(jsonString, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//saveTableConfig writes table config file in the file type of config.
+func saveTableConfig(configfilename string, config *tableConfig) error {
+	b, err := encodeConfig(config.filetype, config)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(configfilename, b)
+}
+
+//tempSuffix is added to the name of a file while writeFileAtomic writes it.
+const tempSuffix = ".tmp"
+
+//syncDir flushes the entries of directory such as created or renamed files.
+func syncDir(directory string) error {
+	d, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+/*
+ writeFileAtomic replaces the file with data.
+ data is written to a temporary file which is renamed to filename after it is flushed,
+ so the file has either the old data or the new data after a crash.
+*/
+func writeFileAtomic(filename string, data []byte) error {
+	tempname := filename + tempSuffix
+	file, err := os.OpenFile(tempname, os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempname)
+		return err
+	}
+	err = os.Rename(tempname, filename)
+	if err != nil {
+		return err
+	}
+	return syncDir(path.Dir(filename))
+}
+
+/*
+ recoverFileAtomic handles the temporary file of writeFileAtomic left by a crash.
+ When filename exists, the temporary file was not renamed and the old data is kept.
+ Otherwise the temporary file is renamed to filename if it is a valid config, or removed.
+*/
+func recoverFileAtomic(filename string) error {
+	tempname := filename + tempSuffix
+	_, err := os.Stat(tempname)
+	if err != nil {
+		//There is no temporary file.
+		return nil
+	}
+	data, err := ioutil.ReadFile(tempname)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(filename)
+	if err == nil || validConfig(data) == false {
+		err = os.Remove(tempname)
+	} else {
+		err = os.Rename(tempname, filename)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(path.Dir(filename))
+}
+
+//validConfig returns whether data is a content of config file in json or toml.
+func validConfig(data []byte) bool {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return false
+	}
+	var v interface{}
+	return decodeConfig(data, &v) == nil
+}
+
+//hasChecksum returns whether the header and rows of table file have checksums.
+func hasChecksum(fileVersion int64) bool {
+	return fileVersion == STATIC3 || fileVersion == DYNAMIC3_TABLE
+}
+
+//headerBytes returns the size of the header of table file.
+func headerBytes(fileVersion int64) int64 {
+	if hasChecksum(fileVersion) {
+		return binary.MaxVarintLen64 + checksumBytes
+	}
+	return binary.MaxVarintLen64
+}
+
+//encodeHeader returns the header of table file with fileVersion.
+func encodeHeader(fileVersion int64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(b, fileVersion)
+	if hasChecksum(fileVersion) {
+		b = appendChecksum(b)
+	}
+	return b
+}
+
+/*
+ readHeader returns the file version in the header of table file.
+ Returns io.EOF when the file is empty, and ErrCorruptHeader when the checksum does not match.
+*/
+func readHeader(f io.ReaderAt) (int64, error) {
+	b := make([]byte, binary.MaxVarintLen64+checksumBytes)
+	_, err := f.ReadAt(b[:binary.MaxVarintLen64], 0)
+	if err != nil {
+		return UNKNOWN, err
+	}
+	fileVersion, num := binary.Varint(b)
+	if num < 1 {
+		return UNKNOWN, errors.New("Failed to read fileversion")
+	}
+	if hasChecksum(fileVersion) {
+		_, err = f.ReadAt(b[binary.MaxVarintLen64:], binary.MaxVarintLen64)
+		if err != nil && err != io.EOF {
+			return UNKNOWN, err
+		}
+		if err == io.EOF || verifyChecksum(b) != nil {
+			return UNKNOWN, ErrCorruptHeader
+		}
+	}
+	return fileVersion, nil
+}
+
+//appendChecksum appends CRC32C of b to b.
+func appendChecksum(b []byte) []byte {
+	sum := make([]byte, checksumBytes)
+	binary.LittleEndian.PutUint32(sum, crc32.Checksum(b, crcTable))
+	return append(b, sum...)
+}
+
+//verifyChecksum checks CRC32C at the end of b. Returns ErrCorruptRow when it does not match.
+func verifyChecksum(b []byte) error {
+	if len(b) < checksumBytes {
+		return ErrCorruptRow
+	}
+	n := len(b) - checksumBytes
+	if crc32.Checksum(b[:n], crcTable) != binary.LittleEndian.Uint32(b[n:]) {
+		return ErrCorruptRow
+	}
+	return nil
+}
+
+//nullBitmapBytes returns the size of null bitmap of a row. Each column has one bit.
+func nullBitmapBytes(columnTypes []ColumnType) int64 {
+	return int64(len(columnTypes)+7) / 8
+}
+
+//hasNullable returns whether a column is nullable.
+func hasNullable(columnTypes []ColumnType) bool {
+	for _, v := range columnTypes {
+		if v.Nullable {
+			return true
+		}
+	}
+	return false
+}
+
+//setNullBit marks column i as NULL in bitmap.
+func setNullBit(bitmap []byte, i int) {
+	bitmap[i/8] |= 1 << uint(i%8)
+}
+
+//getNullBit returns whether column i is NULL in bitmap. An empty bitmap has no NULL.
+func getNullBit(bitmap []byte, i int) bool {
+	if len(bitmap) == 0 {
+		return false
+	}
+	return bitmap[i/8]&(1<<uint(i%8)) != 0
+}
+
+//isArray returns whether the column is a list of values such as "[]int64".
+func (self *ColumnType) isArray() bool {
+	return strings.HasPrefix(self.Type, "[]")
+}
+
+//elementType returns the column type of elements of array column.
+func (self *ColumnType) elementType() ColumnType {
+	return ColumnType{Name: self.Name, Type: strings.TrimPrefix(self.Type, "[]")}
+}
+
+//isNull returns whether the value of column is stored as NULL.
+func (self *ColumnType) isNull(row Row) bool {
+	val, ok := row[self.Name]
+	return self.Nullable && (ok == false || val == nil)
+}
+
+//checkDefault checks flags and default value of column.
+func (self *ColumnType) checkDefault() error {
+	if self.Nullable && self.NotNull {
+		return errors.New("Column can not be both nullable and NOT NULL: " + self.Name)
+	}
+	if self.Identity && (self.Type != COLUMN_INT64 || self.Nullable || self.Default != nil) {
+		return errors.New("Identity column must be int64 without nullable and default: " + self.Name)
+	}
+	if self.Default == nil {
+		return nil
+	}
+	if self.Default == DEFAULT_NOW {
+		if self.Type != COLUMN_TIME {
+			return errors.New("now() is only for time column: " + self.Name)
+		}
+		return nil
+	}
+	if self.Default == DEFAULT_AUTOINCREMENT {
+		if self.Type != COLUMN_INT64 {
+			return errors.New("autoincrement() is only for int64 column: " + self.Name)
+		}
+		return nil
+	}
+	_, err := self.ConvertToBytes(self.Default)
+	return err
+}
+
+/*
+ applyDefaults returns a copy of row whose missing columns have default values.
+ autoIncrement has the next values of auto increment columns and is updated.
+ Returns ErrNotNull when a NOT NULL column has no value.
+*/
+func applyDefaults(columnTypes []ColumnType, row Row, autoIncrement map[string]int64) (Row, error) {
+	result := make(Row, len(columnTypes))
+	for k, v := range row {
+		result[k] = v
+	}
+	for _, v := range columnTypes {
+		val, ok := row[v.Name]
+		if ok == false && v.Default != nil {
+			if v.Default == DEFAULT_NOW {
+				val = time.Now()
+			} else if v.Default == DEFAULT_AUTOINCREMENT {
+				val = autoIncrement[v.Name]
+			} else {
+				val = v.Default
+			}
+			result[v.Name] = val
+		}
+		if v.Default == DEFAULT_AUTOINCREMENT && val != nil {
+			b, err := v.ConvertToBytes(val)
+			if err != nil {
+				return nil, err
+			}
+			n, _ := binary.Varint(b)
+			if n >= autoIncrement[v.Name] {
+				autoIncrement[v.Name] = n + 1
+			}
+		}
+	}
+	return result, checkRequired(columnTypes, result)
+}
+
+//checkIdentity returns error when columns have more than one identity column.
+func checkIdentity(columnTypes []ColumnType) error {
+	count := 0
+	for _, v := range columnTypes {
+		if v.Identity {
+			count++
+		}
+	}
+	if count > 1 {
+		return errors.New("Table can have only one identity column.")
+	}
+	return nil
+}
+
+/*
+ assignIdentity returns a copy of row which has the next value of identity column.
+ next is the counter of table and is updated.
+ Returns the name of identity column, or "" when table has no identity column.
+*/
+func assignIdentity(columnTypes []ColumnType, row Row, next *int64) (Row, string, error) {
+	for _, v := range columnTypes {
+		if v.Identity == false {
+			continue
+		}
+		if row[v.Name] != nil {
+			return nil, "", ErrIdentityValue
+		}
+		result := make(Row, len(row)+1)
+		for k, val := range row {
+			result[k] = val
+		}
+		result[v.Name] = *next
+		*next++
+		return result, v.Name, nil
+	}
+	return row, "", nil
+}
+
+/*
+ keepIdentity returns a copy of row which has the value of identity column in oldRow.
+ Returns ErrIdentityValue when row has another value.
+*/
+func keepIdentity(columnTypes []ColumnType, row Row, oldRow Row) (Row, error) {
+	for _, v := range columnTypes {
+		if v.Identity == false {
+			continue
+		}
+		if row[v.Name] != nil {
+			b, err := v.ConvertToBytes(row[v.Name])
+			if err != nil {
+				return nil, err
+			}
+			n, _ := binary.Varint(b)
+			if n != oldRow[v.Name] {
+				return nil, ErrIdentityValue
+			}
+		}
+		result := make(Row, len(row)+1)
+		for k, val := range row {
+			result[k] = val
+		}
+		result[v.Name] = oldRow[v.Name]
+		return result, nil
+	}
+	return row, nil
+}
+
+//hasIdentity returns whether a column is identity column.
+func hasIdentity(columnTypes []ColumnType) bool {
+	for _, v := range columnTypes {
+		if v.Identity {
+			return true
+		}
+	}
+	return false
+}
+
+//checkRequired returns ErrNotNull when a NOT NULL column of row has no value.
+func checkRequired(columnTypes []ColumnType, row Row) error {
+	for _, v := range columnTypes {
+		if v.NotNull && row[v.Name] == nil {
+			return ErrNotNull
+		}
+	}
+	return nil
+}
+
+/*
+ rowReader reads rows of a table while the lock of the table is held.
+ Both TableStatic and TableDynamic implement it.
+*/
+type rowReader interface {
+	readRow(rowNum int64) (Row, error)
+	scan(lock *sync.RWMutex) (RowIterator, error)
+	columns() []ColumnType
+}
+
+//lockedTable is a table which has a reader/writer lock.
+type lockedTable interface {
+	lock() *sync.RWMutex
+}
+
+//tableLock returns the lock of table.
+func tableLock(table TableInterface) *sync.RWMutex {
+	locked, ok := table.(lockedTable)
+	if ok == false {
+		return &sync.RWMutex{}
+	}
+	return locked.lock()
+}
+
+/*
+ loadAutoIncrement returns the next values of auto increment columns.
+ They are the largest values in table + 1, so the table is scanned when it has such columns.
+*/
+func loadAutoIncrement(table rowReader, columnTypes []ColumnType) (map[string]int64, error) {
+	result := map[string]int64{}
+	for _, v := range columnTypes {
+		if v.Default == DEFAULT_AUTOINCREMENT {
+			result[v.Name] = 1
+		}
+	}
+	if len(result) == 0 {
+		return result, nil
+	}
+	it, err := table.scan(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	for it.Next() {
+		for name, next := range result {
+			n, ok := it.Row()[name].(int64)
+			if ok && n >= next {
+				result[name] = n + 1
+			}
+		}
+	}
+	return result, it.Err()
+}
+
+//batchWriter is a table which stages writes of rows and commits them together.
+type batchWriter interface {
+	writeRow(row Row) (int64, Row, error)
+	reserveIdentity(n int64) error
+	commit() error
+	rollback()
+}
+
+//batchAppender is a batchWriter which keeps the end of rows in memory between beginBatch and endBatch.
+type batchAppender interface {
+	beginBatch() error
+	endBatch() error
+}
+
+/*
+ writeRows stages rows and commits them at once.
+ When a row can not be written, no row is written.
+*/
+func writeRows(table batchWriter, rows []Row) ([]int64, error) {
+	err := table.reserveIdentity(int64(len(rows)))
+	if err != nil {
+		return nil, err
+	}
+	appender, ok := table.(batchAppender)
+	if ok == true {
+		err = appender.beginBatch()
+		if err != nil {
+			return nil, err
+		}
+	}
+	result := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		rowNum, _, err := table.writeRow(row)
+		if err != nil {
+			table.rollback()
+			return nil, err
+		}
+		result = append(result, rowNum)
+	}
+	if ok == true {
+		err = appender.endBatch()
+		if err != nil {
+			table.rollback()
+			return nil, err
+		}
+	}
+	err = table.commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//countRows counts rows which are not deleted by scanning table.
+func countRows(table rowReader) (int64, error) {
+	it, err := table.scan(nil)
+	if err != nil {
+		return -1, err
+	}
+	defer it.Close()
+	count := int64(0)
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil {
+		return -1, it.Err()
+	}
+	return count, nil
+}
+
 //GetBytes returns data size of column.
 func (self *ColumnType) GetBytes() (int64, error) {
 	if self.Type == "int64" {
@@ -69,6 +679,35 @@ func (self *ColumnType) GetBytes() (int64, error) {
 		return self.Size, nil
 	} else if self.Type == "time" {
 		return 15, nil
+	} else if self.Type == "bool" {
+		return 1, nil
+	} else if self.Type == "bytes" {
+		if self.Size < 0 {
+			return 0, errors.New("Size is not valid")
+		}
+		return self.Size, nil
+	} else if self.Type == "uint64" {
+		return binary.MaxVarintLen64, nil
+	} else if self.Type == "decimal" {
+		if self.Precision < 1 || self.Precision > maxDecimalPrecision || self.Scale < 0 || self.Scale > self.Precision {
+			return 0, errors.New("Precision is not valid")
+		}
+		return binary.MaxVarintLen64, nil
+	} else if self.Type == "uuid" {
+		return 16, nil
+	} else if self.isArray() {
+		if _, ok := arrayElementTypes[self.elementType().Type]; ok == false {
+			return 0, errors.New("Type is not valid")
+		}
+		if self.Size != 0 {
+			return 0, errors.New("Size of array column must be 0")
+		}
+		return 0, nil
+	} else if self.Type == "json" {
+		if self.Size != 0 {
+			return 0, errors.New("Size of json column must be 0")
+		}
+		return 0, nil
 	}
 	return 0, errors.New("Type is not valid")
 }
@@ -80,13 +719,25 @@ func (self *ColumnType) GetNil() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if self.Type == "bytes" || self.Type == "uuid" {
+		return make([]byte, byteNum), nil
+	} else if self.Type == "json" {
+		return []byte("null"), nil
+	} else if self.isArray() {
+		return []byte{0}, nil
+	}
 	if byteNum == 0 {
 		byteNum = 1
 	}
 	b = make([]byte, byteNum)
-	if self.Type == "int64" {
+	if self.Type == "int64" || self.Type == "decimal" {
 		binary.PutVarint(b, int64(0))
 		return b, nil
+	} else if self.Type == "uint64" {
+		binary.PutUvarint(b, uint64(0))
+		return b, nil
+	} else if self.Type == "bool" {
+		return b, nil
 	} else if self.Type == "float64" {
 		bits := math.Float64bits(0.0)
 		binary.LittleEndian.PutUint64(b, bits)
@@ -126,6 +777,11 @@ func (self *ColumnType) ConvertToBytes(val interface{}) ([]byte, error) {
 			v = int64(vi)
 		case float64:
 			v = int64(vi)
+		case json.Number:
+			v, err = vi.Int64()
+			if err != nil {
+				return nil, errors.New("Missmatch type(int64) and val: " + self.Name)
+			}
 		default:
 			return nil, errors.New("Missmatch type(int64) and val: " + self.Name)
 		}
@@ -134,6 +790,10 @@ func (self *ColumnType) ConvertToBytes(val interface{}) ([]byte, error) {
 		return b, nil
 	} else if self.Type == "float64" {
 		v, ok := val.(float64)
+		if vi, isNumber := val.(json.Number); isNumber {
+			v, err = vi.Float64()
+			ok = err == nil
+		}
 		if ok == false {
 			return nil, errors.New("Missmatch type(float64) and val: " + self.Name)
 		}
@@ -180,11 +840,286 @@ func (self *ColumnType) ConvertToBytes(val interface{}) ([]byte, error) {
 			return nil, err
 		}
 		return b, nil
+	} else if self.Type == "bool" {
+		v, ok := val.(bool)
+		if ok == false {
+			return nil, errors.New("Missmatch type(bool) and val: " + self.Name)
+		}
+		b = make([]byte, 1)
+		if v {
+			b[0] = 1
+		}
+		return b, nil
+	} else if self.Type == "bytes" {
+		//A string is decoded as base64.
+		v, ok := val.([]byte)
+		if vS, isString := val.(string); isString {
+			v, err = base64.StdEncoding.DecodeString(vS)
+			ok = err == nil
+		}
+		if ok == false {
+			return nil, errors.New("Missmatch type(bytes) and val: " + self.Name)
+		}
+		if byteNum != 0 && int64(len(v)) != byteNum {
+			return nil, errors.New("Wrong size of bytes for " + self.Name)
+		}
+		b = make([]byte, len(v))
+		copy(b, v)
+		return b, nil
+	} else if self.Type == "uint64" {
+		var v uint64
+		switch vi := val.(type) {
+		case uint64:
+			v = vi
+		case uint:
+			v = uint64(vi)
+		case uint32:
+			v = uint64(vi)
+		case int64:
+			if vi < 0 {
+				return nil, errors.New("Missmatch type(uint64) and val: " + self.Name)
+			}
+			v = uint64(vi)
+		case int:
+			if vi < 0 {
+				return nil, errors.New("Missmatch type(uint64) and val: " + self.Name)
+			}
+			v = uint64(vi)
+		case float64:
+			if vi < 0 {
+				return nil, errors.New("Missmatch type(uint64) and val: " + self.Name)
+			}
+			v = uint64(vi)
+		case json.Number:
+			v, err = strconv.ParseUint(string(vi), 10, 64)
+			if err != nil {
+				return nil, errors.New("Missmatch type(uint64) and val: " + self.Name)
+			}
+		default:
+			return nil, errors.New("Missmatch type(uint64) and val: " + self.Name)
+		}
+		b = make([]byte, byteNum)
+		binary.PutUvarint(b, v)
+		return b, nil
+	} else if self.Type == "decimal" {
+		v, err := self.convertToDecimal(val)
+		if err != nil {
+			return nil, err
+		}
+		b = make([]byte, byteNum)
+		binary.PutVarint(b, v.Unscaled)
+		return b, nil
+	} else if self.Type == "json" {
+		//json.RawMessage is stored as it is. Other values are encoded to JSON.
+		v, ok := val.(json.RawMessage)
+		if ok == false {
+			v, err = json.Marshal(val)
+			if err != nil {
+				return nil, errors.New("Missmatch type(json) and val: " + self.Name)
+			}
+		}
+		buf := &bytes.Buffer{}
+		err = json.Compact(buf, v)
+		if err != nil {
+			return nil, errors.New("Invalid JSON for " + self.Name)
+		}
+		return buf.Bytes(), nil
+	} else if self.Type == "uuid" {
+		var v UUID
+		switch vi := val.(type) {
+		case UUID:
+			v = vi
+		case [16]byte:
+			v = vi
+		case []byte:
+			if len(vi) != 16 {
+				return nil, errors.New("Wrong size of uuid for " + self.Name)
+			}
+			copy(v[:], vi)
+		case string:
+			v, err = ParseUUID(vi)
+			if err != nil {
+				return nil, errors.New("Missmatch type(uuid) and val: " + self.Name)
+			}
+		default:
+			return nil, errors.New("Missmatch type(uuid) and val: " + self.Name)
+		}
+		return v[:], nil
+	} else if self.isArray() {
+		//The number of elements is followed by the length and bytes of each element.
+		v := reflect.ValueOf(val)
+		if val == nil || v.Kind() != reflect.Slice {
+			return nil, errors.New("Missmatch type(" + self.Type + ") and val: " + self.Name)
+		}
+		element := self.elementType()
+		b = make([]byte, binary.MaxVarintLen64)
+		b = b[:binary.PutUvarint(b, uint64(v.Len()))]
+		for i := 0; i < v.Len(); i++ {
+			e, err := element.ConvertToBytes(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			if element.Type == COLUMN_INT64 {
+				_, n := binary.Varint(e)
+				e = e[:n]
+			}
+			l := make([]byte, binary.MaxVarintLen64)
+			b = append(b, l[:binary.PutUvarint(l, uint64(len(e)))]...)
+			b = append(b, e...)
+		}
+		return b, nil
 	} else {
 		return nil, errors.New("Type is not valid: " + self.Name)
 	}
 }
 
+/*
+ convertToDecimal converts value to Decimal which has the scale of column.
+ Strings and json.Number are parsed exactly. float64 is rounded.
+*/
+func (self *ColumnType) convertToDecimal(val interface{}) (Decimal, error) {
+	result := Decimal{Scale: self.Scale}
+	var err error
+	switch vi := val.(type) {
+	case Decimal:
+		result.Unscaled, err = rescaleDecimal(vi, self.Scale)
+	case string:
+		result.Unscaled, err = parseDecimal(vi, self.Scale)
+	case json.Number:
+		result.Unscaled, err = parseDecimal(string(vi), self.Scale)
+	case int64:
+		result.Unscaled, err = rescaleDecimal(Decimal{Unscaled: vi}, self.Scale)
+	case int:
+		result.Unscaled, err = rescaleDecimal(Decimal{Unscaled: int64(vi)}, self.Scale)
+	case float64:
+		f := math.Round(vi * math.Pow10(int(self.Scale)))
+		if math.Abs(f) >= math.Pow10(maxDecimalPrecision) {
+			err = errors.New("Too large decimal")
+		}
+		result.Unscaled = int64(f)
+	default:
+		err = errors.New("Missmatch type(decimal) and val")
+	}
+	if err != nil {
+		return Decimal{}, errors.New(err.Error() + ": " + self.Name)
+	}
+	limit := int64(math.Pow10(int(self.Precision)))
+	if result.Unscaled >= limit || result.Unscaled <= -limit {
+		return Decimal{}, errors.New("Too many digits for " + self.Name)
+	}
+	return result, nil
+}
+
+//parseDecimal parses a decimal string to the unscaled value of scale.
+func parseDecimal(s string, scale int64) (int64, error) {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	intPart := s
+	fracPart := ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart = s[:i]
+		fracPart = s[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return 0, errors.New("Invalid decimal")
+	}
+	if int64(len(fracPart)) > scale {
+		return 0, errors.New("Too many digits after the decimal point")
+	}
+	digits := intPart + fracPart + strings.Repeat("0", int(scale)-len(fracPart))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, errors.New("Invalid decimal")
+		}
+	}
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return 0, nil
+	}
+	if len(digits) > maxDecimalPrecision {
+		return 0, errors.New("Too large decimal")
+	}
+	v, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+//rescaleDecimal returns the unscaled value of d in scale. Digits are not dropped.
+func rescaleDecimal(d Decimal, scale int64) (int64, error) {
+	v := d.Unscaled
+	for s := d.Scale; s < scale; s++ {
+		if v > math.MaxInt64/10 || v < math.MinInt64/10 {
+			return 0, errors.New("Too large decimal")
+		}
+		v *= 10
+	}
+	for s := d.Scale; s > scale; s-- {
+		if v%10 != 0 {
+			return 0, errors.New("Too many digits after the decimal point")
+		}
+		v /= 10
+	}
+	return v, nil
+}
+
+//String returns the decimal with Scale digits after the decimal point.
+func (self Decimal) String() string {
+	digits := strconv.FormatInt(self.Unscaled, 10)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign = "-"
+		digits = digits[1:]
+	}
+	if self.Scale <= 0 {
+		return sign + digits
+	}
+	if int64(len(digits)) <= self.Scale {
+		digits = strings.Repeat("0", int(self.Scale)-len(digits)+1) + digits
+	}
+	point := int64(len(digits)) - self.Scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+//ParseUUID parses the canonical text of UUID such as "123e4567-e89b-12d3-a456-426614174000".
+func ParseUUID(s string) (UUID, error) {
+	var result UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return result, errors.New("Invalid UUID")
+	}
+	b, err := hex.DecodeString(s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:36])
+	if err != nil {
+		return result, errors.New("Invalid UUID")
+	}
+	copy(result[:], b)
+	return result, nil
+}
+
+//String returns the canonical text of UUID in lower case.
+func (self UUID) String() string {
+	s := hex.EncodeToString(self[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+//MarshalText encodes UUID to the canonical text, so UUID is a string in JSON.
+func (self UUID) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+//UnmarshalText decodes the canonical text of UUID.
+func (self *UUID) UnmarshalText(b []byte) error {
+	v, err := ParseUUID(string(b))
+	if err != nil {
+		return err
+	}
+	*self = v
+	return nil
+}
+
 //ConvertToVal convert from []byte to value.
 func (self *ColumnType) ConvertToVal(b []byte) (interface{}, error) {
 	if self.Type == "int64" {
@@ -212,7 +1147,136 @@ func (self *ColumnType) ConvertToVal(b []byte) (interface{}, error) {
 			return nil, err
 		}
 		return v, nil
+	} else if self.Type == "bool" {
+		if len(b) < 1 {
+			return nil, errors.New("Missmatch type(bool) and val: " + self.Name)
+		}
+		return b[0] != 0, nil
+	} else if self.Type == "bytes" {
+		v := make([]byte, len(b))
+		copy(v, b)
+		return v, nil
+	} else if self.Type == "uint64" {
+		v, num := binary.Uvarint(b)
+		if num < 1 {
+			return nil, errors.New("Missmatch type(uint64) and val: " + self.Name)
+		}
+		return v, nil
+	} else if self.Type == "decimal" {
+		v, num := binary.Varint(b)
+		if num < 1 {
+			return nil, errors.New("Missmatch type(decimal) and val: " + self.Name)
+		}
+		return Decimal{Unscaled: v, Scale: self.Scale}, nil
+	} else if self.Type == "json" {
+		//Numbers are decoded as json.Number to keep their digits.
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err := d.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	} else if self.Type == "uuid" {
+		var v UUID
+		if len(b) != 16 {
+			return nil, errors.New("Missmatch type(uuid) and val: " + self.Name)
+		}
+		copy(v[:], b)
+		return v, nil
+	} else if self.isArray() {
+		element := self.elementType()
+		count, n := binary.Uvarint(b)
+		if n < 1 || count > uint64(len(b)) {
+			return nil, errors.New("Missmatch type(" + self.Type + ") and val: " + self.Name)
+		}
+		b = b[n:]
+		v := reflect.MakeSlice(reflect.SliceOf(arrayElementTypes[element.Type]), 0, int(count))
+		for i := uint64(0); i < count; i++ {
+			l, n := binary.Uvarint(b)
+			if n < 1 || l > uint64(len(b)-n) {
+				return nil, errors.New("Missmatch type(" + self.Type + ") and val: " + self.Name)
+			}
+			e, err := element.ConvertToVal(b[n : n+int(l)])
+			if err != nil {
+				return nil, err
+			}
+			v = reflect.Append(v, reflect.ValueOf(e))
+			b = b[n+int(l):]
+		}
+		return v.Interface(), nil
 	} else {
 		return nil, errors.New("Type is not valid: " + self.Name)
 	}
 }
+
+/*
+ ConvertToKey converts value to bytes for index.
+ Keys are compared as bytes in the same order as values.
+ Each value starts with a tag byte. nil is NULL and comes before all values.
+*/
+func (self *ColumnType) ConvertToKey(val interface{}) ([]byte, error) {
+	if val == nil {
+		return []byte{0}, nil
+	}
+	b, err := self.ConvertToBytes(val)
+	if err != nil {
+		return nil, err
+	}
+	v, err := self.ConvertToVal(b)
+	if err != nil {
+		return nil, err
+	}
+	result := []byte{1}
+	key := make([]byte, 8)
+	switch vi := v.(type) {
+	case int64:
+		binary.BigEndian.PutUint64(key, uint64(vi)^(1<<63))
+		result = append(result, key...)
+	case float64:
+		bits := math.Float64bits(vi)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits = bits | (1 << 63)
+		}
+		binary.BigEndian.PutUint64(key, bits)
+		result = append(result, key...)
+	case string:
+		//Stored strings end at 0x00, so 0x00 is used as the terminator.
+		result = append(result, vi...)
+		result = append(result, 0)
+	case time.Time:
+		binary.BigEndian.PutUint64(key, uint64(vi.Unix())^(1<<63))
+		result = append(result, key...)
+		binary.BigEndian.PutUint32(key, uint32(vi.Nanosecond()))
+		result = append(result, key[:4]...)
+	case bool:
+		if vi {
+			result = append(result, 1)
+		} else {
+			result = append(result, 0)
+		}
+	case []byte:
+		//0x00 in bytes is escaped to 0x00 0xFF and the end is 0x00 0x01.
+		for _, c := range vi {
+			result = append(result, c)
+			if c == 0 {
+				result = append(result, 0xFF)
+			}
+		}
+		result = append(result, 0, 1)
+	case uint64:
+		binary.BigEndian.PutUint64(key, vi)
+		result = append(result, key...)
+	case Decimal:
+		binary.BigEndian.PutUint64(key, uint64(vi.Unscaled)^(1<<63))
+		result = append(result, key...)
+	case UUID:
+		result = append(result, vi[:]...)
+	default:
+		return nil, errors.New("Type is not valid: " + self.Name)
+	}
+	return result, nil
+}
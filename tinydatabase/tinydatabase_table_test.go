@@ -0,0 +1,151 @@
+package tinydatabase
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test1_ColumnType_convert(t *testing.T) {
+	column := ColumnType{Name: "c", Type: COLUMN_BOOL}
+	for _, v := range []bool{true, false} {
+		b, err := column.ConvertToBytes(v)
+		if err != nil {
+			t.Errorf("Failed to convert bool: %s", err)
+		}
+		val, err := column.ConvertToVal(b)
+		if err != nil || val != v {
+			t.Errorf("Failed to convert bool: %v, %v", val, err)
+		}
+	}
+	_, err := column.ConvertToBytes(1)
+	if err == nil {
+		t.Errorf("Failed to check type of bool")
+	}
+
+	column = ColumnType{Name: "c", Type: COLUMN_BYTES, Size: 4}
+	b, err := column.ConvertToBytes([]byte{0, 1, 2, 3})
+	if err != nil || len(b) != 4 {
+		t.Errorf("Failed to convert bytes: %v, %v", b, err)
+	}
+	_, err = column.ConvertToBytes([]byte{0, 1})
+	if err == nil {
+		t.Errorf("Failed to check size of bytes")
+	}
+	b, err = column.ConvertToBytes("AAECAw==")
+	val, _ := column.ConvertToVal(b)
+	if err != nil || bytes.Equal(val.([]byte), []byte{0, 1, 2, 3}) == false {
+		t.Errorf("Failed to convert base64: %v, %v", val, err)
+	}
+
+	column = ColumnType{Name: "c", Type: COLUMN_UINT64}
+	for _, v := range []interface{}{uint64(1 << 63), json.Number("18446744073709551615"), 5} {
+		b, err = column.ConvertToBytes(v)
+		if err != nil {
+			t.Errorf("Failed to convert uint64: %s", err)
+		}
+	}
+	val, _ = column.ConvertToVal(b)
+	if val != uint64(5) {
+		t.Errorf("Failed to convert uint64: %v", val)
+	}
+	_, err = column.ConvertToBytes(int64(-1))
+	if err == nil {
+		t.Errorf("Failed to refuse negative value for uint64")
+	}
+
+	column = ColumnType{Name: "c", Type: COLUMN_DECIMAL, Precision: 6, Scale: 2}
+	tests := []struct {
+		in  interface{}
+		out string
+	}{
+		{"1234.5", "1234.50"},
+		{"-0.05", "-0.05"},
+		{json.Number("9999.99"), "9999.99"},
+		{int64(12), "12.00"},
+		{0.1, "0.10"},
+		{Decimal{Unscaled: 1230, Scale: 3}, "1.23"},
+	}
+	for _, v := range tests {
+		b, err = column.ConvertToBytes(v.in)
+		if err != nil {
+			t.Errorf("Failed to convert decimal %v: %s", v.in, err)
+			continue
+		}
+		val, err = column.ConvertToVal(b)
+		if err != nil || val.(Decimal).String() != v.out {
+			t.Errorf("Failed to convert decimal %v: %v, %v", v.in, val, err)
+		}
+	}
+	for _, v := range []interface{}{"10000.00", "1.234", "1e3", Decimal{Unscaled: 1234, Scale: 3}} {
+		_, err = column.ConvertToBytes(v)
+		if err == nil {
+			t.Errorf("Failed to refuse decimal %v", v)
+		}
+	}
+	_, err = (&ColumnType{Name: "c", Type: COLUMN_DECIMAL, Precision: 19}).GetBytes()
+	if err == nil {
+		t.Errorf("Failed to check precision")
+	}
+}
+
+func Test2_ColumnType_uuid(t *testing.T) {
+	column := ColumnType{Name: "c", Type: COLUMN_UUID}
+	text := "123e4567-e89b-12d3-a456-426614174000"
+	for _, v := range []interface{}{text, "123E4567-E89B-12D3-A456-426614174000", UUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}} {
+		b, err := column.ConvertToBytes(v)
+		if err != nil || len(b) != 16 {
+			t.Errorf("Failed to convert uuid %v: %v, %v", v, b, err)
+			continue
+		}
+		val, err := column.ConvertToVal(b)
+		if err != nil || val.(UUID).String() != text {
+			t.Errorf("Failed to convert uuid %v: %v, %v", v, val, err)
+		}
+	}
+	for _, v := range []interface{}{"123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g", []byte{1, 2}, 1} {
+		_, err := column.ConvertToBytes(v)
+		if err == nil {
+			t.Errorf("Failed to refuse uuid %v", v)
+		}
+	}
+	u, _ := ParseUUID(text)
+	b, _ := json.Marshal(map[string]interface{}{"id": u})
+	if string(b) != `{"id":"`+text+`"}` {
+		t.Errorf("Failed to marshal uuid: %s", b)
+	}
+}
+
+func Test3_ColumnType_array(t *testing.T) {
+	column := ColumnType{Name: "c", Type: COLUMN_INT64_ARRAY}
+	b, err := column.ConvertToBytes([]interface{}{json.Number("1"), -300, int64(1) << 60})
+	if err != nil {
+		t.Fatalf("Failed to convert array: %s", err)
+	}
+	val, err := column.ConvertToVal(b)
+	v, ok := val.([]int64)
+	if err != nil || ok == false || len(v) != 3 || v[0] != 1 || v[1] != -300 || v[2] != 1<<60 {
+		t.Errorf("Failed to convert array: %v, %v", val, err)
+	}
+	b, _ = column.GetNil()
+	val, _ = column.ConvertToVal(b)
+	if v, ok = val.([]int64); ok == false || len(v) != 0 {
+		t.Errorf("Failed to convert empty array: %v", val)
+	}
+	_, err = column.ConvertToBytes([]string{"a"})
+	if err == nil {
+		t.Errorf("Failed to check type of elements")
+	}
+	_, err = column.ConvertToBytes(1)
+	if err == nil {
+		t.Errorf("Failed to check type of array")
+	}
+	_, err = (&ColumnType{Name: "c", Type: "[]json"}).GetBytes()
+	if err == nil {
+		t.Errorf("Failed to check type of elements")
+	}
+	_, err = (&ColumnType{Name: "c", Type: COLUMN_STRING_ARRAY, Size: 8}).GetBytes()
+	if err == nil {
+		t.Errorf("Failed to check size of array")
+	}
+}
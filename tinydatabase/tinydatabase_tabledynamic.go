@@ -1,28 +1,56 @@
 package tinydatabase
 
 import (
+	"bufio"
 	//"bytes"
 	"encoding/binary"
-	"encoding/json"
+	//"encoding/json"
 	"errors"
 	//"fmt"
 	"io"
-	"io/ioutil"
-	//"math"
+	//"io/ioutil"
+	"math"
 	"os"
 	"path"
 	//"strconv"
+	"sync"
 	//"time"
 )
 
-//TableDynamic is a not fixed size row table.
+/*
+ TableDynamic is a not fixed size row table.
+ Exported methods lock the table, so it can be shared by goroutines.
+ Unexported methods are called while the lock is held.
+*/
 type TableDynamic struct {
-	tablefile           *os.File
-	indexfile           *os.File
+	mutex               sync.RWMutex
+	tablefile           *dataFile
+	indexfile           *dataFile
+	wal                 *writeAheadLog
+	tx                  *Tx
+	directory           string
+	tablename           string
 	fileVersion         int64
 	columnTypes         []ColumnType
 	columnBytes         int64
+	nullBytes           int64
 	numOfFlexibleColumn int64
+	indexes             []*Index
+	constraints         constraints
+	autoIncrement       map[string]int64
+	nextIdentity        int64
+	savedIdentity       int64 //nextIdentity in the config file
+	filetype            string
+	durability          string
+	readOnly            bool
+	batch               *dynamicBatch //Positions of rows staged by WriteRows. nil out of the batch
+	generation          int64         //Incremented when files are closed, so iterators of old files fail
+}
+
+//dynamicBatch keeps the end of rows in memory while WriteRows stages rows, so the header is written once.
+type dynamicBatch struct {
+	tableOff int64
+	indexNum int64
 }
 
 /*
@@ -30,10 +58,11 @@ type TableDynamic struct {
  When files exist, returns error.
 */
 func (self *TableDynamic) NewTable(directory string, tablename string, columnTypes []ColumnType) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	directory = path.Clean(directory)
 	directory = directory + "/"
 	dCheck, err := os.Stat(directory)
-	dCheck = dCheck
 	if err != nil {
 		return err
 	}
@@ -53,7 +82,7 @@ func (self *TableDynamic) NewTable(directory string, tablename string, columnTyp
 	if err == nil {
 		return errors.New("Table file exists.")
 	}
-	err = self.Close()
+	err = self.close()
 	if err != nil {
 		return err
 	}
@@ -62,6 +91,10 @@ func (self *TableDynamic) NewTable(directory string, tablename string, columnTyp
 	if err != nil {
 		return err
 	}
+	self.directory = directory
+	self.tablename = tablename
+	self.constraints = constraints{}
+	self.nextIdentity = 1
 	err = self.saveConfigFile(directory + tablename + ".config")
 	if err != nil {
 		return err
@@ -75,23 +108,48 @@ func (self *TableDynamic) NewTable(directory string, tablename string, columnTyp
 	if err != nil {
 		return err
 	}
-	return nil
+	self.autoIncrement, err = loadAutoIncrement(self, self.columnTypes)
+	return err
 }
 
 /*
  Open func opens table file and config file.
 */
 func (self *TableDynamic) Open(directory string, tablename string) error {
-	err := self.Close()
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.open(directory, tablename)
+}
+
+//open opens files of the table.
+func (self *TableDynamic) open(directory string, tablename string) error {
+	err := self.close()
 	if err != nil {
 		return err
 	}
 	directory = path.Clean(directory)
 	directory = directory + "/"
+	self.directory = directory
+	self.tablename = tablename
+	if self.readOnly {
+		//Files left by a crash are not recovered without writing.
+		err = checkRecovered(recoveryFilenames(directory, tablename)...)
+	} else {
+		err = recoverAlter(directory, tablename)
+	}
+	if err != nil {
+		return err
+	}
 	err = self.openConfigFile(directory + tablename + ".config")
 	if err != nil {
 		return err
 	}
+	if self.readOnly == false {
+		err = self.recoverCompaction()
+		if err != nil {
+			return err
+		}
+	}
 	err = self.openTableFile(directory + tablename + ".table")
 	if err != nil {
 		return err
@@ -100,10 +158,40 @@ func (self *TableDynamic) Open(directory string, tablename string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	self.autoIncrement, err = loadAutoIncrement(self, self.columnTypes)
+	return err
 }
 
 func (self *TableDynamic) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.close()
+}
+
+//close closes files and indexes of the table.
+func (self *TableDynamic) close() error {
+	err := self.closeFiles()
+	if err != nil {
+		return err
+	}
+	err = closeIndexes(self.indexes)
+	if err != nil {
+		return err
+	}
+	self.indexes = nil
+	return nil
+}
+
+//closeFiles closes table file and index file. Secondary indexes are kept open.
+func (self *TableDynamic) closeFiles() error {
+	self.generation++
+	if self.tablefile != nil && self.wal != nil {
+		//Writes which are not synced are synced before files are closed, renamed or removed.
+		err := self.wal.Flush()
+		if err != nil {
+			return err
+		}
+	}
 	if self.tablefile != nil {
 		err := self.tablefile.Close()
 		if err != nil {
@@ -123,286 +211,1085 @@ func (self *TableDynamic) Close() error {
 
 /*
  WriteRow func writes row on table file.
+ The row is not changed. Use WriteRowReturning to get the assigned identity.
 */
 func (self *TableDynamic) WriteRow(row Row) (int64, error) {
-	tableOff, err := self.searchLastTableOffset()
-	if err != nil {
-		return -1, err
-	}
-	indexNum, err := self.searchLastIndexNum()
-	if err != nil {
-		return -1, err
-	}
-	indexOff := self.convertIndexNumToOffset(indexNum)
+	rowNum, _, err := self.WriteRowReturning(row)
+	return rowNum, err
+}
 
-	var b []byte
-	b = make([]byte, 1)
-	b[0] = ROW_NORMAL
-	num, err := self.tablefile.WriteAt(b, tableOff)
+/*
+ WriteRowReturning func writes row like WriteRow and returns the written row,
+ which has the assigned identity and default values.
+*/
+func (self *TableDynamic) WriteRowReturning(row Row) (int64, Row, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return -1, nil, ErrTableInTx
+	}
+	rowNum, written, err := self.writeRow(row)
 	if err != nil {
-		return -1, err
+		self.rollback()
+		return -1, nil, err
 	}
-
-	b = make([]byte, binary.MaxVarintLen64)
-	binary.PutVarint(b, tableOff)
-	num2, err := self.indexfile.WriteAt(b, indexOff)
+	err = self.commit()
 	if err != nil {
-		return -1, err
+		return -1, nil, err
 	}
+	return rowNum, written, nil
+}
 
-	tableOff = tableOff + int64(num)
-	indexOff = indexOff + int64(num2)
-	for _, v := range self.columnTypes {
-		if val, ok := row[v.Name]; ok {
-			b, err = v.ConvertToBytes(val)
-			if err != nil {
-				return -1, err
-			}
-		} else {
-			b, err = v.GetNil()
-			if err != nil {
-				return -1, err
-			}
-		}
-		num, err = self.tablefile.WriteAt(b, tableOff)
-		if err != nil {
-			return -1, err
-		}
-		if v.Size == 0 {
-			b = make([]byte, binary.MaxVarintLen64)
-			binary.PutVarint(b, int64(num))
-			num2, err := self.indexfile.WriteAt(b, indexOff)
-			if err != nil {
-				return -1, err
-			}
-			indexOff = indexOff + int64(num2)
-		}
-		tableOff = tableOff + int64(num)
-	}
-	b = make([]byte, binary.MaxVarintLen64)
-	binary.PutVarint(b, tableOff)
-	_, err = self.indexfile.WriteAt(b, int64(binary.MaxVarintLen64))
-	if err != nil {
-		return -1, err
+/*
+ WriteRows func writes rows and returns their row numbers.
+ The rows are committed with one sync, so all of them or none of them are written.
+*/
+func (self *TableDynamic) WriteRows(rows []Row) ([]int64, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return nil, ErrTableInTx
 	}
+	return writeRows(self, rows)
+}
 
-	err = self.tablefile.Sync()
-	if err != nil {
-		return -1, err
+/*
+ UpdateRow func overwrites the row at rowNum.
+ When the new row fits in the old area, it is overwritten in place.
+ Otherwise the row is appended and the index points to the new area.
+ The row keeps its row number.
+*/
+func (self *TableDynamic) UpdateRow(rowNum int64, row Row) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return ErrTableInTx
 	}
-	err = self.indexfile.Sync()
+	err := self.updateRow(rowNum, row)
 	if err != nil {
-		return -1, err
+		self.rollback()
+		return err
 	}
-	return indexNum, nil
+	return self.commit()
 }
 
 func (self *TableDynamic) ReadRow(rowNum int64) (Row, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.readRow(rowNum)
+}
+
+func (self *TableDynamic) readRow(rowNum int64) (Row, error) {
+	if self.tablefile == nil {
+		return nil, ErrTableClosed
+	}
 	lastIndexNum, err := self.searchLastIndexNum()
 	if err != nil {
 		return nil, err
 	}
-	if rowNum > lastIndexNum {
-		return nil, errors.New("Out of Row index")
+	if rowNum >= lastIndexNum {
+		return nil, ErrOutOfRowIndex
 	}
 	if rowNum < 0 {
-		return nil, errors.New("Out of Row index")
+		return nil, ErrOutOfRowIndex
 	}
-	indexOff := self.convertIndexNumToOffset(rowNum)
-
-	var b []byte
-	b = make([]byte, binary.MaxVarintLen64)
-	num, err := self.indexfile.ReadAt(b, indexOff)
+	tableOff, lengths, err := self.readIndexEntry(rowNum)
 	if err != nil {
 		return nil, err
 	}
-	if num != binary.MaxVarintLen64 {
-		return nil, errors.New("Failed to read table index")
+
+	b := make([]byte, 1)
+	_, err = self.tablefile.ReadAt(b, tableOff)
+	if err != nil {
+		return nil, err
 	}
-	tableOff, num := binary.Varint(b)
-	if num < 1 {
-		return nil, errors.New("Failed to read table index")
+	if b[0] == ROW_DELETED {
+		return nil, ErrRowDeleted
 	}
-	indexOff = indexOff + int64(binary.MaxVarintLen64)
 
-	b = make([]byte, 1)
+	b = make([]byte, self.rowBytes(lengths))
 	_, err = self.tablefile.ReadAt(b, tableOff)
 	if err != nil {
 		return nil, err
 	}
-	if b[0] == ROW_DELETED {
-		return nil, errors.New("Deleted row")
+	return self.decodeRecord(b, lengths)
+}
+
+func (self *TableDynamic) DeleteRow(rowNum int64) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return ErrTableInTx
 	}
-	tableOff = tableOff + 1
+	err := self.deleteRow(rowNum)
+	if err != nil {
+		self.rollback()
+		return err
+	}
+	return self.commit()
+}
 
-	result := make(Row)
-	for _, v := range self.columnTypes {
-		size, err := v.GetBytes()
+/*
+ Scan func returns an iterator over all rows which are not deleted.
+ Rows written after Scan are not returned.
+*/
+func (self *TableDynamic) Scan() (RowIterator, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.scan(&self.mutex)
+}
+
+/*
+ scan returns an iterator over rows. Each Next of the iterator holds lock.
+ lock is nil when the caller holds the lock of the table.
+*/
+func (self *TableDynamic) scan(lock *sync.RWMutex) (RowIterator, error) {
+	if self.tablefile == nil {
+		return nil, ErrTableClosed
+	}
+	lastIndexNum, err := self.searchLastIndexNum()
+	if err != nil {
+		return nil, err
+	}
+	startOff := self.convertIndexNumToOffset(0)
+	endOff := self.convertIndexNumToOffset(lastIndexNum)
+	result := &tableDynamicIterator{}
+	result.lock = lock
+	result.table = self
+	result.indexReader = bufio.NewReaderSize(io.NewSectionReader(self.indexfile, startOff, endOff-startOff), scanBufferSize)
+	result.tableReader = bufio.NewReaderSize(io.NewSectionReader(self.tablefile, 0, 0), scanBufferSize)
+	result.tablePos = -1
+	result.indexBuf = make([]byte, binary.MaxVarintLen64*(self.numOfFlexibleColumn+1))
+	result.rowNum = -1
+	result.lastRowNum = lastIndexNum
+	result.endOff = endOff
+	result.generation = self.generation
+	result.changes = self.indexfile.changes + self.tablefile.changes
+	return result, nil
+}
+
+//CountRows returns the number of rows which are not deleted.
+func (self *TableDynamic) CountRows() (int64, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return countRows(self)
+}
+
+/*
+ Compact func rewrites table file and index file without deleted rows and unused areas.
+ Row numbers do not change. Index entries of deleted rows point to one shared deleted marker.
+ New files are written beside the old ones and swapped in after they are synced.
+*/
+func (self *TableDynamic) Compact() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	lastIndexNum, err := self.searchLastIndexNum()
+	if err != nil {
+		return err
+	}
+	basename := self.directory + self.tablename
+	newTable, err := os.OpenFile(basename+".table.compact", os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer newTable.Close()
+	newIndex, err := os.OpenFile(basename+".index.compact", os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer newIndex.Close()
+
+	tableWriter := bufio.NewWriterSize(newTable, scanBufferSize)
+	indexWriter := bufio.NewWriterSize(newIndex, scanBufferSize)
+	tableWriter.Write(encodeHeader(self.fileVersion))
+	deletedOff := headerBytes(self.fileVersion)
+	tableWriter.WriteByte(ROW_DELETED)
+	tableOff := deletedOff + 1
+
+	//Last table offset is written after all rows are copied.
+	b := make([]byte, binary.MaxVarintLen64*2)
+	binary.PutVarint(b, DYNAMIC1_INDEX)
+	indexWriter.Write(b)
+
+	emptyLengths := make([]int64, self.numOfFlexibleColumn)
+	entry := make([]byte, binary.MaxVarintLen64*(self.numOfFlexibleColumn+1))
+	status := make([]byte, 1)
+	for i := int64(0); i < lastIndexNum; i++ {
+		oldOff, lengths, err := self.readIndexEntry(i)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		b, err = v.GetNil()
+		_, err = self.tablefile.ReadAt(status, oldOff)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if size > 0 {
-			num, err = self.tablefile.ReadAt(b, tableOff)
-			if err != nil {
-				return nil, err
-			}
-			result[v.Name], err = v.ConvertToVal(b)
-			if err != nil {
-				return nil, err
+		for j := range entry {
+			entry[j] = 0
+		}
+		if status[0] == ROW_DELETED {
+			binary.PutVarint(entry, deletedOff)
+			for j, l := range emptyLengths {
+				binary.PutVarint(entry[binary.MaxVarintLen64*(j+1):], l)
 			}
-			tableOff += int64(num)
 		} else {
-			b = make([]byte, binary.MaxVarintLen64)
-			num, err = self.indexfile.ReadAt(b, indexOff)
-			indexOff += int64(num)
-			size, num = binary.Varint(b)
-			if num < 1 {
-				return nil, errors.New("Failed to read index")
-			}
-			b = make([]byte, size)
-
-			num, err = self.tablefile.ReadAt(b, tableOff)
+			//Rows are copied with their checksums, so a corrupted row is still found after compaction.
+			data := make([]byte, self.rowBytes(lengths))
+			_, err = self.tablefile.ReadAt(data, oldOff)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			result[v.Name], err = v.ConvertToVal(b)
+			_, err = tableWriter.Write(data)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			tableOff += int64(num)
-
+			binary.PutVarint(entry, tableOff)
+			for j, l := range lengths {
+				binary.PutVarint(entry[binary.MaxVarintLen64*(j+1):], l)
+			}
+			tableOff += int64(len(data))
+		}
+		_, err = indexWriter.Write(entry)
+		if err != nil {
+			return err
 		}
 	}
-	return result, nil
-}
-
-func (self *TableDynamic) DeleteRow(rowNum int64) error {
-	lastIndexNum, err := self.searchLastIndexNum()
+	err = tableWriter.Flush()
 	if err != nil {
 		return err
 	}
-	if rowNum > lastIndexNum {
-		return errors.New("Out of Row index")
-	}
-	if rowNum < 0 {
-		return errors.New("Out of Row index")
+	err = indexWriter.Flush()
+	if err != nil {
+		return err
 	}
-	indexOff := self.convertIndexNumToOffset(rowNum)
-
-	var b []byte
 	b = make([]byte, binary.MaxVarintLen64)
-	_, err = self.indexfile.ReadAt(b, indexOff)
+	binary.PutVarint(b, tableOff)
+	_, err = newIndex.WriteAt(b, int64(binary.MaxVarintLen64))
 	if err != nil {
 		return err
 	}
-	tableOff, num := binary.Varint(b)
-	if num == 0 {
-		return errors.New("Failed to read table index")
+	err = newTable.Sync()
+	if err != nil {
+		return err
 	}
-	b = make([]byte, 1)
-	b[0] = ROW_DELETED
-	_, err = self.tablefile.WriteAt(b, tableOff)
-	err = self.tablefile.Sync()
+	err = newIndex.Sync()
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func (self *TableDynamic) GetTableType() string {
-	return "dynamic"
-}
 
-//**************************************************
-
-func (self *TableDynamic) openConfigFile(configfilename string) error {
-
-	jsonString, err := ioutil.ReadFile(configfilename)
+	//The marker file commits the compaction. Open finishes the swap after a crash.
+	marker, err := os.OpenFile(basename+".compact", os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
-
-	var columnTypes []ColumnType
-	err = json.Unmarshal(jsonString, &columnTypes)
+	err = marker.Close()
 	if err != nil {
 		return err
 	}
-	err = self.setColumns(columnTypes)
+	err = syncDir(self.directory)
 	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func (self *TableDynamic) openTableFile(tablefilename string) error {
-	f, err := os.OpenFile(tablefilename, os.O_RDWR+os.O_CREATE, 0666)
+	err = self.closeFiles()
 	if err != nil {
 		return err
 	}
-	self.tablefile = f
-
-	b := make([]byte, binary.MaxVarintLen64)
-	num, err := self.tablefile.ReadAt(b, 0)
+	err = self.recoverCompaction()
 	if err != nil {
-		if err == io.EOF {
-			self.fileVersion = DYNAMIC1_TABLE
-			binary.PutVarint(b, self.fileVersion)
-			num, err = self.tablefile.WriteAt(b, 0)
-			if err != nil {
-				return err
-			}
-			err = self.tablefile.Sync()
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	} else {
-		self.fileVersion, num = binary.Varint(b)
-		if num == 0 {
-			return errors.New("Failed to read fileversion")
-		}
-		if self.fileVersion != DYNAMIC1_TABLE {
-			return errors.New("Fileversion is not correct")
-		}
+		return err
 	}
-
-	return err
+	err = self.openTableFile(basename + ".table")
+	if err != nil {
+		return err
+	}
+	return self.openIndexFile(basename + ".index")
 }
 
-func (self *TableDynamic) openIndexFile(indexfilename string) error {
-	f, err := os.OpenFile(indexfilename, os.O_RDWR+os.O_CREATE, 0666)
+/*
+ alterTable rewrites table file and index file with changed columns.
+ Like Compact, deleted rows point to one shared deleted marker.
+*/
+func (self *TableDynamic) alterTable(changes []ColumnChange) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	plan, err := planAlter(self.config(), changes, true)
+	if err != nil {
+		return err
+	}
+	next := &TableDynamic{}
+	err = next.setColumns(plan.config.Columns)
+	if err != nil {
+		return err
+	}
+	next.fileVersion = DYNAMIC3_TABLE
+	next.nullBytes = nullBitmapBytes(next.columnTypes)
+	lastIndexNum, err := self.searchLastIndexNum()
 	if err != nil {
 		return err
 	}
-	self.indexfile = f
 
-	b := make([]byte, binary.MaxVarintLen64)
-	num, err := self.indexfile.ReadAt(b, 0)
+	basename := self.directory + self.tablename
+	newTable, err := os.OpenFile(basename+".table"+alterSuffix, os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
 	if err != nil {
-		if err == io.EOF {
-			binary.PutVarint(b, DYNAMIC1_INDEX)
-			num, err = self.indexfile.WriteAt(b, 0)
+		return err
+	}
+	defer newTable.Close()
+	newIndex, err := os.OpenFile(basename+".index"+alterSuffix, os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer newIndex.Close()
+	//Incomplete files are removed when the table is not altered.
+	committed := false
+	defer func() {
+		if committed == false {
+			recoverAlter(self.directory, self.tablename)
+		}
+	}()
+
+	tableWriter := bufio.NewWriterSize(newTable, scanBufferSize)
+	indexWriter := bufio.NewWriterSize(newIndex, scanBufferSize)
+	tableWriter.Write(encodeHeader(next.fileVersion))
+	deletedOff := headerBytes(next.fileVersion)
+	tableWriter.WriteByte(ROW_DELETED)
+	tableOff := deletedOff + 1
+
+	//Last table offset is written after all rows are copied.
+	b := make([]byte, binary.MaxVarintLen64*2)
+	binary.PutVarint(b, DYNAMIC1_INDEX)
+	indexWriter.Write(b)
+
+	status := make([]byte, 1)
+	for i := int64(0); i < lastIndexNum; i++ {
+		oldOff, oldLengths, err := self.readIndexEntry(i)
+		if err != nil {
+			return err
+		}
+		_, err = self.tablefile.ReadAt(status, oldOff)
+		if err != nil {
+			return err
+		}
+		entry := make([]byte, binary.MaxVarintLen64*(next.numOfFlexibleColumn+1))
+		if status[0] == ROW_DELETED {
+			binary.PutVarint(entry, deletedOff)
+			for j := int64(0); j < next.numOfFlexibleColumn; j++ {
+				binary.PutVarint(entry[binary.MaxVarintLen64*(j+1):], 0)
+			}
+		} else {
+			data := make([]byte, self.rowBytes(oldLengths))
+			_, err = self.tablefile.ReadAt(data, oldOff)
 			if err != nil {
 				return err
 			}
-			binary.PutVarint(b, int64(binary.MaxVarintLen64))
-			num, err = self.indexfile.WriteAt(b, int64(num))
+			row, err := self.decodeRecord(data, oldLengths)
 			if err != nil {
 				return err
 			}
-			err = self.indexfile.Sync()
+			row, err = plan.convert(row)
 			if err != nil {
 				return err
 			}
-		} else {
-			return err
+			data, lengths, err := next.encodeRow(row)
+			if err != nil {
+				return err
+			}
+			_, err = tableWriter.Write(data)
+			if err != nil {
+				return err
+			}
+			binary.PutVarint(entry, tableOff)
+			for j, l := range lengths {
+				binary.PutVarint(entry[binary.MaxVarintLen64*(j+1):], l)
+			}
+			tableOff += int64(len(data))
+		}
+		_, err = indexWriter.Write(entry)
+		if err != nil {
+			return err
+		}
+	}
+	err = tableWriter.Flush()
+	if err != nil {
+		return err
+	}
+	err = indexWriter.Flush()
+	if err != nil {
+		return err
+	}
+	b = make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(b, tableOff)
+	_, err = newIndex.WriteAt(b, int64(binary.MaxVarintLen64))
+	if err != nil {
+		return err
+	}
+	err = newTable.Sync()
+	if err != nil {
+		return err
+	}
+	err = newIndex.Sync()
+	if err != nil {
+		return err
+	}
+	committed = true
+	err = self.close()
+	if err != nil {
+		return err
+	}
+	err = commitAlter(self.directory, self.tablename, plan)
+	if err != nil {
+		self.open(self.directory, self.tablename)
+		return err
+	}
+	return self.open(self.directory, self.tablename)
+}
+
+/*
+ Verify checks headers, index entries and rows of the table and returns problems found.
+ When repair is true, index entries at the end whose rows are beyond table file and
+ bytes after the last table offset are truncated, and the last table offset is rebuilt
+ from the rows. Corrupted rows in the middle are only reported.
+*/
+func (self *TableDynamic) Verify(repair bool) ([]Problem, error) {
+	if repair {
+		self.mutex.Lock()
+		defer self.mutex.Unlock()
+	} else {
+		self.mutex.RLock()
+		defer self.mutex.RUnlock()
+	}
+	if self.tablefile == nil {
+		return nil, ErrTableClosed
+	}
+	if repair && self.readOnly {
+		return nil, ErrReadOnly
+	}
+	if repair && self.tx != nil {
+		return nil, ErrTableInTx
+	}
+	if repair && self.wal != nil {
+		//Truncated files must not be extended by records in the log at the replay.
+		err := self.wal.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	problems := []Problem{}
+	_, err := readHeader(self.tablefile)
+	if err != nil {
+		//Rows can not be found without the headers.
+		return append(problems, newProblem(-1, "Header of table file: %s", err)), nil
+	}
+	b := make([]byte, binary.MaxVarintLen64)
+	_, err = self.indexfile.ReadAt(b, 0)
+	v, num := binary.Varint(b)
+	if err != nil || num < 1 || v != DYNAMIC1_INDEX {
+		return append(problems, newProblem(-1, "Header of index file is invalid")), nil
+	}
+	tableSize, err := self.tablefile.Size()
+	if err != nil {
+		return problems, err
+	}
+	indexSize, err := self.indexfile.Size()
+	if err != nil {
+		return problems, err
+	}
+	lastIndexNum := self.convertOffsetToIndexNum(indexSize)
+	tail := indexSize - self.convertIndexNumToOffset(lastIndexNum)
+	if tail != 0 {
+		problem := newProblem(lastIndexNum, "Torn entry of %d bytes at the end of index file", tail)
+		if repair {
+			err = self.indexfile.Truncate(self.convertIndexNumToOffset(lastIndexNum))
+			if err != nil {
+				return problems, err
+			}
+			problem.Repaired = true
+		}
+		problems = append(problems, problem)
+	}
+
+	//rowsEnd is the end of rows which index entries point to.
+	rowsEnd := headerBytes(self.fileVersion)
+	//torn is positions in problems of entries at the end whose rows are beyond table file.
+	torn := []int{}
+	status := make([]byte, 1)
+	for i := int64(0); i < lastIndexNum; i++ {
+		tableOff, lengths, err := self.readIndexEntry(i)
+		if err != nil {
+			problems = append(problems, newProblem(i, "Index entry is broken: %s", err))
+			torn = nil
+			continue
+		}
+		valid := true
+		for _, l := range lengths {
+			if l < 0 {
+				valid = false
+			}
+		}
+		if valid == false || tableOff < headerBytes(self.fileVersion) {
+			problems = append(problems, newProblem(i, "Index entry is invalid"))
+			torn = nil
+			continue
+		}
+		if tableOff >= tableSize {
+			torn = append(torn, len(problems))
+			problems = append(problems, newProblem(i, "Row at %d is beyond the end of table file", tableOff))
+			continue
+		}
+		_, err = self.tablefile.ReadAt(status, tableOff)
+		if err != nil {
+			return problems, err
+		}
+		if status[0] == ROW_DELETED {
+			//Deleted rows may point to the shared deleted marker of one byte.
+			if tableOff+1 > rowsEnd {
+				rowsEnd = tableOff + 1
+			}
+			torn = nil
+			continue
+		}
+		size := self.rowBytes(lengths)
+		if tableOff+size > tableSize {
+			torn = append(torn, len(problems))
+			problems = append(problems, newProblem(i, "Row at %d is beyond the end of table file", tableOff))
+			continue
+		}
+		torn = nil
+		if tableOff+size > rowsEnd {
+			rowsEnd = tableOff + size
+		}
+		if status[0] != ROW_NORMAL {
+			problems = append(problems, newProblem(i, "Invalid status byte %d", status[0]))
+			continue
+		}
+		data := make([]byte, size)
+		_, err = self.tablefile.ReadAt(data, tableOff)
+		if err != nil {
+			return problems, err
+		}
+		_, err = self.decodeRecord(data, lengths)
+		if err != nil {
+			problems = append(problems, newProblem(i, "%s", err))
+		}
+	}
+	if repair && len(torn) > 0 {
+		//Rows which were not written completely are dropped from the end.
+		err = self.indexfile.Truncate(self.convertIndexNumToOffset(problems[torn[0]].RowNum))
+		if err != nil {
+			return problems, err
+		}
+		for _, j := range torn {
+			problems[j].Repaired = true
+		}
+	}
+
+	lastOff, err := self.searchLastTableOffset()
+	var problem Problem
+	if err != nil {
+		problem = newProblem(-1, "Last table offset is not readable")
+	} else if lastOff < rowsEnd {
+		problem = newProblem(-1, "Last table offset %d is before the end of rows %d", lastOff, rowsEnd)
+	} else if lastOff > tableSize {
+		problem = newProblem(-1, "Last table offset %d is beyond the end of table file %d", lastOff, tableSize)
+	}
+	if problem.Detail != "" {
+		if repair {
+			err = self.writeLastTableOffset(rowsEnd)
+			if err == nil {
+				err = commitFiles(self.wal, self.indexfile)
+			}
+			if err != nil {
+				return problems, err
+			}
+			problem.Repaired = true
+			lastOff = rowsEnd
+		}
+		problems = append(problems, problem)
+	}
+	if lastOff >= rowsEnd && lastOff < tableSize {
+		problem = newProblem(-1, "%d bytes after the last table offset", tableSize-lastOff)
+		if repair {
+			err = self.tablefile.Truncate(lastOff)
+			if err != nil {
+				return problems, err
+			}
+			problem.Repaired = true
+		}
+		problems = append(problems, problem)
+	}
+	return problems, nil
+}
+
+func (self *TableDynamic) GetTableType() string {
+	return "dynamic"
+}
+
+//**************************************************
+
+//writeRow stages the writes of WriteRow.
+func (self *TableDynamic) writeRow(row Row) (int64, Row, error) {
+	if self.tablefile == nil {
+		return -1, nil, ErrTableClosed
+	}
+	if self.readOnly {
+		return -1, nil, ErrReadOnly
+	}
+	row, identity, err := assignIdentity(self.columnTypes, row, &self.nextIdentity)
+	if err != nil {
+		return -1, nil, err
+	}
+	if identity != "" && self.nextIdentity > self.savedIdentity {
+		//The counter is saved before the row, so a value is never assigned twice.
+		err = self.saveConfigFile(self.directory + self.tablename + ".config")
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	row, err = applyDefaults(self.columnTypes, row, self.autoIncrement)
+	if err != nil {
+		return -1, nil, err
+	}
+	var tableOff, indexNum int64
+	if self.batch != nil {
+		tableOff = self.batch.tableOff
+		indexNum = self.batch.indexNum
+	} else {
+		tableOff, err = self.searchLastTableOffset()
+		if err != nil {
+			return -1, nil, err
+		}
+		indexNum, err = self.searchLastIndexNum()
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+
+	b, lengths, err := self.encodeRow(row)
+	if err != nil {
+		return -1, nil, err
+	}
+	_, err = self.tablefile.WriteAt(b, tableOff)
+	if err != nil {
+		return -1, nil, err
+	}
+	err = self.writeIndexEntry(indexNum, tableOff, lengths)
+	if err != nil {
+		return -1, nil, err
+	}
+	if self.batch != nil {
+		self.batch.tableOff = tableOff + int64(len(b))
+		self.batch.indexNum = indexNum + 1
+	} else {
+		err = self.writeLastTableOffset(tableOff + int64(len(b)))
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	if len(self.indexes) > 0 {
+		newRow, err := self.decodeRow(b[1:], lengths)
+		if err != nil {
+			return -1, nil, err
+		}
+		err = insertIndexes(self.indexes, newRow, indexNum)
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	return indexNum, row, nil
+}
+
+//updateRow stages the writes of UpdateRow.
+func (self *TableDynamic) updateRow(rowNum int64, row Row) error {
+	if self.tablefile == nil {
+		return ErrTableClosed
+	}
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	err := checkRequired(self.columnTypes, row)
+	if err != nil {
+		return err
+	}
+	lastIndexNum, err := self.searchLastIndexNum()
+	if err != nil {
+		return err
+	}
+	if rowNum >= lastIndexNum {
+		return ErrOutOfRowIndex
+	}
+	if rowNum < 0 {
+		return ErrOutOfRowIndex
+	}
+	tableOff, oldLengths, err := self.readIndexEntry(rowNum)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 1)
+	_, err = self.tablefile.ReadAt(b, tableOff)
+	if err != nil {
+		return err
+	}
+	if b[0] == ROW_DELETED {
+		return ErrRowDeleted
+	}
+	oldSize := self.rowBytes(oldLengths)
+	if len(self.indexes) > 0 || hasIdentity(self.columnTypes) {
+		oldRow, err := self.readRow(rowNum)
+		if err != nil {
+			return err
+		}
+		row, err = keepIdentity(self.columnTypes, row, oldRow)
+		if err != nil {
+			return err
+		}
+		err = removeIndexes(self.indexes, oldRow, rowNum)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, lengths, err := self.encodeRow(row)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) <= oldSize {
+		_, err = self.tablefile.WriteAt(b, tableOff)
+		if err != nil {
+			return err
+		}
+	} else {
+		tableOff, err = self.searchLastTableOffset()
+		if err != nil {
+			return err
+		}
+		_, err = self.tablefile.WriteAt(b, tableOff)
+		if err != nil {
+			return err
+		}
+		err = self.writeLastTableOffset(tableOff + int64(len(b)))
+		if err != nil {
+			return err
+		}
+	}
+	err = self.writeIndexEntry(rowNum, tableOff, lengths)
+	if err != nil {
+		return err
+	}
+	if len(self.indexes) > 0 {
+		newRow, err := self.decodeRow(b[1:], lengths)
+		if err != nil {
+			return err
+		}
+		return insertIndexes(self.indexes, newRow, rowNum)
+	}
+	return nil
+}
+
+//deleteRow stages the writes of DeleteRow.
+func (self *TableDynamic) deleteRow(rowNum int64) error {
+	if self.tablefile == nil {
+		return ErrTableClosed
+	}
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	lastIndexNum, err := self.searchLastIndexNum()
+	if err != nil {
+		return err
+	}
+	if rowNum >= lastIndexNum {
+		return ErrOutOfRowIndex
+	}
+	if rowNum < 0 {
+		return ErrOutOfRowIndex
+	}
+	if len(self.indexes) > 0 {
+		oldRow, err := self.readRow(rowNum)
+		if err == nil {
+			err = removeIndexes(self.indexes, oldRow, rowNum)
+		}
+		if err != nil && err != ErrRowDeleted {
+			return err
+		}
+	}
+	indexOff := self.convertIndexNumToOffset(rowNum)
+
+	var b []byte
+	b = make([]byte, binary.MaxVarintLen64)
+	_, err = self.indexfile.ReadAt(b, indexOff)
+	if err != nil {
+		return err
+	}
+	tableOff, num := binary.Varint(b)
+	if num == 0 {
+		return errors.New("Failed to read table index")
+	}
+	b = make([]byte, 1)
+	b[0] = ROW_DELETED
+	_, err = self.tablefile.WriteAt(b, tableOff)
+	return err
+}
+
+/*
+ commit writes staged writes with durability of the table. The lock must be held.
+ A group commit releases the lock while it waits for the sync, so other writers can join the sync.
+*/
+func (self *TableDynamic) commit() error {
+	wal := self.wal
+	seq, err := commitFilesWith(wal, self.durability, self.dataFiles()...)
+	if err != nil || seq == 0 {
+		return err
+	}
+	self.mutex.Unlock()
+	defer self.mutex.Lock()
+	return wal.waitCommit(seq)
+}
+
+//rollback drops staged writes.
+func (self *TableDynamic) rollback() {
+	self.batch = nil
+	rollbackFiles(self.dataFiles()...)
+}
+
+//beginBatch starts to keep the end of rows in memory. The lock must be held.
+func (self *TableDynamic) beginBatch() error {
+	if self.tablefile == nil {
+		return ErrTableClosed
+	}
+	tableOff, err := self.searchLastTableOffset()
+	if err != nil {
+		return err
+	}
+	indexNum, err := self.searchLastIndexNum()
+	if err != nil {
+		return err
+	}
+	self.batch = &dynamicBatch{tableOff: tableOff, indexNum: indexNum}
+	return nil
+}
+
+//endBatch writes the end of rows staged in the batch on the header.
+func (self *TableDynamic) endBatch() error {
+	batch := self.batch
+	self.batch = nil
+	if batch == nil {
+		return nil
+	}
+	return self.writeLastTableOffset(batch.tableOff)
+}
+
+func (self *TableDynamic) setWriteAheadLog(wal *writeAheadLog) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.wal = wal
+}
+
+//dataFiles returns files which are written by the table.
+func (self *TableDynamic) dataFiles() []*dataFile {
+	return append([]*dataFile{self.tablefile, self.indexfile}, indexFiles(self.indexes)...)
+}
+
+//createIndex builds an index on columns and saves it in the config file.
+func (self *TableDynamic) createIndex(columns []string, unique bool) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	index, err := buildIndex(self.directory, self.tablename, self.indexes, columns, unique, self.columnTypes, self)
+	if err != nil {
+		return err
+	}
+	index.lock = &self.mutex
+	self.indexes = append(self.indexes, index)
+	err = self.saveConfigFile(self.directory + self.tablename + ".config")
+	if err != nil {
+		self.indexes = self.indexes[:len(self.indexes)-1]
+		index.tree.file.Close()
+		os.Remove(indexFilename(self.directory, self.tablename, index.Name))
+		return err
+	}
+	return nil
+}
+
+func (self *TableDynamic) getIndex(columns []string) (*Index, error) {
+	return findIndex(self.indexes, columns)
+}
+
+//addConstraint adds primary key or unique constraint and saves it in the config file.
+func (self *TableDynamic) addConstraint(columns []string, primary bool) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	err := addConstraint(self, &self.constraints, columns, primary)
+	if err != nil {
+		return err
+	}
+	return self.saveConfigFile(self.directory + self.tablename + ".config")
+}
+
+func (self *TableDynamic) readRowByKey(key []interface{}) (Row, int64, error) {
+	return readRowByKey(self, self.indexes, self.constraints, key)
+}
+
+func (self *TableDynamic) setTransaction(tx *Tx) {
+	self.tx = tx
+}
+
+func (self *TableDynamic) getTransaction() *Tx {
+	return self.tx
+}
+
+//setFiletype sets the file type of config file.
+func (self *TableDynamic) setFiletype(filetype string) {
+	self.filetype = filetype
+}
+
+//setReadOnly makes the table open files read-only and refuse writes.
+func (self *TableDynamic) setReadOnly(readOnly bool) {
+	self.readOnly = readOnly
+}
+
+//getFileVersion returns the version of table file.
+func (self *TableDynamic) getFileVersion() int64 {
+	return self.fileVersion
+}
+
+//setDurability changes durability and saves it in the config file.
+func (self *TableDynamic) setDurability(durability string) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	err := checkDurability(durability)
+	if err != nil {
+		return err
+	}
+	old := self.durability
+	self.durability = durability
+	err = self.saveConfigFile(self.directory + self.tablename + ".config")
+	if err != nil {
+		self.durability = old
+	}
+	return err
+}
+
+func (self *TableDynamic) getDurability() string {
+	if self.durability == "" {
+		return DURABILITY_SYNC
+	}
+	return self.durability
+}
+
+func (self *TableDynamic) openConfigFile(configfilename string) error {
+	config, err := loadTableConfig(configfilename)
+	if err != nil {
+		return err
+	}
+	err = self.setColumns(config.Columns)
+	if err != nil {
+		return err
+	}
+	self.filetype = config.filetype
+	self.durability = config.Durability
+	self.nextIdentity = config.NextIdentity
+	if self.nextIdentity < 1 {
+		self.nextIdentity = 1
+	}
+	self.savedIdentity = self.nextIdentity
+	self.indexes, err = openIndexes(self.directory, self.tablename, config.Indexes, self.columnTypes, self.readOnly)
+	if err != nil {
+		return err
+	}
+	for _, v := range self.indexes {
+		v.lock = &self.mutex
+	}
+	self.constraints = newConstraints(config)
+	err = markConstraintIndexes(self.indexes, self.constraints)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (self *TableDynamic) openTableFile(tablefilename string) error {
+	f, err := os.OpenFile(tablefilename, openFlag(self.readOnly), 0666)
+	if err != nil {
+		return err
+	}
+	self.tablefile = newDataFile(f)
+
+	self.fileVersion, err = readHeader(self.tablefile)
+	if err != nil {
+		if err == io.EOF {
+			self.fileVersion = DYNAMIC3_TABLE
+			_, err = self.tablefile.WriteAt(encodeHeader(self.fileVersion), 0)
+			if err != nil {
+				return err
+			}
+			err = commitFiles(nil, self.tablefile)
+			if err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+	} else {
+		if self.fileVersion != DYNAMIC1_TABLE && self.fileVersion != DYNAMIC2_TABLE && self.fileVersion != DYNAMIC3_TABLE {
+			return errors.New("Fileversion is not correct")
+		}
+	}
+	self.nullBytes = 0
+	if self.fileVersion != DYNAMIC1_TABLE {
+		self.nullBytes = nullBitmapBytes(self.columnTypes)
+	} else if hasNullable(self.columnTypes) {
+		return errors.New("Nullable column is not supported by fileversion")
+	}
+
+	return err
+}
+
+func (self *TableDynamic) openIndexFile(indexfilename string) error {
+	f, err := os.OpenFile(indexfilename, openFlag(self.readOnly), 0666)
+	if err != nil {
+		return err
+	}
+	self.indexfile = newDataFile(f)
+
+	b := make([]byte, binary.MaxVarintLen64)
+	num, err := self.indexfile.ReadAt(b, 0)
+	if err != nil {
+		if err == io.EOF {
+			binary.PutVarint(b, DYNAMIC1_INDEX)
+			num, err = self.indexfile.WriteAt(b, 0)
+			if err != nil {
+				return err
+			}
+			//Rows are written after the header of table file.
+			binary.PutVarint(b, headerBytes(self.fileVersion))
+			num, err = self.indexfile.WriteAt(b, int64(num))
+			if err != nil {
+				return err
+			}
+			err = commitFiles(nil, self.indexfile)
+			if err != nil {
+				return err
+			}
+		} else {
+			return err
 		}
 	} else {
 		v, num := binary.Varint(b)
@@ -418,22 +1305,50 @@ func (self *TableDynamic) openIndexFile(indexfilename string) error {
 }
 
 func (self *TableDynamic) saveConfigFile(configfile string) error {
-	b, err := json.Marshal(self.columnTypes)
+	err := saveTableConfig(configfile, self.config())
 	if err != nil {
 		return err
 	}
+	self.savedIdentity = self.nextIdentity
+	return nil
+}
 
-	err = ioutil.WriteFile(configfile, b, os.ModePerm)
-	if err != nil {
-		return err
+//reserveIdentity saves the counter of identity advanced by n, so writeRow does not save it for each of n rows.
+func (self *TableDynamic) reserveIdentity(n int64) error {
+	if hasIdentity(self.columnTypes) == false || self.tablefile == nil || self.readOnly {
+		return nil
 	}
-	return nil
+	next := self.nextIdentity
+	self.nextIdentity += n
+	err := self.saveConfigFile(self.directory + self.tablename + ".config")
+	self.nextIdentity = next
+	return err
+}
+
+//config returns the content of config file.
+func (self *TableDynamic) config() *tableConfig {
+	config := &tableConfig{}
+	config.Columns = self.columnTypes
+	config.Indexes = indexConfigs(self.indexes)
+	config.filetype = self.filetype
+	if self.durability != DURABILITY_SYNC {
+		config.Durability = self.durability
+	}
+	self.constraints.save(config)
+	if hasIdentity(self.columnTypes) {
+		config.NextIdentity = self.nextIdentity
+	}
+	return config
 }
 
 func (self *TableDynamic) setColumns(columnTypes []ColumnType) error {
 	columnBytes := int64(0)
 	numOfFlexibleColumn := int64(0)
 	flags := map[string]int{}
+	err := checkIdentity(columnTypes)
+	if err != nil {
+		return err
+	}
 
 	for _, val := range columnTypes {
 		_, ok := flags[val.Name]
@@ -441,6 +1356,10 @@ func (self *TableDynamic) setColumns(columnTypes []ColumnType) error {
 			return errors.New("Same column name exists.")
 		}
 		flags[val.Name] = 1
+		err := val.checkDefault()
+		if err != nil {
+			return err
+		}
 		num, err := val.GetBytes()
 		if err != nil {
 			return err
@@ -457,6 +1376,194 @@ func (self *TableDynamic) setColumns(columnTypes []ColumnType) error {
 	return nil
 }
 
+/*
+ recoverCompaction finishes or discards the files of Compact.
+ When the marker file exists, the new files are moved to the place of the old ones.
+ Otherwise the new files are incomplete and removed.
+*/
+func (self *TableDynamic) recoverCompaction() error {
+	basename := self.directory + self.tablename
+	_, err := os.Stat(basename + ".compact")
+	if err != nil {
+		if os.IsNotExist(err) == false {
+			return err
+		}
+		os.Remove(basename + ".table.compact")
+		os.Remove(basename + ".index.compact")
+		return nil
+	}
+	for _, ext := range []string{".table", ".index"} {
+		_, err = os.Stat(basename + ext + ".compact")
+		if err == nil {
+			err = os.Rename(basename+ext+".compact", basename+ext)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = syncDir(self.directory)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(basename + ".compact")
+	if err != nil {
+		return err
+	}
+	return syncDir(self.directory)
+}
+
+/*
+ encodeRow converts row to the bytes of table file and the sizes of flexible columns.
+ A flexible column of NULL has no bytes. The checksum of the row is added at the end
+ when the file version has checksums.
+*/
+func (self *TableDynamic) encodeRow(row Row) ([]byte, []int64, error) {
+	result := make([]byte, 1+self.nullBytes, self.columnBytes+self.nullBytes+1)
+	result[0] = ROW_NORMAL
+	lengths := []int64{}
+	for i, v := range self.columnTypes {
+		//Size of column may be given by type, so flexible columns are found by GetBytes.
+		size, err := v.GetBytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		var b []byte
+		if v.isNull(row) {
+			setNullBit(result[1:], i)
+			if size != 0 {
+				b, err = v.GetNil()
+			}
+		} else if val, ok := row[v.Name]; ok && val != nil {
+			b, err = v.ConvertToBytes(val)
+		} else {
+			b, err = v.GetNil()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, b...)
+		if size == 0 {
+			lengths = append(lengths, int64(len(b)))
+		}
+	}
+	if hasChecksum(self.fileVersion) {
+		result = appendChecksum(result)
+	}
+	return result, lengths, nil
+}
+
+//decodeRecord verifies the checksum of the bytes of a row including the status byte and converts them to row.
+func (self *TableDynamic) decodeRecord(b []byte, lengths []int64) (Row, error) {
+	if hasChecksum(self.fileVersion) {
+		err := verifyChecksum(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return self.decodeRow(b[1:], lengths)
+}
+
+//decodeRow converts the bytes of table file without the status byte to row. NULL is nil.
+func (self *TableDynamic) decodeRow(b []byte, lengths []int64) (Row, error) {
+	result := make(Row)
+	if int64(len(b)) < self.nullBytes {
+		return nil, errors.New("Failed to read row")
+	}
+	bitmap := b[:self.nullBytes]
+	off := self.nullBytes
+	flexNum := 0
+	for i, v := range self.columnTypes {
+		size, err := v.GetBytes()
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			size = lengths[flexNum]
+			flexNum++
+		}
+		if off+size > int64(len(b)) {
+			return nil, errors.New("Failed to read row")
+		}
+		if getNullBit(bitmap, i) {
+			result[v.Name] = nil
+			off += size
+			continue
+		}
+		result[v.Name], err = v.ConvertToVal(b[off : off+size])
+		if err != nil {
+			return nil, err
+		}
+		off += size
+	}
+	return result, nil
+}
+
+//payloadSize returns the size of row data without the status byte.
+func (self *TableDynamic) payloadSize(lengths []int64) int64 {
+	size := self.columnBytes + self.nullBytes
+	for _, l := range lengths {
+		size += l
+	}
+	return size
+}
+
+//rowBytes returns the size of a row in table file including the status byte and checksum.
+func (self *TableDynamic) rowBytes(lengths []int64) int64 {
+	if hasChecksum(self.fileVersion) {
+		return self.payloadSize(lengths) + 1 + checksumBytes
+	}
+	return self.payloadSize(lengths) + 1
+}
+
+//parseIndexEntry converts the bytes of an index entry to table offset and sizes of flexible columns.
+func (self *TableDynamic) parseIndexEntry(b []byte) (int64, []int64, error) {
+	tableOff, num := binary.Varint(b)
+	if num < 1 {
+		return -1, nil, errors.New("Failed to read table index")
+	}
+	lengths := make([]int64, self.numOfFlexibleColumn)
+	for i := range lengths {
+		start := binary.MaxVarintLen64 * (i + 1)
+		lengths[i], num = binary.Varint(b[start:])
+		if num < 1 {
+			return -1, nil, errors.New("Failed to read index")
+		}
+	}
+	return tableOff, lengths, nil
+}
+
+//readIndexEntry returns table offset and sizes of flexible columns of the row.
+func (self *TableDynamic) readIndexEntry(indexNum int64) (int64, []int64, error) {
+	b := make([]byte, binary.MaxVarintLen64*(self.numOfFlexibleColumn+1))
+	num, err := self.indexfile.ReadAt(b, self.convertIndexNumToOffset(indexNum))
+	if err != nil {
+		return -1, nil, err
+	}
+	if num != len(b) {
+		return -1, nil, errors.New("Failed to read table index")
+	}
+	return self.parseIndexEntry(b)
+}
+
+//writeIndexEntry writes table offset and sizes of flexible columns of the row.
+func (self *TableDynamic) writeIndexEntry(indexNum int64, tableOff int64, lengths []int64) error {
+	b := make([]byte, binary.MaxVarintLen64*(len(lengths)+1))
+	binary.PutVarint(b, tableOff)
+	for i, l := range lengths {
+		binary.PutVarint(b[binary.MaxVarintLen64*(i+1):], l)
+	}
+	_, err := self.indexfile.WriteAt(b, self.convertIndexNumToOffset(indexNum))
+	return err
+}
+
+//writeLastTableOffset writes the end of table data on the index file header.
+func (self *TableDynamic) writeLastTableOffset(tableOff int64) error {
+	b := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(b, tableOff)
+	_, err := self.indexfile.WriteAt(b, int64(binary.MaxVarintLen64))
+	return err
+}
+
 func (self *TableDynamic) convertIndexNumToOffset(indexNum int64) int64 {
 	offset := indexNum*(int64(binary.MaxVarintLen64)*(self.numOfFlexibleColumn+1)) + int64(binary.MaxVarintLen64)*2
 	return offset
@@ -467,7 +1574,7 @@ func (self *TableDynamic) convertOffsetToIndexNum(offset int64) int64 {
 }
 
 func (self *TableDynamic) searchLastIndexNum() (int64, error) {
-	lastOff, err := self.indexfile.Seek(0, 2)
+	lastOff, err := self.indexfile.Size()
 	if err != nil {
 		return -1, err
 	}
@@ -490,5 +1597,124 @@ func (self *TableDynamic) searchLastTableOffset() (int64, error) {
 }
 
 func (self *TableDynamic) GetColumns() []ColumnType {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.columnTypes
+}
+
+func (self *TableDynamic) columns() []ColumnType {
 	return self.columnTypes
 }
+
+func (self *TableDynamic) lock() *sync.RWMutex {
+	return &self.mutex
+}
+
+/*
+ tableDynamicIterator reads index file sequentially.
+ Table file is also read sequentially while rows are stored in order.
+*/
+type tableDynamicIterator struct {
+	lock        *sync.RWMutex
+	table       *TableDynamic
+	indexReader *bufio.Reader
+	tableReader *bufio.Reader
+	tablePos    int64
+	indexBuf    []byte
+	rowNum      int64
+	lastRowNum  int64
+	endOff      int64 //End of index entries at Scan
+	generation  int64 //generation of the table at Scan
+	changes     int64 //changes of files when the buffers were filled
+	row         Row
+	err         error
+}
+
+func (self *tableDynamicIterator) Next() bool {
+	if self.lock != nil {
+		self.lock.RLock()
+		defer self.lock.RUnlock()
+	}
+	self.row = nil
+	if self.err == nil && self.rowNum+1 < self.lastRowNum {
+		self.err = self.refresh()
+	}
+	for self.err == nil {
+		self.rowNum++
+		if self.rowNum >= self.lastRowNum {
+			return false
+		}
+		_, err := io.ReadFull(self.indexReader, self.indexBuf)
+		if err != nil {
+			self.err = err
+			return false
+		}
+		tableOff, lengths, err := self.table.parseIndexEntry(self.indexBuf)
+		if err != nil {
+			self.err = err
+			return false
+		}
+		if tableOff != self.tablePos {
+			self.tableReader.Reset(io.NewSectionReader(self.table.tablefile, tableOff, math.MaxInt64-tableOff))
+			self.tablePos = tableOff
+		}
+		status, err := self.tableReader.ReadByte()
+		if err != nil {
+			self.err = err
+			return false
+		}
+		self.tablePos++
+		if status == ROW_DELETED {
+			continue
+		}
+		b := make([]byte, self.table.rowBytes(lengths))
+		b[0] = status
+		_, err = io.ReadFull(self.tableReader, b[1:])
+		if err != nil {
+			self.err = err
+			return false
+		}
+		self.tablePos += int64(len(b)) - 1
+		self.row, self.err = self.table.decodeRecord(b, lengths)
+		return self.err == nil
+	}
+	return false
+}
+
+/*
+ refresh checks the table before Next reads it. The lock must be held.
+ Buffers are dropped when files were written after they were filled.
+*/
+func (self *tableDynamicIterator) refresh() error {
+	if self.generation != self.table.generation {
+		return ErrIteratorInvalidated
+	}
+	changes := self.table.indexfile.changes + self.table.tablefile.changes
+	if changes != self.changes {
+		startOff := self.table.convertIndexNumToOffset(self.rowNum + 1)
+		self.indexReader.Reset(io.NewSectionReader(self.table.indexfile, startOff, self.endOff-startOff))
+		self.tablePos = -1
+		self.changes = changes
+	}
+	return nil
+}
+
+func (self *tableDynamicIterator) Row() Row {
+	return self.row
+}
+
+func (self *tableDynamicIterator) RowNum() int64 {
+	return self.rowNum
+}
+
+func (self *tableDynamicIterator) Err() error {
+	return self.err
+}
+
+func (self *tableDynamicIterator) Close() error {
+	self.indexReader = nil
+	self.tableReader = nil
+	self.row = nil
+	self.rowNum = self.lastRowNum
+	return nil
+}
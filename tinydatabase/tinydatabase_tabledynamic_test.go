@@ -2,6 +2,7 @@ package tinydatabase
 
 import (
 	//"fmt"
+	"io/ioutil"
 	"os"
 	//"path"
 	"strings"
@@ -278,3 +279,489 @@ func Test2_TableDynamic_errUsage(t *testing.T) {
 	tableInst.Close()
 
 }
+
+func Test3_TableDynamic_updateRow(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "testdynamic"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 0},
+		{Name: "strline2", Type: COLUMN_STRING, Size: 0},
+	}
+
+	var tableInst TableInterface
+	tableInst = &TableDynamic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+
+	testRow := Row{"intline": int64(1), "strline": "first row", "strline2": "aaaa"}
+	_, err = tableInst.WriteRow(testRow)
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	testRow = Row{"intline": int64(2), "strline": "second row", "strline2": "bbbb"}
+	_, err = tableInst.WriteRow(testRow)
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+
+	//Shorter row is overwritten in place.
+	testRow = Row{"intline": int64(10), "strline": "short", "strline2": "cc"}
+	err = tableInst.UpdateRow(0, testRow)
+	if err != nil {
+		t.Errorf("Failed to update row at 0: %s", err)
+	}
+	testRow2, err := tableInst.ReadRow(0)
+	if err != nil {
+		t.Errorf("Failed to read row at 0: %s", err)
+	}
+	if testRow2["intline"] != int64(10) || testRow2["strline"] != "short" || testRow2["strline2"] != "cc" {
+		t.Errorf("Failed to update row at 0: %v", testRow2)
+	}
+
+	//Longer row is relocated.
+	testRow = Row{"intline": int64(11), "strline": "this is much longer than before", "strline2": "dddddddd"}
+	err = tableInst.UpdateRow(0, testRow)
+	if err != nil {
+		t.Errorf("Failed to update row at 0: %s", err)
+	}
+	testRow2, err = tableInst.ReadRow(0)
+	if err != nil {
+		t.Errorf("Failed to read row at 0: %s", err)
+	}
+	if testRow2["intline"] != int64(11) || testRow2["strline"] != testRow["strline"] || testRow2["strline2"] != "dddddddd" {
+		t.Errorf("Failed to relocate row at 0: %v", testRow2)
+	}
+	testRow2, err = tableInst.ReadRow(1)
+	if err != nil {
+		t.Errorf("Failed to read row at 1: %s", err)
+	}
+	if testRow2["intline"] != int64(2) || testRow2["strline"] != "second row" || testRow2["strline2"] != "bbbb" {
+		t.Errorf("Failed to keep row at 1: %v", testRow2)
+	}
+
+	num, err := tableInst.WriteRow(Row{"intline": int64(3), "strline": "third row", "strline2": "eeee"})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 2 {
+		t.Errorf("Failed to insert row at 2: %d", num)
+	}
+	testRow2, err = tableInst.ReadRow(2)
+	if err != nil {
+		t.Errorf("Failed to read row at 2: %s", err)
+	}
+	if testRow2["strline"] != "third row" {
+		t.Errorf("Failed to write row after relocation: %v", testRow2)
+	}
+
+	err = tableInst.DeleteRow(1)
+	if err != nil {
+		t.Errorf("Failed to delete row at 1: %s", err)
+	}
+	err = tableInst.UpdateRow(1, Row{"intline": int64(3)})
+	if err == nil || err.Error() != "Deleted row" {
+		t.Errorf("Failed to refuse updating deleted row: %v", err)
+	}
+	err = tableInst.UpdateRow(3, Row{"intline": int64(3)})
+	if err == nil {
+		t.Errorf("Failed to raise error for invalid row")
+	}
+
+	it, err := tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan table: %s", err)
+	}
+	expected := []int64{11, 3}
+	i := 0
+	for it.Next() {
+		if i >= len(expected) || it.Row()["intline"] != expected[i] {
+			t.Errorf("Failed to scan relocated row at %d: %v", it.RowNum(), it.Row())
+		}
+		i++
+	}
+	if it.Err() != nil || i != len(expected) {
+		t.Errorf("Failed to scan table: %v, %d rows", it.Err(), i)
+	}
+	it.Close()
+
+	tableInst.Close()
+}
+
+func Test4_TableDynamic_scan(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "testdynamic"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 0},
+	}
+
+	var tableInst TableInterface
+	tableInst = &TableDynamic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+
+	it, err := tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan empty table: %s", err)
+	}
+	if it.Next() {
+		t.Errorf("Failed to scan empty table: row %d", it.RowNum())
+	}
+	it.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = tableInst.WriteRow(Row{"intline": int64(i), "strline": strings.Repeat("a", i+1)})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	err = tableInst.DeleteRow(1)
+	if err != nil {
+		t.Errorf("Failed to delete row at 1: %s", err)
+	}
+	err = tableInst.DeleteRow(4)
+	if err != nil {
+		t.Errorf("Failed to delete row at 4: %s", err)
+	}
+	_, err = tableInst.ReadRow(1)
+	if err != ErrRowDeleted {
+		t.Errorf("Failed to return ErrRowDeleted: %v", err)
+	}
+	_, err = tableInst.ReadRow(5)
+	if err != ErrOutOfRowIndex {
+		t.Errorf("Failed to return ErrOutOfRowIndex: %v", err)
+	}
+
+	it, err = tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan table: %s", err)
+	}
+	rowNums := []int64{}
+	for it.Next() {
+		row := it.Row()
+		if row["intline"] != it.RowNum() {
+			t.Errorf("Failed to read row at %d: %v", it.RowNum(), row)
+		}
+		if row["strline"] != strings.Repeat("a", int(it.RowNum())+1) {
+			t.Errorf("Failed to read row at %d: %v", it.RowNum(), row)
+		}
+		rowNums = append(rowNums, it.RowNum())
+	}
+	if it.Err() != nil {
+		t.Errorf("Failed to scan table: %s", it.Err())
+	}
+	err = it.Close()
+	if err != nil {
+		t.Errorf("Failed to close iterator: %s", err)
+	}
+	if len(rowNums) != 3 || rowNums[0] != 0 || rowNums[1] != 2 || rowNums[2] != 3 {
+		t.Errorf("Failed to skip deleted rows: %v", rowNums)
+	}
+
+	count, err := tableInst.CountRows()
+	if err != nil {
+		t.Errorf("Failed to count rows: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("Failed to count rows: %d", count)
+	}
+
+	//Rows written while iterating are read as they are when Next reaches them.
+	it, err = tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan table: %s", err)
+	}
+	if it.Next() == false || it.RowNum() != 0 {
+		t.Errorf("Failed to read first row: %d, %v", it.RowNum(), it.Err())
+	}
+	err = tableInst.UpdateRow(2, Row{"intline": int64(20), "strline": "updated row which is longer than before"})
+	if err != nil {
+		t.Errorf("Failed to update row at 2: %s", err)
+	}
+	err = tableInst.DeleteRow(3)
+	if err != nil {
+		t.Errorf("Failed to delete row at 3: %s", err)
+	}
+	if it.Next() == false || it.RowNum() != 2 || it.Row()["intline"] != int64(20) || it.Row()["strline"] != "updated row which is longer than before" {
+		t.Errorf("Failed to read updated row: %d, %v, %v", it.RowNum(), it.Row(), it.Err())
+	}
+	if it.Next() || it.Err() != nil {
+		t.Errorf("Failed to skip row deleted while iterating: %d, %v", it.RowNum(), it.Err())
+	}
+	it.Close()
+
+	it, err = tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan table: %s", err)
+	}
+	it.Next()
+	err = tableInst.(*TableDynamic).Compact()
+	if err != nil {
+		t.Errorf("Failed to compact table: %s", err)
+	}
+	if it.Next() || it.Err() != ErrIteratorInvalidated {
+		t.Errorf("Failed to invalidate iterator: %v", it.Err())
+	}
+	it.Close()
+
+	tableInst.Close()
+}
+
+func Test5_TableDynamic_compact(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "testdynamic"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 0},
+	}
+
+	tableInst := &TableDynamic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err = tableInst.WriteRow(Row{"intline": int64(i), "strline": strings.Repeat("a", 100)})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	for i := int64(0); i < 10; i += 2 {
+		err = tableInst.DeleteRow(i)
+		if err != nil {
+			t.Errorf("Failed to delete row at %d: %s", i, err)
+		}
+	}
+	err = tableInst.UpdateRow(3, Row{"intline": int64(3), "strline": strings.Repeat("b", 200)})
+	if err != nil {
+		t.Errorf("Failed to update row at 3: %s", err)
+	}
+	before, _ := os.Stat(directory + tablename + ".table")
+
+	err = tableInst.Compact()
+	if err != nil {
+		t.Errorf("Failed to compact table: %s", err)
+	}
+	after, _ := os.Stat(directory + tablename + ".table")
+	if after.Size() >= before.Size()*2/3 {
+		t.Errorf("Failed to reduce table file: %d -> %d", before.Size(), after.Size())
+	}
+	_, err = os.Stat(directory + tablename + ".compact")
+	if err == nil {
+		t.Errorf("Failed to remove compaction marker")
+	}
+
+	check := func() {
+		for i := int64(0); i < 10; i++ {
+			row, err := tableInst.ReadRow(i)
+			if i%2 == 0 {
+				if err != ErrRowDeleted {
+					t.Errorf("Failed to keep deleted row at %d: %v", i, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Failed to read row at %d: %s", i, err)
+				continue
+			}
+			expected := strings.Repeat("a", 100)
+			if i == 3 {
+				expected = strings.Repeat("b", 200)
+			}
+			if row["intline"] != i || row["strline"] != expected {
+				t.Errorf("Failed to keep row at %d: %v", i, row)
+			}
+		}
+		count, err := tableInst.CountRows()
+		if err != nil || count != 5 {
+			t.Errorf("Failed to count rows after compaction: %d, %v", count, err)
+		}
+	}
+	check()
+
+	num, err := tableInst.WriteRow(Row{"intline": int64(10), "strline": "new"})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 10 {
+		t.Errorf("Failed to insert row at 10: %d", num)
+	}
+	err = tableInst.DeleteRow(10)
+	if err != nil {
+		t.Errorf("Failed to delete row at 10: %s", err)
+	}
+	err = tableInst.DeleteRow(0)
+	if err != nil {
+		t.Errorf("Failed to delete deleted row at 0: %s", err)
+	}
+
+	//Incomplete files without marker are discarded on Open.
+	tableInst.Close()
+	err = ioutil.WriteFile(directory+tablename+".table.compact", []byte("broken"), 0666)
+	if err != nil {
+		t.Errorf("Failed to write file: %s", err)
+	}
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Errorf("Failed to open table: %s", err)
+	}
+	_, err = os.Stat(directory + tablename + ".table.compact")
+	if err == nil {
+		t.Errorf("Failed to remove incomplete compaction file")
+	}
+	check()
+
+	//Compaction with marker is finished on Open.
+	err = tableInst.Compact()
+	if err != nil {
+		t.Errorf("Failed to compact table: %s", err)
+	}
+	tableInst.Close()
+	os.Rename(directory+tablename+".table", directory+tablename+".table.compact")
+	os.Rename(directory+tablename+".index", directory+tablename+".index.compact")
+	ioutil.WriteFile(directory+tablename+".table", []byte("old"), 0666)
+	ioutil.WriteFile(directory+tablename+".compact", []byte{}, 0666)
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Errorf("Failed to open table: %s", err)
+	}
+	check()
+
+	tableInst.Close()
+}
+
+func Test6_TableDynamic_nullable(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "nulltime", Type: COLUMN_TIME, Size: 15, Nullable: true},
+		{Name: "nullstr", Type: COLUMN_STRING, Size: 0, Nullable: true},
+		{Name: "strline", Type: COLUMN_STRING, Size: 0},
+	}
+	tableInst := &TableDynamic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	if tableInst.fileVersion != DYNAMIC3_TABLE {
+		t.Errorf("Failed to create table with null bitmap: %d", tableInst.fileVersion)
+	}
+	_, err = tableInst.WriteRow(Row{"intline": int64(1), "nullstr": "", "strline": "a"})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	_, err = tableInst.WriteRow(Row{"intline": int64(2), "nullstr": nil, "strline": "b"})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	row, err := tableInst.ReadRow(0)
+	if err != nil || row["nulltime"] != nil || row["nullstr"] != "" || row["strline"] != "a" {
+		t.Errorf("Failed to read row: %v, %v", row, err)
+	}
+	row, err = tableInst.ReadRow(1)
+	if err != nil || row["nulltime"] != nil || row["nullstr"] != nil || row["strline"] != "b" {
+		t.Errorf("Failed to read NULL: %v, %v", row, err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	err = tableInst.UpdateRow(1, Row{"intline": int64(2), "nulltime": now, "nullstr": "longer string", "strline": "b"})
+	if err != nil {
+		t.Errorf("Failed to update row: %s", err)
+	}
+
+	count := 0
+	it, err := tableInst.Scan()
+	if err != nil {
+		t.Fatalf("Failed to scan table: %s", err)
+	}
+	for it.Next() {
+		count++
+		row = it.Row()
+	}
+	if it.Err() != nil || count != 2 || row["nullstr"] != "longer string" || row["nulltime"].(time.Time).Equal(now) == false {
+		t.Errorf("Failed to scan rows with NULL: %d, %v, %v", count, row, it.Err())
+	}
+	it.Close()
+	tableInst.Close()
+}
+
+func Test7_TableDynamic_checksum(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 8},
+	}
+	tableInst := &TableDynamic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = tableInst.WriteRow(Row{"intline": int64(i), "strline": "abc"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	tableOff, _, _ := tableInst.readIndexEntry(1)
+	off := int(tableOff) + 3
+	tableInst.Close()
+
+	//A flipped bit in a row is reported instead of the row.
+	data, _ := ioutil.ReadFile(directory + tablename + ".table")
+	data[off] ^= 0x01
+	ioutil.WriteFile(directory+tablename+".table", data, 0666)
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Fatalf("Failed to open table: %s", err)
+	}
+	_, err = tableInst.ReadRow(1)
+	if err != ErrCorruptRow {
+		t.Errorf("Failed to detect corrupted row: %v", err)
+	}
+	row, err := tableInst.ReadRow(2)
+	if err != nil || row["intline"] != int64(2) {
+		t.Errorf("Failed to read row: %v, %v", row, err)
+	}
+	it, err := tableInst.Scan()
+	if err != nil {
+		t.Fatalf("Failed to scan: %s", err)
+	}
+	for it.Next() {
+	}
+	if it.Err() != ErrCorruptRow || it.RowNum() != 1 {
+		t.Errorf("Failed to detect corrupted row by scan: %d, %v", it.RowNum(), it.Err())
+	}
+	it.Close()
+	tableInst.Close()
+
+	//A flipped bit in the header is found on opening.
+	data[off] ^= 0x01
+	data[1] ^= 0x01
+	ioutil.WriteFile(directory+tablename+".table", data, 0666)
+	err = tableInst.Open(directory, tablename)
+	if err != ErrCorruptHeader {
+		t.Errorf("Failed to detect corrupted header: %v", err)
+	}
+	tableInst.Close()
+}
@@ -1,26 +1,50 @@
 package tinydatabase
 
 import (
+	"bufio"
 	//"bytes"
 	"encoding/binary"
-	"encoding/json"
+	//"encoding/json"
 	"errors"
 	//"fmt"
 	"io"
-	"io/ioutil"
+	//"io/ioutil"
 	//"math"
 	"os"
 	"path"
 	//"strconv"
+	"sync"
 	//"time"
 )
 
-//TableStatic is a fixed size row table.
+/*
+ TableStatic is a fixed size row table.
+ Exported methods lock the table, so it can be shared by goroutines.
+ Unexported methods are called while the lock is held.
+*/
 type TableStatic struct {
-	tablefile   *os.File
-	fileVersion int64
-	columnTypes []ColumnType
-	columnBytes int64
+	mutex          sync.RWMutex
+	tablefile      *dataFile
+	freefile       *dataFile
+	wal            *writeAheadLog
+	tx             *Tx
+	directory      string
+	tablename      string
+	configfilename string
+	fileVersion    int64
+	columnTypes    []ColumnType
+	columnBytes    int64
+	nullBytes      int64
+	slotReuse      bool
+	indexes        []*Index
+	constraints    constraints
+	autoIncrement  map[string]int64
+	nextIdentity   int64
+	savedIdentity  int64 //nextIdentity in the config file
+	filetype       string
+	durability     string
+	readOnly       bool
+	generation     int64 //Incremented when files are closed, so iterators of old files fail
 }
 
 /*
@@ -28,10 +52,11 @@ type TableStatic struct {
  When files exist, returns error.
 */
 func (self *TableStatic) NewTable(directory string, tablename string, columnTypes []ColumnType) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	directory = path.Clean(directory)
 	directory = directory + "/"
 	dCheck, err := os.Stat(directory)
-	dCheck = dCheck
 	if err != nil {
 		return err
 	}
@@ -47,7 +72,11 @@ func (self *TableStatic) NewTable(directory string, tablename string, columnType
 	if err == nil {
 		return errors.New("Table file exists.")
 	}
-	err = self.Close()
+	_, err = os.Stat(directory + tablename + ".free")
+	if err == nil {
+		return errors.New("Free list file exists.")
+	}
+	err = self.close()
 	if err != nil {
 		return err
 	}
@@ -56,7 +85,13 @@ func (self *TableStatic) NewTable(directory string, tablename string, columnType
 	if err != nil {
 		return err
 	}
-	err = self.saveConfigFile(directory + tablename + ".config")
+	self.slotReuse = true
+	self.constraints = constraints{}
+	self.nextIdentity = 1
+	self.directory = directory
+	self.tablename = tablename
+	self.configfilename = directory + tablename + ".config"
+	err = self.saveConfigFile(self.configfilename)
 	if err != nil {
 		return err
 	}
@@ -65,20 +100,44 @@ func (self *TableStatic) NewTable(directory string, tablename string, columnType
 	if err != nil {
 		return err
 	}
-	return nil
+	err = self.openFreeFile(directory + tablename + ".free")
+	if err != nil {
+		return err
+	}
+	self.autoIncrement, err = loadAutoIncrement(self, self.columnTypes)
+	return err
 }
 
 /*
  Open func opens table file and config file.
 */
 func (self *TableStatic) Open(directory string, tablename string) error {
-	err := self.Close()
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.open(directory, tablename)
+}
+
+//open opens files of the table.
+func (self *TableStatic) open(directory string, tablename string) error {
+	err := self.close()
 	if err != nil {
 		return err
 	}
 	directory = path.Clean(directory)
 	directory = directory + "/"
-	err = self.openConfigFile(directory + tablename + ".config")
+	self.directory = directory
+	self.tablename = tablename
+	self.configfilename = directory + tablename + ".config"
+	if self.readOnly {
+		//Files left by a crash are not recovered without writing.
+		err = checkRecovered(recoveryFilenames(directory, tablename)...)
+	} else {
+		err = recoverAlter(directory, tablename)
+	}
+	if err != nil {
+		return err
+	}
+	err = self.openConfigFile(self.configfilename)
 	if err != nil {
 		return err
 	}
@@ -86,153 +145,726 @@ func (self *TableStatic) Open(directory string, tablename string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	err = self.openFreeFile(directory + tablename + ".free")
+	if err != nil {
+		return err
+	}
+	self.autoIncrement, err = loadAutoIncrement(self, self.columnTypes)
+	return err
 }
 
 func (self *TableStatic) Close() error {
-	if self.tablefile == nil {
-		return nil
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.close()
+}
+
+//close closes files and indexes of the table.
+func (self *TableStatic) close() error {
+	self.generation++
+	if self.tablefile != nil && self.wal != nil {
+		//Writes which are not synced are synced before files are closed, renamed or removed.
+		err := self.wal.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	if self.tablefile != nil {
+		err := self.tablefile.Close()
+		if err != nil {
+			return err
+		}
+		self.tablefile = nil
+	}
+	if self.freefile != nil {
+		err := self.freefile.Close()
+		if err != nil {
+			return err
+		}
+		self.freefile = nil
 	}
-	err := self.tablefile.Close()
+	err := closeIndexes(self.indexes)
 	if err != nil {
 		return err
 	}
-	self.tablefile = nil
+	self.indexes = nil
 	return nil
 }
 
+/*
+ SetSlotReuse func enables or disables reusing slots of deleted rows.
+ Disable it when row numbers are used as permanent IDs.
+ The setting is saved in the config file.
+*/
+func (self *TableStatic) SetSlotReuse(reuse bool) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	self.slotReuse = reuse
+	return self.saveConfigFile(self.configfilename)
+}
+
+//GetSlotReuse returns whether slots of deleted rows are reused.
+func (self *TableStatic) GetSlotReuse() bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.slotReuse
+}
+
 /*
  WriteRow func writes row on table file.
+ When slot reuse is enabled, a slot of deleted row is used first.
+ The row is not changed. Use WriteRowReturning to get the assigned identity.
 */
 func (self *TableStatic) WriteRow(row Row) (int64, error) {
-	rowNum, err := self.searchLastRowNum()
+	rowNum, _, err := self.WriteRowReturning(row)
+	return rowNum, err
+}
+
+/*
+ WriteRowReturning func writes row like WriteRow and returns the written row,
+ which has the assigned identity and default values.
+*/
+func (self *TableStatic) WriteRowReturning(row Row) (int64, Row, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return -1, nil, ErrTableInTx
+	}
+	rowNum, written, err := self.writeRow(row)
 	if err != nil {
-		return -1, err
+		self.rollback()
+		return -1, nil, err
+	}
+	err = self.commit()
+	if err != nil {
+		return -1, nil, err
+	}
+	return rowNum, written, nil
+}
+
+/*
+ WriteRows func writes rows and returns their row numbers.
+ The rows are committed with one sync, so all of them or none of them are written.
+*/
+func (self *TableStatic) WriteRows(rows []Row) ([]int64, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return nil, ErrTableInTx
+	}
+	return writeRows(self, rows)
+}
+
+/*
+ UpdateRow func overwrites the row at rowNum.
+ The row keeps its row number.
+*/
+func (self *TableStatic) UpdateRow(rowNum int64, row Row) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	err := self.updateRow(rowNum, row)
+	if err != nil {
+		self.rollback()
+		return err
+	}
+	return self.commit()
+}
+
+func (self *TableStatic) ReadRow(rowNum int64) (Row, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.readRow(rowNum)
+}
+
+func (self *TableStatic) readRow(rowNum int64) (Row, error) {
+	if self.tablefile == nil {
+		return nil, ErrTableClosed
+	}
+	lastRowNum, err := self.searchLastRowNum()
+	if err != nil {
+		return nil, err
+	}
+	if rowNum >= lastRowNum {
+		return nil, ErrOutOfRowIndex
+	}
+	if rowNum < 0 {
+		return nil, ErrOutOfRowIndex
 	}
 	targetOff := self.convertRowNumToOffset(rowNum)
-	var b []byte
-	b = make([]byte, 1)
-	b[0] = ROW_NORMAL
-	num, err := self.tablefile.WriteAt(b, targetOff)
+
+	b := make([]byte, self.slotBytes())
+	_, err = self.tablefile.ReadAt(b, targetOff)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	targetOff = targetOff + int64(num)
-	for _, v := range self.columnTypes {
-		if val, ok := row[v.Name]; ok {
-			b, err = v.ConvertToBytes(val)
+	if b[0] == ROW_DELETED {
+		return nil, ErrRowDeleted
+	}
+	return self.decodeSlot(b)
+}
+
+func (self *TableStatic) DeleteRow(rowNum int64) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	err := self.deleteRow(rowNum)
+	if err != nil {
+		self.rollback()
+		return err
+	}
+	return self.commit()
+}
+
+/*
+ Scan func returns an iterator over all rows which are not deleted.
+ Rows written after Scan are not returned.
+*/
+func (self *TableStatic) Scan() (RowIterator, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.scan(&self.mutex)
+}
+
+/*
+ scan returns an iterator over rows. Each Next of the iterator holds lock.
+ lock is nil when the caller holds the lock of the table.
+*/
+func (self *TableStatic) scan(lock *sync.RWMutex) (RowIterator, error) {
+	if self.tablefile == nil {
+		return nil, ErrTableClosed
+	}
+	lastRowNum, err := self.searchLastRowNum()
+	if err != nil {
+		return nil, err
+	}
+	startOff := self.convertRowNumToOffset(0)
+	endOff := self.convertRowNumToOffset(lastRowNum)
+	result := &tableStaticIterator{}
+	result.lock = lock
+	result.table = self
+	result.reader = bufio.NewReaderSize(io.NewSectionReader(self.tablefile, startOff, endOff-startOff), scanBufferSize)
+	result.buf = make([]byte, self.slotBytes())
+	result.rowNum = -1
+	result.lastRowNum = lastRowNum
+	result.endOff = endOff
+	result.generation = self.generation
+	result.changes = self.tablefile.changes
+	return result, nil
+}
+
+//CountRows returns the number of rows which are not deleted.
+func (self *TableStatic) CountRows() (int64, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return countRows(self)
+}
+
+/*
+ alterTable rewrites the table file with changed columns.
+ Slots of deleted rows stay deleted, so the free list is not changed.
+*/
+func (self *TableStatic) alterTable(changes []ColumnChange) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	plan, err := planAlter(self.config(), changes, false)
+	if err != nil {
+		return err
+	}
+	next := &TableStatic{}
+	err = next.setColumns(plan.config.Columns)
+	if err != nil {
+		return err
+	}
+	next.fileVersion = STATIC3
+	next.nullBytes = nullBitmapBytes(next.columnTypes)
+	lastRowNum, err := self.searchLastRowNum()
+	if err != nil {
+		return err
+	}
+
+	basename := self.directory + self.tablename
+	newTable, err := os.OpenFile(basename+".table"+alterSuffix, os.O_RDWR+os.O_CREATE+os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer newTable.Close()
+	//Incomplete files are removed when the table is not altered.
+	committed := false
+	defer func() {
+		if committed == false {
+			recoverAlter(self.directory, self.tablename)
+		}
+	}()
+	writer := bufio.NewWriterSize(newTable, scanBufferSize)
+	writer.Write(encodeHeader(next.fileVersion))
+	slot := make([]byte, self.slotBytes())
+	for rowNum := int64(0); rowNum < lastRowNum; rowNum++ {
+		_, err = self.tablefile.ReadAt(slot, self.convertRowNumToOffset(rowNum))
+		if err != nil {
+			return err
+		}
+		b := make([]byte, next.slotBytes())
+		if slot[0] != ROW_DELETED {
+			row, err := self.decodeSlot(slot)
 			if err != nil {
-				return -1, err
+				return err
 			}
-		} else {
-			b, err = v.GetNil()
+			row, err = plan.convert(row)
 			if err != nil {
-				return -1, err
+				return err
+			}
+			b, err = next.encodeRow(row)
+			if err != nil {
+				return err
 			}
 		}
-		num, err := self.tablefile.WriteAt(b, targetOff)
+		_, err = writer.Write(b)
 		if err != nil {
-			return -1, err
+			return err
 		}
-		targetOff = targetOff + int64(num)
 	}
-	err = self.tablefile.Sync()
+	err = writer.Flush()
 	if err != nil {
-		return -1, err
+		return err
+	}
+	err = newTable.Sync()
+	if err != nil {
+		return err
 	}
-	return rowNum, nil
+	committed = true
+	err = self.close()
+	if err != nil {
+		return err
+	}
+	err = commitAlter(self.directory, self.tablename, plan)
+	if err != nil {
+		self.open(self.directory, self.tablename)
+		return err
+	}
+	return self.open(self.directory, self.tablename)
 }
 
-func (self *TableStatic) ReadRow(rowNum int64) (Row, error) {
+/*
+ Verify checks the header and all slots of the table file and returns problems found.
+ When repair is true, a torn slot at the end of the file is truncated.
+ Corrupted rows are only reported.
+*/
+func (self *TableStatic) Verify(repair bool) ([]Problem, error) {
+	if repair {
+		self.mutex.Lock()
+		defer self.mutex.Unlock()
+	} else {
+		self.mutex.RLock()
+		defer self.mutex.RUnlock()
+	}
+	if self.tablefile == nil {
+		return nil, ErrTableClosed
+	}
+	if repair && self.readOnly {
+		return nil, ErrReadOnly
+	}
+	if repair && self.tx != nil {
+		return nil, ErrTableInTx
+	}
+	if repair && self.wal != nil {
+		//Truncated files must not be extended by records in the log at the replay.
+		err := self.wal.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	problems := []Problem{}
+	_, err := readHeader(self.tablefile)
+	if err != nil {
+		//Slots can not be found without the header.
+		return append(problems, newProblem(-1, "Header of table file: %s", err)), nil
+	}
+	size, err := self.tablefile.Size()
+	if err != nil {
+		return problems, err
+	}
+	lastRowNum := self.convertOffsetToRowNum(size)
+	tail := size - self.convertRowNumToOffset(lastRowNum)
+	if tail != 0 {
+		problem := newProblem(lastRowNum, "Torn slot of %d bytes at the end of table file", tail)
+		if repair {
+			err = self.tablefile.Truncate(self.convertRowNumToOffset(lastRowNum))
+			if err != nil {
+				return problems, err
+			}
+			problem.Repaired = true
+		}
+		problems = append(problems, problem)
+	}
+	slot := make([]byte, self.slotBytes())
+	for rowNum := int64(0); rowNum < lastRowNum; rowNum++ {
+		_, err = self.tablefile.ReadAt(slot, self.convertRowNumToOffset(rowNum))
+		if err != nil {
+			return problems, err
+		}
+		if slot[0] == ROW_DELETED {
+			continue
+		}
+		if slot[0] != ROW_NORMAL {
+			problems = append(problems, newProblem(rowNum, "Invalid status byte %d", slot[0]))
+			continue
+		}
+		_, err = self.decodeSlot(slot)
+		if err != nil {
+			problems = append(problems, newProblem(rowNum, "%s", err))
+		}
+	}
+	return problems, nil
+}
+
+func (self *TableStatic) GetTableType() string {
+	return "static"
+}
+
+//**************************************************
+
+//writeRow stages the writes of WriteRow.
+func (self *TableStatic) writeRow(row Row) (int64, Row, error) {
+	if self.tablefile == nil {
+		return -1, nil, ErrTableClosed
+	}
+	if self.readOnly {
+		return -1, nil, ErrReadOnly
+	}
+	row, identity, err := assignIdentity(self.columnTypes, row, &self.nextIdentity)
+	if err != nil {
+		return -1, nil, err
+	}
+	if identity != "" && self.nextIdentity > self.savedIdentity {
+		//The counter is saved before the row, so a value is never assigned twice.
+		err = self.saveConfigFile(self.configfilename)
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	row, err = applyDefaults(self.columnTypes, row, self.autoIncrement)
+	if err != nil {
+		return -1, nil, err
+	}
+	b, err := self.encodeRow(row)
+	if err != nil {
+		return -1, nil, err
+	}
+	rowNum := int64(-1)
+	freeCount := int64(-1)
+	if self.slotReuse {
+		rowNum, freeCount, err = self.searchFreeSlot()
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	if rowNum < 0 {
+		rowNum, err = self.searchLastRowNum()
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	_, err = self.tablefile.WriteAt(b, self.convertRowNumToOffset(rowNum))
+	if err != nil {
+		return -1, nil, err
+	}
+	if freeCount >= 0 {
+		err = self.writeFreeCount(freeCount)
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	if len(self.indexes) > 0 {
+		newRow, err := self.decodeRow(b[1:])
+		if err != nil {
+			return -1, nil, err
+		}
+		err = insertIndexes(self.indexes, newRow, rowNum)
+		if err != nil {
+			return -1, nil, err
+		}
+	}
+	return rowNum, row, nil
+}
+
+//updateRow stages the writes of UpdateRow.
+func (self *TableStatic) updateRow(rowNum int64, row Row) error {
+	if self.tablefile == nil {
+		return ErrTableClosed
+	}
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	err := checkRequired(self.columnTypes, row)
+	if err != nil {
+		return err
+	}
 	lastRowNum, err := self.searchLastRowNum()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if rowNum > lastRowNum {
-		return nil, errors.New("Out of Row index")
+	if rowNum >= lastRowNum {
+		return ErrOutOfRowIndex
 	}
 	if rowNum < 0 {
-		return nil, errors.New("Out of Row index")
+		return ErrOutOfRowIndex
 	}
 	targetOff := self.convertRowNumToOffset(rowNum)
 
-	var b []byte
-	b = make([]byte, 1)
+	b := make([]byte, self.slotBytes())
 	_, err = self.tablefile.ReadAt(b, targetOff)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if b[0] == ROW_DELETED {
-		return nil, errors.New("Deleted row")
+		return ErrRowDeleted
 	}
-	targetOff = targetOff + 1
-
-	result := make(Row)
-	for _, v := range self.columnTypes {
-		b, err = v.GetNil()
+	if len(self.indexes) > 0 || hasIdentity(self.columnTypes) {
+		oldRow, err := self.decodeSlot(b)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		_, err = self.tablefile.ReadAt(b, targetOff)
+		row, err = keepIdentity(self.columnTypes, row, oldRow)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		result[v.Name], err = v.ConvertToVal(b)
+		err = removeIndexes(self.indexes, oldRow, rowNum)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		nextOff, err := v.GetBytes()
+	}
+
+	b, err = self.encodeRow(row)
+	if err != nil {
+		return err
+	}
+	_, err = self.tablefile.WriteAt(b, targetOff)
+	if err != nil {
+		return err
+	}
+	if len(self.indexes) > 0 {
+		newRow, err := self.decodeRow(b[1:])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		targetOff = targetOff + int64(nextOff)
+		return insertIndexes(self.indexes, newRow, rowNum)
 	}
-	return result, nil
+	return nil
 }
 
-func (self *TableStatic) DeleteRow(rowNum int64) error {
+//deleteRow stages the writes of DeleteRow.
+func (self *TableStatic) deleteRow(rowNum int64) error {
+	if self.tablefile == nil {
+		return ErrTableClosed
+	}
+	if self.readOnly {
+		return ErrReadOnly
+	}
 	lastRowNum, err := self.searchLastRowNum()
 	if err != nil {
 		return err
 	}
-	if rowNum > lastRowNum {
-		return errors.New("Out of Row index")
+	if rowNum >= lastRowNum {
+		return ErrOutOfRowIndex
 	}
 	if rowNum < 0 {
-		return errors.New("Out of Row index")
+		return ErrOutOfRowIndex
 	}
 
 	targetOff := self.convertRowNumToOffset(rowNum)
 	var b []byte
-	b = make([]byte, 1)
-	b[0] = ROW_DELETED
-	_, err = self.tablefile.WriteAt(b, targetOff)
-	err = self.tablefile.Sync()
+	b = make([]byte, self.slotBytes())
+	_, err = self.tablefile.ReadAt(b, targetOff)
 	if err != nil {
 		return err
 	}
+	if b[0] == ROW_DELETED {
+		return nil
+	}
+	if len(self.indexes) > 0 {
+		oldRow, err := self.decodeSlot(b)
+		if err != nil {
+			return err
+		}
+		err = removeIndexes(self.indexes, oldRow, rowNum)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = self.tablefile.WriteAt([]byte{ROW_DELETED}, targetOff)
+	if err != nil {
+		return err
+	}
+	if self.slotReuse {
+		return self.pushFreeSlot(rowNum)
+	}
 	return nil
 }
 
-func (self *TableStatic) GetTableType() string {
-	return "static"
+/*
+ commit writes staged writes with durability of the table. The lock must be held.
+ A group commit releases the lock while it waits for the sync, so other writers can join the sync.
+*/
+func (self *TableStatic) commit() error {
+	wal := self.wal
+	seq, err := commitFilesWith(wal, self.durability, self.dataFiles()...)
+	if err != nil || seq == 0 {
+		return err
+	}
+	self.mutex.Unlock()
+	defer self.mutex.Lock()
+	return wal.waitCommit(seq)
 }
 
-//**************************************************
+//rollback drops staged writes.
+func (self *TableStatic) rollback() {
+	rollbackFiles(self.dataFiles()...)
+}
 
-func (self *TableStatic) openConfigFile(configfilename string) error {
+func (self *TableStatic) setWriteAheadLog(wal *writeAheadLog) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.wal = wal
+}
+
+//dataFiles returns files which are written by the table.
+func (self *TableStatic) dataFiles() []*dataFile {
+	return append([]*dataFile{self.tablefile, self.freefile}, indexFiles(self.indexes)...)
+}
 
-	jsonString, err := ioutil.ReadFile(configfilename)
+//createIndex builds an index on columns and saves it in the config file.
+func (self *TableStatic) createIndex(columns []string, unique bool) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	index, err := buildIndex(self.directory, self.tablename, self.indexes, columns, unique, self.columnTypes, self)
+	if err != nil {
+		return err
+	}
+	index.lock = &self.mutex
+	self.indexes = append(self.indexes, index)
+	err = self.saveConfigFile(self.configfilename)
 	if err != nil {
+		self.indexes = self.indexes[:len(self.indexes)-1]
+		index.tree.file.Close()
+		os.Remove(indexFilename(self.directory, self.tablename, index.Name))
 		return err
 	}
+	return nil
+}
+
+func (self *TableStatic) getIndex(columns []string) (*Index, error) {
+	return findIndex(self.indexes, columns)
+}
 
-	var columnTypes []ColumnType
-	err = json.Unmarshal(jsonString, &columnTypes)
+//addConstraint adds primary key or unique constraint and saves it in the config file.
+func (self *TableStatic) addConstraint(columns []string, primary bool) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	if self.tx != nil {
+		return ErrTableInTx
+	}
+	err := addConstraint(self, &self.constraints, columns, primary)
 	if err != nil {
 		return err
 	}
-	err = self.setColumns(columnTypes)
+	return self.saveConfigFile(self.configfilename)
+}
+
+func (self *TableStatic) readRowByKey(key []interface{}) (Row, int64, error) {
+	return readRowByKey(self, self.indexes, self.constraints, key)
+}
+
+func (self *TableStatic) setTransaction(tx *Tx) {
+	self.tx = tx
+}
+
+func (self *TableStatic) getTransaction() *Tx {
+	return self.tx
+}
+
+//setFiletype sets the file type of config file.
+func (self *TableStatic) setFiletype(filetype string) {
+	self.filetype = filetype
+}
+
+//setReadOnly makes the table open files read-only and refuse writes.
+func (self *TableStatic) setReadOnly(readOnly bool) {
+	self.readOnly = readOnly
+}
+
+//getFileVersion returns the version of table file.
+func (self *TableStatic) getFileVersion() int64 {
+	return self.fileVersion
+}
+
+//setDurability changes durability and saves it in the config file.
+func (self *TableStatic) setDurability(durability string) error {
+	if self.readOnly {
+		return ErrReadOnly
+	}
+	err := checkDurability(durability)
+	if err != nil {
+		return err
+	}
+	old := self.durability
+	self.durability = durability
+	err = self.saveConfigFile(self.directory + self.tablename + ".config")
+	if err != nil {
+		self.durability = old
+	}
+	return err
+}
+
+func (self *TableStatic) getDurability() string {
+	if self.durability == "" {
+		return DURABILITY_SYNC
+	}
+	return self.durability
+}
+
+func (self *TableStatic) openConfigFile(configfilename string) error {
+	config, err := loadTableConfig(configfilename)
+	if err != nil {
+		return err
+	}
+	err = self.setColumns(config.Columns)
+	if err != nil {
+		return err
+	}
+	self.slotReuse = !config.DisableSlotReuse
+	self.filetype = config.filetype
+	self.durability = config.Durability
+	self.nextIdentity = config.NextIdentity
+	if self.nextIdentity < 1 {
+		self.nextIdentity = 1
+	}
+	self.savedIdentity = self.nextIdentity
+	self.indexes, err = openIndexes(self.directory, self.tablename, config.Indexes, self.columnTypes, self.readOnly)
+	if err != nil {
+		return err
+	}
+	for _, v := range self.indexes {
+		v.lock = &self.mutex
+	}
+	self.constraints = newConstraints(config)
+	err = markConstraintIndexes(self.indexes, self.constraints)
 	if err != nil {
 		return err
 	}
@@ -240,54 +872,226 @@ func (self *TableStatic) openConfigFile(configfilename string) error {
 	return nil
 }
 func (self *TableStatic) openTableFile(tablefilename string) error {
-	f, err := os.OpenFile(tablefilename, os.O_RDWR+os.O_CREATE, 0666)
+	f, err := os.OpenFile(tablefilename, openFlag(self.readOnly), 0666)
 	if err != nil {
 		return err
 	}
-	self.tablefile = f
+	self.tablefile = newDataFile(f)
 
-	b := make([]byte, binary.MaxVarintLen64)
-	num, err := self.tablefile.ReadAt(b, 0)
+	self.fileVersion, err = readHeader(self.tablefile)
 	if err != nil {
 		if err == io.EOF {
-			self.fileVersion = STATIC1
-			binary.PutVarint(b, self.fileVersion)
-			num, err = self.tablefile.WriteAt(b, 0)
+			self.fileVersion = STATIC3
+			_, err = self.tablefile.WriteAt(encodeHeader(self.fileVersion), 0)
 			if err != nil {
 				return err
 			}
+			err = commitFiles(nil, self.tablefile)
 		} else {
 			return err
 		}
 	} else {
-		self.fileVersion, num = binary.Varint(b)
-		if num == 0 {
-			return errors.New("Failed to read fileversion")
-		}
-		if self.fileVersion != STATIC1 {
+		if self.fileVersion != STATIC1 && self.fileVersion != STATIC2 && self.fileVersion != STATIC3 {
 			return errors.New("Fileversion is not correct")
 		}
 	}
+	self.nullBytes = 0
+	if self.fileVersion != STATIC1 {
+		self.nullBytes = nullBitmapBytes(self.columnTypes)
+	} else if hasNullable(self.columnTypes) {
+		return errors.New("Nullable column is not supported by fileversion")
+	}
 
 	return err
 }
 
-func (self *TableStatic) saveConfigFile(configfile string) error {
-	b, err := json.Marshal(self.columnTypes)
+/*
+ openFreeFile opens the list of deleted slots.
+ When the file is created for an existing table, deleted slots are collected from table file.
+ A read-only table without the file has no free list.
+*/
+func (self *TableStatic) openFreeFile(freefilename string) error {
+	f, err := os.OpenFile(freefilename, openFlag(self.readOnly), 0666)
+	if os.IsNotExist(err) && self.readOnly {
+		//Tables made before the free list have no file. The free list is not used without writing.
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	self.freefile = newDataFile(f)
 
-	err = ioutil.WriteFile(configfile, b, os.ModePerm)
+	b := make([]byte, binary.MaxVarintLen64)
+	_, err = self.freefile.ReadAt(b, 0)
+	if err != nil {
+		if err != io.EOF {
+			return err
+		}
+		binary.PutVarint(b, STATIC1_FREE)
+		_, err = self.freefile.WriteAt(b, 0)
+		if err != nil {
+			return err
+		}
+		err = self.writeFreeCount(0)
+		if err != nil {
+			return err
+		}
+		lastRowNum, err := self.searchLastRowNum()
+		if err != nil {
+			return err
+		}
+		status := make([]byte, 1)
+		for i := int64(0); i < lastRowNum; i++ {
+			_, err = self.tablefile.ReadAt(status, self.convertRowNumToOffset(i))
+			if err != nil {
+				return err
+			}
+			if status[0] == ROW_DELETED {
+				err = self.pushFreeSlot(i)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return commitFiles(nil, self.freefile)
+	}
+	v, num := binary.Varint(b)
+	if num < 1 {
+		return errors.New("Failed to read fileversion")
+	}
+	if v != STATIC1_FREE {
+		return errors.New("Fileversion is not correct")
+	}
+	return nil
+}
+
+/*
+ searchFreeSlot returns a deleted slot to reuse and the count of free list after using it.
+ When no slot is found, returns -1.
+ Entries which are not deleted any more (e.g. crash after writing a row) are skipped.
+*/
+func (self *TableStatic) searchFreeSlot() (int64, int64, error) {
+	count, err := self.readFreeCount()
+	if err != nil {
+		return -1, -1, err
+	}
+	if count == 0 {
+		return -1, -1, nil
+	}
+	lastRowNum, err := self.searchLastRowNum()
+	if err != nil {
+		return -1, -1, err
+	}
+	b := make([]byte, binary.MaxVarintLen64)
+	status := make([]byte, 1)
+	for count > 0 {
+		count--
+		_, err = self.freefile.ReadAt(b, self.convertFreeNumToOffset(count))
+		if err != nil {
+			return -1, -1, err
+		}
+		rowNum, num := binary.Varint(b)
+		if num < 1 {
+			return -1, -1, errors.New("Failed to read free list")
+		}
+		if rowNum < 0 || rowNum >= lastRowNum {
+			continue
+		}
+		_, err = self.tablefile.ReadAt(status, self.convertRowNumToOffset(rowNum))
+		if err != nil {
+			return -1, -1, err
+		}
+		if status[0] == ROW_DELETED {
+			return rowNum, count, nil
+		}
+	}
+	return -1, 0, nil
+}
+
+//pushFreeSlot adds a deleted slot to free list.
+func (self *TableStatic) pushFreeSlot(rowNum int64) error {
+	count, err := self.readFreeCount()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(b, rowNum)
+	_, err = self.freefile.WriteAt(b, self.convertFreeNumToOffset(count))
+	if err != nil {
+		return err
+	}
+	return self.writeFreeCount(count + 1)
+}
+
+func (self *TableStatic) readFreeCount() (int64, error) {
+	b := make([]byte, binary.MaxVarintLen64)
+	_, err := self.freefile.ReadAt(b, int64(binary.MaxVarintLen64))
+	if err != nil {
+		return -1, err
+	}
+	v, num := binary.Varint(b)
+	if num < 1 {
+		return -1, errors.New("Failed to read free list")
+	}
+	return v, nil
+}
+
+func (self *TableStatic) writeFreeCount(count int64) error {
+	b := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(b, count)
+	_, err := self.freefile.WriteAt(b, int64(binary.MaxVarintLen64))
+	return err
+}
+
+func (self *TableStatic) convertFreeNumToOffset(freeNum int64) int64 {
+	return freeNum*int64(binary.MaxVarintLen64) + int64(binary.MaxVarintLen64)*2
+}
+
+func (self *TableStatic) saveConfigFile(configfile string) error {
+	err := saveTableConfig(configfile, self.config())
 	if err != nil {
 		return err
 	}
+	self.savedIdentity = self.nextIdentity
 	return nil
 }
 
+//reserveIdentity saves the counter of identity advanced by n, so writeRow does not save it for each of n rows.
+func (self *TableStatic) reserveIdentity(n int64) error {
+	if hasIdentity(self.columnTypes) == false || self.tablefile == nil || self.readOnly {
+		return nil
+	}
+	next := self.nextIdentity
+	self.nextIdentity += n
+	err := self.saveConfigFile(self.configfilename)
+	self.nextIdentity = next
+	return err
+}
+
+//config returns the content of config file.
+func (self *TableStatic) config() *tableConfig {
+	config := &tableConfig{}
+	config.Columns = self.columnTypes
+	config.DisableSlotReuse = !self.slotReuse
+	config.Indexes = indexConfigs(self.indexes)
+	config.filetype = self.filetype
+	if self.durability != DURABILITY_SYNC {
+		config.Durability = self.durability
+	}
+	self.constraints.save(config)
+	if hasIdentity(self.columnTypes) {
+		config.NextIdentity = self.nextIdentity
+	}
+	return config
+}
+
 func (self *TableStatic) setColumns(columnTypes []ColumnType) error {
 	columnBytes := int64(0)
 	flags := map[string]int{}
+	err := checkIdentity(columnTypes)
+	if err != nil {
+		return err
+	}
 
 	for _, val := range columnTypes {
 		_, ok := flags[val.Name]
@@ -295,10 +1099,20 @@ func (self *TableStatic) setColumns(columnTypes []ColumnType) error {
 			return errors.New("Same column name exists.")
 		}
 		flags[val.Name] = 1
+		err := val.checkDefault()
+		if err != nil {
+			return err
+		}
 		num, err := val.GetBytes()
 		if err != nil {
 			return err
 		}
+		if num == 0 && val.Type == COLUMN_BYTES {
+			return errors.New("Variable size bytes column is not supported by static table.")
+		}
+		if val.Type == COLUMN_JSON || val.isArray() {
+			return errors.New("Column type " + val.Type + " is not supported by static table.")
+		}
 		val.Size = num
 		columnBytes = columnBytes + num
 	}
@@ -307,17 +1121,89 @@ func (self *TableStatic) setColumns(columnTypes []ColumnType) error {
 	return nil
 }
 
+/*
+ encodeRow converts row to the bytes of one slot including the status byte and null bitmap.
+ The checksum of the slot is added at the end when the file version has checksums.
+*/
+func (self *TableStatic) encodeRow(row Row) ([]byte, error) {
+	result := make([]byte, 1+self.nullBytes, self.slotBytes())
+	result[0] = ROW_NORMAL
+	for i, v := range self.columnTypes {
+		var b []byte
+		var err error
+		if v.isNull(row) {
+			setNullBit(result[1:], i)
+			b, err = v.GetNil()
+		} else if val, ok := row[v.Name]; ok && val != nil {
+			b, err = v.ConvertToBytes(val)
+		} else {
+			b, err = v.GetNil()
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, b...)
+	}
+	if hasChecksum(self.fileVersion) {
+		result = appendChecksum(result)
+	}
+	return result, nil
+}
+
+//decodeSlot verifies the checksum of the whole slot and converts it to row.
+func (self *TableStatic) decodeSlot(b []byte) (Row, error) {
+	if hasChecksum(self.fileVersion) {
+		err := verifyChecksum(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return self.decodeRow(b[1:])
+}
+
+//decodeRow converts the bytes of one slot without the status byte to row. NULL is nil.
+func (self *TableStatic) decodeRow(b []byte) (Row, error) {
+	result := make(Row)
+	bitmap := b[:self.nullBytes]
+	off := self.nullBytes
+	for i, v := range self.columnTypes {
+		size, err := v.GetBytes()
+		if err != nil {
+			return nil, err
+		}
+		if getNullBit(bitmap, i) {
+			result[v.Name] = nil
+			off += size
+			continue
+		}
+		result[v.Name], err = v.ConvertToVal(b[off : off+size])
+		if err != nil {
+			return nil, err
+		}
+		off += size
+	}
+	return result, nil
+}
+
+//slotBytes returns the size of one slot including the status byte, null bitmap and checksum.
+func (self *TableStatic) slotBytes() int64 {
+	if hasChecksum(self.fileVersion) {
+		return self.columnBytes + self.nullBytes + 1 + checksumBytes
+	}
+	return self.columnBytes + self.nullBytes + 1
+}
+
 func (self *TableStatic) convertRowNumToOffset(rowNum int64) int64 {
-	offset := int64(rowNum)*int64(self.columnBytes+1) + int64(binary.MaxVarintLen64)
+	offset := int64(rowNum)*self.slotBytes() + headerBytes(self.fileVersion)
 	return offset
 }
 func (self *TableStatic) convertOffsetToRowNum(offset int64) int64 {
-	rowNum := int64((offset - int64(binary.MaxVarintLen64)) / (int64(self.columnBytes + 1)))
+	rowNum := int64((offset - headerBytes(self.fileVersion)) / self.slotBytes())
 	return rowNum
 }
 
 func (self *TableStatic) searchLastRowNum() (int64, error) {
-	lastOff, err := self.tablefile.Seek(0, 2)
+	lastOff, err := self.tablefile.Size()
 	if err != nil {
 		return -1, err
 	}
@@ -335,5 +1221,93 @@ func (self TinyDatabaseError) Error() string {
 }*/
 
 func (self *TableStatic) GetColumns() []ColumnType {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
 	return self.columnTypes
 }
+
+func (self *TableStatic) columns() []ColumnType {
+	return self.columnTypes
+}
+
+func (self *TableStatic) lock() *sync.RWMutex {
+	return &self.mutex
+}
+
+//tableStaticIterator reads slots of TableStatic sequentially.
+type tableStaticIterator struct {
+	lock       *sync.RWMutex
+	table      *TableStatic
+	reader     *bufio.Reader
+	buf        []byte
+	rowNum     int64
+	lastRowNum int64
+	endOff     int64 //End of slots at Scan
+	generation int64 //generation of the table at Scan
+	changes    int64 //changes of table file when the buffer was filled
+	row        Row
+	err        error
+}
+
+func (self *tableStaticIterator) Next() bool {
+	if self.lock != nil {
+		self.lock.RLock()
+		defer self.lock.RUnlock()
+	}
+	self.row = nil
+	if self.err == nil && self.rowNum+1 < self.lastRowNum {
+		self.err = self.refresh()
+	}
+	for self.err == nil {
+		self.rowNum++
+		if self.rowNum >= self.lastRowNum {
+			return false
+		}
+		_, err := io.ReadFull(self.reader, self.buf)
+		if err != nil {
+			self.err = err
+			return false
+		}
+		if self.buf[0] == ROW_DELETED {
+			continue
+		}
+		self.row, self.err = self.table.decodeSlot(self.buf)
+		return self.err == nil
+	}
+	return false
+}
+
+/*
+ refresh checks the table before Next reads it. The lock must be held.
+ The buffer is dropped when table file was written after it was filled.
+*/
+func (self *tableStaticIterator) refresh() error {
+	if self.generation != self.table.generation {
+		return ErrIteratorInvalidated
+	}
+	if self.table.tablefile.changes != self.changes {
+		startOff := self.table.convertRowNumToOffset(self.rowNum + 1)
+		self.reader.Reset(io.NewSectionReader(self.table.tablefile, startOff, self.endOff-startOff))
+		self.changes = self.table.tablefile.changes
+	}
+	return nil
+}
+
+func (self *tableStaticIterator) Row() Row {
+	return self.row
+}
+
+func (self *tableStaticIterator) RowNum() int64 {
+	return self.rowNum
+}
+
+func (self *tableStaticIterator) Err() error {
+	return self.err
+}
+
+func (self *tableStaticIterator) Close() error {
+	self.reader = nil
+	self.row = nil
+	self.rowNum = self.lastRowNum
+	return nil
+}
@@ -1,6 +1,8 @@
 package tinydatabase
 
 import (
+	"encoding/binary"
+	"io/ioutil"
 	//"fmt"
 	"os"
 	//"path"
@@ -276,3 +278,484 @@ func Test2_TableStatic_errUsage(t *testing.T) {
 	tableInst.Close()
 
 }
+
+func Test3_TableStatic_updateRow(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: "int64", Size: 64},
+		{Name: "strline", Type: "string", Size: 16},
+	}
+
+	var tableInst TableInterface
+	tableInst = &TableStatic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+
+	testRow := Row{"intline": int64(1), "strline": "first"}
+	_, err = tableInst.WriteRow(testRow)
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	testRow = Row{"intline": int64(2), "strline": "second"}
+	_, err = tableInst.WriteRow(testRow)
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+
+	testRow = Row{"intline": int64(10), "strline": "updated"}
+	err = tableInst.UpdateRow(0, testRow)
+	if err != nil {
+		t.Errorf("Failed to update row at 0: %s", err)
+	}
+	testRow2, err := tableInst.ReadRow(0)
+	if err != nil {
+		t.Errorf("Failed to read row at 0: %s", err)
+	}
+	if testRow2["intline"] != int64(10) || testRow2["strline"] != "updated" {
+		t.Errorf("Failed to update row at 0: %v", testRow2)
+	}
+	testRow2, err = tableInst.ReadRow(1)
+	if err != nil {
+		t.Errorf("Failed to read row at 1: %s", err)
+	}
+	if testRow2["intline"] != int64(2) || testRow2["strline"] != "second" {
+		t.Errorf("Failed to keep row at 1: %v", testRow2)
+	}
+
+	testRow["strline"] = "This is over than 16 words"
+	err = tableInst.UpdateRow(0, testRow)
+	if err == nil {
+		t.Errorf("Failed to check string count")
+	}
+
+	err = tableInst.DeleteRow(1)
+	if err != nil {
+		t.Errorf("Failed to delete row at 1: %s", err)
+	}
+	err = tableInst.UpdateRow(1, Row{"intline": int64(3)})
+	if err == nil || err.Error() != "Deleted row" {
+		t.Errorf("Failed to refuse updating deleted row: %v", err)
+	}
+	err = tableInst.UpdateRow(2, Row{"intline": int64(3)})
+	if err == nil {
+		t.Errorf("Failed to raise error for invalid row")
+	}
+	err = tableInst.UpdateRow(-1, Row{"intline": int64(3)})
+	if err == nil {
+		t.Errorf("Failed to raise error for invalid row")
+	}
+
+	tableInst.Close()
+}
+
+func Test4_TableStatic_scan(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 16},
+	}
+
+	var tableInst TableInterface
+	tableInst = &TableStatic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+
+	it, err := tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan empty table: %s", err)
+	}
+	if it.Next() {
+		t.Errorf("Failed to scan empty table: row %d", it.RowNum())
+	}
+	it.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = tableInst.WriteRow(Row{"intline": int64(i), "strline": strings.Repeat("a", i+1)})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	err = tableInst.DeleteRow(1)
+	if err != nil {
+		t.Errorf("Failed to delete row at 1: %s", err)
+	}
+	err = tableInst.DeleteRow(4)
+	if err != nil {
+		t.Errorf("Failed to delete row at 4: %s", err)
+	}
+	_, err = tableInst.ReadRow(1)
+	if err != ErrRowDeleted {
+		t.Errorf("Failed to return ErrRowDeleted: %v", err)
+	}
+	_, err = tableInst.ReadRow(5)
+	if err != ErrOutOfRowIndex {
+		t.Errorf("Failed to return ErrOutOfRowIndex: %v", err)
+	}
+
+	it, err = tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan table: %s", err)
+	}
+	rowNums := []int64{}
+	for it.Next() {
+		row := it.Row()
+		if row["intline"] != it.RowNum() {
+			t.Errorf("Failed to read row at %d: %v", it.RowNum(), row)
+		}
+		if row["strline"] != strings.Repeat("a", int(it.RowNum())+1) {
+			t.Errorf("Failed to read row at %d: %v", it.RowNum(), row)
+		}
+		rowNums = append(rowNums, it.RowNum())
+	}
+	if it.Err() != nil {
+		t.Errorf("Failed to scan table: %s", it.Err())
+	}
+	err = it.Close()
+	if err != nil {
+		t.Errorf("Failed to close iterator: %s", err)
+	}
+	if len(rowNums) != 3 || rowNums[0] != 0 || rowNums[1] != 2 || rowNums[2] != 3 {
+		t.Errorf("Failed to skip deleted rows: %v", rowNums)
+	}
+
+	count, err := tableInst.CountRows()
+	if err != nil {
+		t.Errorf("Failed to count rows: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("Failed to count rows: %d", count)
+	}
+
+	//Rows written while iterating are read as they are when Next reaches them.
+	it, err = tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan table: %s", err)
+	}
+	if it.Next() == false || it.RowNum() != 0 {
+		t.Errorf("Failed to read first row: %d, %v", it.RowNum(), it.Err())
+	}
+	err = tableInst.UpdateRow(2, Row{"intline": int64(20), "strline": "updated"})
+	if err != nil {
+		t.Errorf("Failed to update row at 2: %s", err)
+	}
+	err = tableInst.DeleteRow(3)
+	if err != nil {
+		t.Errorf("Failed to delete row at 3: %s", err)
+	}
+	if it.Next() == false || it.RowNum() != 2 || it.Row()["intline"] != int64(20) || it.Row()["strline"] != "updated" {
+		t.Errorf("Failed to read updated row: %d, %v, %v", it.RowNum(), it.Row(), it.Err())
+	}
+	if it.Next() || it.Err() != nil {
+		t.Errorf("Failed to skip row deleted while iterating: %d, %v", it.RowNum(), it.Err())
+	}
+	it.Close()
+
+	it, err = tableInst.Scan()
+	if err != nil {
+		t.Errorf("Failed to scan table: %s", err)
+	}
+	it.Next()
+	tableInst.Close()
+	if it.Next() || it.Err() != ErrIteratorInvalidated {
+		t.Errorf("Failed to invalidate iterator: %v", it.Err())
+	}
+	it.Close()
+
+	tableInst.Close()
+}
+
+func Test5_TableStatic_slotReuse(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+	}
+
+	tableInst := &TableStatic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Errorf("Failed to create table: %s", err)
+	}
+	_, err = os.Stat(directory + tablename + ".free")
+	if err != nil {
+		t.Errorf("Failed to create free list file:%s", err)
+	}
+	if tableInst.GetSlotReuse() == false {
+		t.Errorf("Failed to enable slot reuse by default")
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = tableInst.WriteRow(Row{"intline": int64(i)})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	err = tableInst.DeleteRow(1)
+	if err != nil {
+		t.Errorf("Failed to delete row at 1: %s", err)
+	}
+	num, err := tableInst.WriteRow(Row{"intline": int64(10)})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 1 {
+		t.Errorf("Failed to reuse deleted slot 1: %d", num)
+	}
+	num, err = tableInst.WriteRow(Row{"intline": int64(11)})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 3 {
+		t.Errorf("Failed to append row at 3: %d", num)
+	}
+
+	//Free list is kept after reopening.
+	err = tableInst.DeleteRow(0)
+	if err != nil {
+		t.Errorf("Failed to delete row at 0: %s", err)
+	}
+	err = tableInst.DeleteRow(0)
+	if err != nil {
+		t.Errorf("Failed to delete row at 0 again: %s", err)
+	}
+	tableInst.Close()
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Errorf("Failed to open table: %s", err)
+	}
+	num, err = tableInst.WriteRow(Row{"intline": int64(12)})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 0 {
+		t.Errorf("Failed to reuse deleted slot 0: %d", num)
+	}
+	num, err = tableInst.WriteRow(Row{"intline": int64(13)})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 4 {
+		t.Errorf("Failed to append row at 4: %d", num)
+	}
+
+	//Disabled reuse keeps row numbers permanent.
+	err = tableInst.SetSlotReuse(false)
+	if err != nil {
+		t.Errorf("Failed to disable slot reuse: %s", err)
+	}
+	err = tableInst.DeleteRow(2)
+	if err != nil {
+		t.Errorf("Failed to delete row at 2: %s", err)
+	}
+	tableInst.Close()
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Errorf("Failed to open table: %s", err)
+	}
+	if tableInst.GetSlotReuse() == true {
+		t.Errorf("Failed to save slot reuse setting")
+	}
+	num, err = tableInst.WriteRow(Row{"intline": int64(14)})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 5 {
+		t.Errorf("Failed to append row at 5: %d", num)
+	}
+	_, err = tableInst.ReadRow(2)
+	if err != ErrRowDeleted {
+		t.Errorf("Failed to keep deleted row at 2: %v", err)
+	}
+
+	//Free list is rebuilt from table file when it is missing.
+	tableInst.Close()
+	os.Remove(directory + tablename + ".free")
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Errorf("Failed to open table: %s", err)
+	}
+	err = tableInst.SetSlotReuse(true)
+	if err != nil {
+		t.Errorf("Failed to enable slot reuse: %s", err)
+	}
+	num, err = tableInst.WriteRow(Row{"intline": int64(15)})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	if num != 2 {
+		t.Errorf("Failed to reuse deleted slot 2: %d", num)
+	}
+
+	tableInst.Close()
+}
+
+func Test6_TableStatic_nullable(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "nullint", Type: COLUMN_INT64, Size: 64, Nullable: true},
+		{Name: "nullstr", Type: COLUMN_STRING, Size: 8, Nullable: true},
+	}
+	tableInst := &TableStatic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	if tableInst.fileVersion != STATIC3 {
+		t.Errorf("Failed to create table with null bitmap: %d", tableInst.fileVersion)
+	}
+	_, err = tableInst.WriteRow(Row{"intline": int64(1), "nullint": int64(0), "nullstr": ""})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	_, err = tableInst.WriteRow(Row{"nullint": nil})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	tableInst.Close()
+
+	tableInst = &TableStatic{}
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Fatalf("Failed to open table: %s", err)
+	}
+	row, err := tableInst.ReadRow(0)
+	if err != nil || row["nullint"] != int64(0) || row["nullstr"] != "" {
+		t.Errorf("Failed to read zero values: %v, %v", row, err)
+	}
+	row, err = tableInst.ReadRow(1)
+	if err != nil || row["intline"] != int64(0) || row["nullint"] != nil || row["nullstr"] != nil {
+		t.Errorf("Failed to read NULL: %v, %v", row, err)
+	}
+	if _, ok := row["nullstr"]; ok == false {
+		t.Errorf("Failed to return NULL column in row: %v", row)
+	}
+	err = tableInst.UpdateRow(1, Row{"intline": int64(2), "nullstr": "abc"})
+	if err != nil {
+		t.Errorf("Failed to update row: %s", err)
+	}
+	row, err = tableInst.ReadRow(1)
+	if err != nil || row["nullint"] != nil || row["nullstr"] != "abc" {
+		t.Errorf("Failed to read updated row: %v, %v", row, err)
+	}
+	tableInst.Close()
+
+	//Tables of old fileversion have no null bitmap.
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+	tableInst = &TableStatic{}
+	err = tableInst.NewTable(directory, tablename, columnSet[:1])
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	tableInst.Close()
+	b := make([]byte, 10)
+	binary.PutVarint(b, STATIC1)
+	ioutil.WriteFile(directory+tablename+".table", b, 0666)
+	tableInst = &TableStatic{}
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Fatalf("Failed to open old table: %s", err)
+	}
+	_, err = tableInst.WriteRow(Row{"intline": int64(3)})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	info, _ := os.Stat(directory + tablename + ".table")
+	if info.Size() != 10+1+10 {
+		t.Errorf("Failed to write row of old fileversion: %d", info.Size())
+	}
+	row, err = tableInst.ReadRow(0)
+	if err != nil || row["intline"] != int64(3) {
+		t.Errorf("Failed to read row of old fileversion: %v, %v", row, err)
+	}
+	tableInst.Close()
+	saveTableConfig(directory+tablename+".config", &tableConfig{Columns: columnSet})
+	err = tableInst.Open(directory, tablename)
+	if err == nil {
+		t.Errorf("Failed to refuse nullable column in old fileversion")
+	}
+	tableInst.Close()
+}
+
+func Test7_TableStatic_checksum(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 8},
+	}
+	tableInst := &TableStatic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = tableInst.WriteRow(Row{"intline": int64(i), "strline": "abc"})
+		if err != nil {
+			t.Errorf("Failed to insert row: %s", err)
+		}
+	}
+	off := int(tableInst.convertRowNumToOffset(1)) + 3
+	tableInst.Close()
+
+	//A flipped bit in a row is reported instead of the row.
+	data, _ := ioutil.ReadFile(directory + tablename + ".table")
+	data[off] ^= 0x01
+	ioutil.WriteFile(directory+tablename+".table", data, 0666)
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Fatalf("Failed to open table: %s", err)
+	}
+	_, err = tableInst.ReadRow(1)
+	if err != ErrCorruptRow {
+		t.Errorf("Failed to detect corrupted row: %v", err)
+	}
+	row, err := tableInst.ReadRow(2)
+	if err != nil || row["intline"] != int64(2) {
+		t.Errorf("Failed to read row: %v, %v", row, err)
+	}
+	it, err := tableInst.Scan()
+	if err != nil {
+		t.Fatalf("Failed to scan: %s", err)
+	}
+	for it.Next() {
+	}
+	if it.Err() != ErrCorruptRow || it.RowNum() != 1 {
+		t.Errorf("Failed to detect corrupted row by scan: %d, %v", it.RowNum(), it.Err())
+	}
+	it.Close()
+	tableInst.Close()
+
+	//A flipped bit in the header is found on opening.
+	data[off] ^= 0x01
+	data[1] ^= 0x01
+	ioutil.WriteFile(directory+tablename+".table", data, 0666)
+	err = tableInst.Open(directory, tablename)
+	if err != ErrCorruptHeader {
+		t.Errorf("Failed to detect corrupted header: %v", err)
+	}
+	tableInst.Close()
+}
@@ -0,0 +1,260 @@
+package tinydatabase
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+/*
+ Tx is a transaction over tables of a Database.
+ Writes are staged until Commit and are written through the write-ahead log as one record,
+ so all of them or none of them survive a crash.
+ ReadRow in the transaction sees its own writes. Reads outside the transaction do not see them until Commit.
+ A Tx must not be used by goroutines at the same time.
+*/
+type Tx struct {
+	db     *Database
+	tables map[string]txTable
+	done   bool
+}
+
+//txTable is a table whose writes can be staged and committed together.
+type txTable interface {
+	TableInterface
+	readRow(rowNum int64) (Row, error)
+	writeRow(row Row) (int64, Row, error)
+	updateRow(rowNum int64, row Row) error
+	deleteRow(rowNum int64) error
+	dataFiles() []*dataFile
+	setTransaction(tx *Tx)
+	getTransaction() *Tx
+	getDurability() string
+}
+
+var (
+	ErrTxDone    = errors.New("Transaction has already been committed or rolled back")
+	ErrTableInTx = errors.New("Table is used by a transaction")
+)
+
+//Begin starts a transaction.
+func (self *Database) Begin() (*Tx, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if self.readOnly {
+		return nil, ErrReadOnly
+	}
+	if self.wal == nil {
+		return nil, ErrDatabaseNotExist
+	}
+	result := &Tx{}
+	result.db = self
+	result.tables = map[string]txTable{}
+	return result, nil
+}
+
+//WriteRow stages a new row and returns its row number.
+func (self *Tx) WriteRow(tablename string, row Row) (int64, error) {
+	rowNum, _, err := self.WriteRowReturning(tablename, row)
+	return rowNum, err
+}
+
+/*
+ WriteRowReturning stages a new row and returns its row number and the row to be written,
+ which has the assigned identity and default values.
+*/
+func (self *Tx) WriteRowReturning(tablename string, row Row) (int64, Row, error) {
+	table, err := self.table(tablename)
+	if err != nil {
+		return -1, nil, err
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	showStaged(table, true)
+	defer showStaged(table, false)
+	marks := savepoints(table)
+	rowNum, written, err := table.writeRow(row)
+	if err != nil {
+		rollbackToSavepoints(table, marks)
+		return -1, nil, err
+	}
+	return rowNum, written, nil
+}
+
+//UpdateRow stages overwriting the row at rowNum.
+func (self *Tx) UpdateRow(tablename string, rowNum int64, row Row) error {
+	table, err := self.table(tablename)
+	if err != nil {
+		return err
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	showStaged(table, true)
+	defer showStaged(table, false)
+	marks := savepoints(table)
+	err = table.updateRow(rowNum, row)
+	if err != nil {
+		rollbackToSavepoints(table, marks)
+		return err
+	}
+	return nil
+}
+
+//DeleteRow stages deleting the row at rowNum.
+func (self *Tx) DeleteRow(tablename string, rowNum int64) error {
+	table, err := self.table(tablename)
+	if err != nil {
+		return err
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	showStaged(table, true)
+	defer showStaged(table, false)
+	marks := savepoints(table)
+	err = table.deleteRow(rowNum)
+	if err != nil {
+		rollbackToSavepoints(table, marks)
+		return err
+	}
+	return nil
+}
+
+//ReadRow reads the row at rowNum including writes of the transaction.
+func (self *Tx) ReadRow(tablename string, rowNum int64) (Row, error) {
+	table, err := self.table(tablename)
+	if err != nil {
+		return nil, err
+	}
+	lock := tableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	showStaged(table, true)
+	defer showStaged(table, false)
+	return table.readRow(rowNum)
+}
+
+//Commit writes all staged writes at once.
+func (self *Tx) Commit() error {
+	if self.done {
+		return ErrTxDone
+	}
+	unlock := self.lockTables()
+	files := []*dataFile{}
+	//The transaction is synced as the table of the strongest durability.
+	durability := DURABILITY_PERIODIC
+	for _, table := range self.tables {
+		files = append(files, table.dataFiles()...)
+		durability = strongerDurability(durability, table.getDurability())
+	}
+	seq, err := commitFilesWith(self.db.wal, durability, files...)
+	self.release()
+	unlock()
+	if err != nil {
+		return err
+	}
+	return self.db.wal.waitCommit(seq)
+}
+
+//Rollback drops all staged writes.
+func (self *Tx) Rollback() error {
+	if self.done {
+		return ErrTxDone
+	}
+	unlock := self.lockTables()
+	defer unlock()
+	for _, table := range self.tables {
+		rollbackFiles(table.dataFiles()...)
+	}
+	self.release()
+	return nil
+}
+
+//table returns the table and makes it belong to the transaction.
+func (self *Tx) table(tablename string) (txTable, error) {
+	if self.done {
+		return nil, ErrTxDone
+	}
+	result, ok := self.tables[tablename]
+	if ok == true {
+		return result, nil
+	}
+	tableI, err := self.db.GetTable(tablename)
+	if err != nil {
+		return nil, err
+	}
+	result, ok = tableI.(txTable)
+	if ok == false {
+		return nil, ErrInvalidTabletype
+	}
+	lock := tableLock(result)
+	lock.Lock()
+	defer lock.Unlock()
+	if result.getTransaction() != nil {
+		return nil, ErrTableInTx
+	}
+	result.setTransaction(self)
+	self.tables[tablename] = result
+	return result, nil
+}
+
+/*
+ lockTables locks all tables of the transaction and returns the function which unlocks them.
+ Tables are locked in order of names, so transactions do not wait for each other forever.
+*/
+func (self *Tx) lockTables() func() {
+	names := []string{}
+	for name := range self.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	locks := []*sync.RWMutex{}
+	for _, name := range names {
+		lock := tableLock(self.tables[name])
+		lock.Lock()
+		locks = append(locks, lock)
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+//release makes tables leave the transaction. Tables must be locked.
+func (self *Tx) release() {
+	for _, table := range self.tables {
+		table.setTransaction(nil)
+		showStaged(table, true)
+	}
+	self.tables = nil
+	self.done = true
+}
+
+//showStaged makes reads of the table see staged writes of the transaction or not. The table must be locked.
+func showStaged(table txTable, show bool) {
+	for _, f := range table.dataFiles() {
+		if f != nil && f.hidden == show {
+			f.hidden = !show
+			f.changes++
+		}
+	}
+}
+
+//savepoints returns marks of staged writes of the table.
+func savepoints(table txTable) []stagedMark {
+	result := []stagedMark{}
+	for _, f := range table.dataFiles() {
+		result = append(result, f.savepoint())
+	}
+	return result
+}
+
+//rollbackToSavepoints drops writes of a failed operation in a transaction.
+func rollbackToSavepoints(table txTable, marks []stagedMark) {
+	for i, f := range table.dataFiles() {
+		f.rollbackTo(marks[i])
+	}
+}
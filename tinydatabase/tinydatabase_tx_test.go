@@ -0,0 +1,222 @@
+package tinydatabase
+
+import (
+	"os"
+	"testing"
+)
+
+func Test1_Tx_basicUsage(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	orders, err := db.NewTable("orders", "static", []ColumnType{
+		{Name: "id", Type: COLUMN_INT64, Size: 64},
+		{Name: "customer", Type: COLUMN_STRING, Size: 32},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	items, err := db.NewTable("items", "dynamic", []ColumnType{
+		{Name: "order", Type: COLUMN_INT64, Size: 64},
+		{Name: "name", Type: COLUMN_STRING, Size: 0},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %s", err)
+	}
+	orderNum, err := tx.WriteRow("orders", Row{"id": int64(1), "customer": "alice"})
+	if err != nil || orderNum != 0 {
+		t.Errorf("Failed to write row in transaction: %d, %v", orderNum, err)
+	}
+	for i, name := range []string{"apple", "banana", "cherry"} {
+		num, err := tx.WriteRow("items", Row{"order": int64(1), "name": name})
+		if err != nil || num != int64(i) {
+			t.Errorf("Failed to write row in transaction: %d, %v", num, err)
+		}
+	}
+	err = tx.UpdateRow("items", 1, Row{"order": int64(1), "name": "blueberry and banana"})
+	if err != nil {
+		t.Errorf("Failed to update row in transaction: %s", err)
+	}
+	err = tx.DeleteRow("items", 2)
+	if err != nil {
+		t.Errorf("Failed to delete row in transaction: %s", err)
+	}
+	row, err := tx.ReadRow("items", 1)
+	if err != nil || row["name"] != "blueberry and banana" {
+		t.Errorf("Failed to read own write: %v, %v", row, err)
+	}
+	_, err = tx.ReadRow("items", 2)
+	if err != ErrRowDeleted {
+		t.Errorf("Failed to read own delete: %v", err)
+	}
+	_, err = items.WriteRow(Row{"order": int64(2), "name": "outside"})
+	if err != ErrTableInTx {
+		t.Errorf("Failed to refuse write outside transaction: %v", err)
+	}
+	tx2, _ := db.Begin()
+	_, err = tx2.WriteRow("orders", Row{"id": int64(2)})
+	if err != ErrTableInTx {
+		t.Errorf("Failed to refuse second transaction: %v", err)
+	}
+	tx2.Rollback()
+	_, err = tx.WriteRow("notable", Row{})
+	if err != ErrTableNotExist {
+		t.Errorf("Failed to check table name: %v", err)
+	}
+	_, err = tx.WriteRow("orders", Row{"id": "not int"})
+	if err == nil {
+		t.Errorf("Failed to check invalid data")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Errorf("Failed to commit: %s", err)
+	}
+	err = tx.Commit()
+	if err != ErrTxDone {
+		t.Errorf("Failed to refuse second commit: %v", err)
+	}
+	_, err = tx.WriteRow("orders", Row{"id": int64(2)})
+	if err != ErrTxDone {
+		t.Errorf("Failed to refuse write after commit: %v", err)
+	}
+	count, _ := orders.CountRows()
+	if count != 1 {
+		t.Errorf("Failed to commit orders: %d", count)
+	}
+	count, _ = items.CountRows()
+	if count != 2 {
+		t.Errorf("Failed to commit items: %d", count)
+	}
+
+	//Rollback drops all writes.
+	tx, _ = db.Begin()
+	_, err = tx.WriteRow("orders", Row{"id": int64(2), "customer": "bob"})
+	if err != nil {
+		t.Errorf("Failed to write row in transaction: %s", err)
+	}
+	_, err = tx.WriteRow("items", Row{"order": int64(2), "name": "durian"})
+	if err != nil {
+		t.Errorf("Failed to write row in transaction: %s", err)
+	}
+	err = tx.DeleteRow("orders", 0)
+	if err != nil {
+		t.Errorf("Failed to delete row in transaction: %s", err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Errorf("Failed to roll back: %s", err)
+	}
+	row, err = orders.ReadRow(0)
+	if err != nil || row["customer"] != "alice" {
+		t.Errorf("Failed to roll back delete: %v, %v", row, err)
+	}
+	num, err := orders.WriteRow(Row{"id": int64(3), "customer": "carol"})
+	if err != nil || num != 1 {
+		t.Errorf("Failed to write row after rollback: %d, %v", num, err)
+	}
+	num, err = items.WriteRow(Row{"order": int64(3), "name": "elderberry"})
+	if err != nil || num != 3 {
+		t.Errorf("Failed to write row after rollback: %d, %v", num, err)
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, _ = dbList.Get("database1")
+	items, _ = db.GetTable("items")
+	row, err = items.ReadRow(1)
+	if err != nil || row["name"] != "blueberry and banana" {
+		t.Errorf("Failed to keep committed row: %v, %v", row, err)
+	}
+	dbList.Close()
+}
+
+func Test2_Tx_isolation(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	defer dbList.Close()
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{{Name: "id", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		err = db.CreateIndex(tabletype, []string{"id"}, false)
+		if err != nil {
+			t.Fatalf("Failed to create index: %s", err)
+		}
+		index, _ := db.GetIndex(tabletype, []string{"id"})
+
+		for _, commit := range []bool{false, true} {
+			tx, _ := db.Begin()
+			rowNum, err := tx.WriteRow(tabletype, Row{"id": int64(7)})
+			if err != nil {
+				t.Fatalf("Failed to stage row: %s", err)
+			}
+			row, err := tx.ReadRow(tabletype, rowNum)
+			if err != nil || row["id"] != int64(7) {
+				t.Errorf("Failed to read own write in transaction: %v, %v", row, err)
+			}
+
+			//Reads outside the transaction do not see its writes.
+			_, err = table.ReadRow(rowNum)
+			if err != ErrOutOfRowIndex {
+				t.Errorf("Failed to hide staged row of %s: %v", tabletype, err)
+			}
+			count, err := table.CountRows()
+			if err != nil || count != 0 {
+				t.Errorf("Failed to hide staged row from count of %s: %d, %v", tabletype, count, err)
+			}
+			found, err := index.Lookup(int64(7))
+			if err != nil || len(found) != 0 {
+				t.Errorf("Failed to hide staged index entry of %s: %v, %v", tabletype, found, err)
+			}
+
+			if commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				t.Fatalf("Failed to finish transaction: %s", err)
+			}
+			count, err = table.CountRows()
+			if commit && (err != nil || count != 1) {
+				t.Errorf("Failed to read committed row of %s: %d, %v", tabletype, count, err)
+			}
+			if commit == false && (err != nil || count != 0) {
+				t.Errorf("Failed to drop rolled back row of %s: %d, %v", tabletype, count, err)
+			}
+		}
+		rowNum, err := table.WriteRow(Row{"id": int64(8)})
+		if err != nil || rowNum != 1 {
+			t.Errorf("Failed to write row after transactions: %d, %v", rowNum, err)
+		}
+	}
+}
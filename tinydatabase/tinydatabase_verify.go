@@ -0,0 +1,59 @@
+package tinydatabase
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+ Problem is an inconsistency of table files found by Verify.
+ RowNum is the row of the problem, or -1 when the problem is not of a row.
+ Repaired is true when Verify with repair has fixed it.
+*/
+type Problem struct {
+	RowNum   int64
+	Detail   string
+	Repaired bool
+}
+
+func (self Problem) String() string {
+	result := self.Detail
+	if self.RowNum >= 0 {
+		result = fmt.Sprintf("row %d: %s", self.RowNum, result)
+	}
+	if self.Repaired {
+		result += " (repaired)"
+	}
+	return result
+}
+
+/*
+ Check verifies all tables of the database and returns problems of each table.
+ Tables without problems are not included.
+ When repair is true, torn tails of files are truncated and the last table offset is rebuilt.
+*/
+func (self *Database) Check(repair bool) (map[string][]Problem, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	names := []string{}
+	for name := range self.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := map[string][]Problem{}
+	for _, name := range names {
+		problems, err := self.tables[name].Verify(repair)
+		if err != nil {
+			return result, err
+		}
+		if len(problems) > 0 {
+			result[name] = problems
+		}
+	}
+	return result, nil
+}
+
+//newProblem returns a problem of rowNum.
+func newProblem(rowNum int64, format string, a ...interface{}) Problem {
+	return Problem{RowNum: rowNum, Detail: fmt.Sprintf(format, a...)}
+}
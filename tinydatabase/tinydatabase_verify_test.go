@@ -0,0 +1,193 @@
+package tinydatabase
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test1_Verify_tornTail(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 8},
+	}
+	for _, tableInst := range []TableInterface{&TableStatic{}, &TableDynamic{}} {
+		os.RemoveAll(directory)
+		os.Mkdir(directory, 0777)
+		err := tableInst.NewTable(directory, tablename, columnSet)
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		for i := 0; i < 3; i++ {
+			tableInst.WriteRow(Row{"intline": int64(i), "strline": "abc"})
+		}
+		problems, err := tableInst.Verify(false)
+		if err != nil || len(problems) != 0 {
+			t.Errorf("Failed to verify sound table: %v, %v", problems, err)
+		}
+		tableInst.Close()
+
+		//Bytes of a row which was not written completely are left at the end.
+		f, _ := os.OpenFile(directory+tablename+".table", os.O_WRONLY+os.O_APPEND, 0666)
+		f.Write([]byte{ROW_NORMAL, 0, 1})
+		f.Close()
+		err = tableInst.Open(directory, tablename)
+		if err != nil {
+			t.Fatalf("Failed to open table: %s", err)
+		}
+		problems, err = tableInst.Verify(false)
+		if err != nil || len(problems) != 1 || problems[0].Repaired {
+			t.Errorf("Failed to find torn tail of %s: %v, %v", tableInst.GetTableType(), problems, err)
+		}
+		problems, err = tableInst.Verify(true)
+		if err != nil || len(problems) != 1 || problems[0].Repaired == false {
+			t.Errorf("Failed to repair torn tail of %s: %v, %v", tableInst.GetTableType(), problems, err)
+		}
+		problems, err = tableInst.Verify(false)
+		if err != nil || len(problems) != 0 {
+			t.Errorf("Failed to verify repaired table: %v, %v", problems, err)
+		}
+		rowNum, err := tableInst.WriteRow(Row{"intline": int64(3), "strline": "abc"})
+		if err != nil || rowNum != 3 {
+			t.Errorf("Failed to write row after repair: %d, %v", rowNum, err)
+		}
+		tableInst.Close()
+	}
+}
+
+func Test2_Verify_dynamicRepair(t *testing.T) {
+	directory := "./testdata/"
+	tablename := "test"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 0},
+	}
+	tableInst := &TableDynamic{}
+	err := tableInst.NewTable(directory, tablename, columnSet)
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		tableInst.WriteRow(Row{"intline": int64(i), "strline": "abcdefgh"})
+	}
+	tableOff, _, _ := tableInst.readIndexEntry(2)
+	tableInst.Close()
+
+	//The last row is cut in the middle, so its index entry points beyond the table file.
+	os.Truncate(directory+tablename+".table", tableOff+4)
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Fatalf("Failed to open table: %s", err)
+	}
+	problems, err := tableInst.Verify(false)
+	if err != nil || len(problems) != 2 || problems[0].RowNum != 2 {
+		t.Errorf("Failed to find torn row: %v, %v", problems, err)
+	}
+	problems, err = tableInst.Verify(true)
+	if err != nil || len(problems) != 3 {
+		t.Errorf("Failed to repair torn row: %v, %v", problems, err)
+	}
+	for _, v := range problems {
+		if v.Repaired == false {
+			t.Errorf("Failed to repair: %v", v)
+		}
+	}
+	count, err := tableInst.CountRows()
+	if err != nil || count != 2 {
+		t.Errorf("Failed to drop torn row: %d, %v", count, err)
+	}
+	rowNum, err := tableInst.WriteRow(Row{"intline": int64(5), "strline": "xyz"})
+	if err != nil || rowNum != 2 {
+		t.Errorf("Failed to write row after repair: %d, %v", rowNum, err)
+	}
+	row, err := tableInst.ReadRow(2)
+	if err != nil || row["strline"] != "xyz" {
+		t.Errorf("Failed to read row after repair: %v, %v", row, err)
+	}
+	problems, err = tableInst.Verify(false)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Failed to verify repaired table: %v, %v", problems, err)
+	}
+
+	//A corrupted row in the middle is reported but kept.
+	tableOff, _, _ = tableInst.readIndexEntry(1)
+	tableInst.Close()
+	data, _ := ioutil.ReadFile(directory + tablename + ".table")
+	data[tableOff+3] ^= 0x01
+	ioutil.WriteFile(directory+tablename+".table", data, 0666)
+	err = tableInst.Open(directory, tablename)
+	if err != nil {
+		t.Fatalf("Failed to open table: %s", err)
+	}
+	defer tableInst.Close()
+	problems, err = tableInst.Verify(true)
+	if err != nil || len(problems) != 1 || problems[0].RowNum != 1 || problems[0].Repaired {
+		t.Errorf("Failed to report corrupted row: %v, %v", problems, err)
+	}
+	_, err = tableInst.ReadRow(1)
+	if err != ErrCorruptRow {
+		t.Errorf("Failed to keep corrupted row: %v", err)
+	}
+}
+
+func Test3_Verify_check(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, tabletype := range []string{"static", "dynamic"} {
+		table, err := db.NewTable(tabletype, tabletype, []ColumnType{{Name: "a", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+		table.WriteRow(Row{"a": int64(1)})
+	}
+	dbList.Close()
+
+	f, _ := os.OpenFile(directoryJson+"database1/dynamic.table", os.O_WRONLY+os.O_APPEND, 0666)
+	f.Write([]byte{0, 0})
+	f.Close()
+
+	dbList, err = LoadDatabaseListReadOnly(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list read-only:%s", err)
+	}
+	db, _ = dbList.Get("database1")
+	result, err := db.Check(false)
+	if err != nil || len(result) != 1 || len(result["dynamic"]) != 1 {
+		t.Errorf("Failed to check database: %v, %v", result, err)
+	}
+	_, err = db.Check(true)
+	if err != ErrReadOnly {
+		t.Errorf("Failed to refuse repair of read-only database: %v", err)
+	}
+	dbList.Close()
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	defer dbList.Close()
+	db, _ = dbList.Get("database1")
+	result, err = db.Check(true)
+	if err != nil || len(result["dynamic"]) != 1 || result["dynamic"][0].Repaired == false {
+		t.Errorf("Failed to repair database: %v, %v", result, err)
+	}
+	result, err = db.Check(false)
+	if err != nil || len(result) != 0 {
+		t.Errorf("Failed to check repaired database: %v, %v", result, err)
+	}
+}
@@ -0,0 +1,665 @@
+package tinydatabase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+/*
+ dataFile is a file of table.
+ Writes are staged in memory until commit, and reads see the staged writes unless they are hidden.
+ Writes of a transaction are hidden while the transaction does not use the file.
+ Commits which are not synced on the log are held in memory, and reads always see them.
+*/
+type dataFile struct {
+	file      *os.File
+	name      string
+	mutex     sync.RWMutex  //Guards held writes and the file, because the periodic sync writes them without the lock of table
+	held      []stagedWrite //Committed writes which are written on file after the log is synced
+	heldEnd   int64
+	writes    []stagedWrite
+	stagedEnd int64
+	hidden    bool
+	changes   int64 //Counts changes of the content seen by ReadAt. Iterators drop their buffers when it changes
+}
+
+type stagedWrite struct {
+	offset int64
+	data   []byte
+}
+
+//stagedMark is a savepoint of staged writes. The last write may be extended after the mark, so its size is kept.
+type stagedMark struct {
+	writes int
+	size   int
+}
+
+/*
+ writeAheadLog records writes of tables before they touch table files.
+ The log is shared by tables of a database, so one commit uses it at a time.
+ The file is created by the first record, so opening a database does not write its directory.
+*/
+type writeAheadLog struct {
+	file      *os.File //nil until the first record when the file does not exist
+	directory string
+	closed    bool
+	mutex     sync.Mutex
+	cond      *sync.Cond         //Broadcast when the log is synced
+	appended  int64              //Number of records written on the log
+	synced    int64              //Number of records synced on the log
+	syncing   bool               //Whether a group commit is collecting records to sync
+	waiting   int                //Number of group commits waiting for the sync
+	size      int64              //Bytes of records since the log was emptied
+	dirty     map[*dataFile]bool //Files which have held writes or writes which are not synced
+	window    time.Duration      //Group commit window of the database
+	done      chan bool          //Closed to stop the periodic sync
+}
+
+var (
+	ErrInvalidLog = errors.New("Write-ahead log is broken")
+)
+
+//walFilename is a file name of write-ahead log in database directory.
+const walFilename = "database.wal"
+
+//walHeaderSize is the size of record length and checksum.
+const walHeaderSize = 12
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//newDataFile wraps opened file. The name is used to find the file at replay.
+func newDataFile(f *os.File) *dataFile {
+	result := &dataFile{}
+	result.file = f
+	result.name = path.Base(f.Name())
+	result.heldEnd = -1
+	result.stagedEnd = -1
+	return result
+}
+
+//ReadAt reads file with held writes and staged writes.
+func (self *dataFile) ReadAt(b []byte, off int64) (int, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if len(self.held) == 0 && (len(self.writes) == 0 || self.hidden) {
+		return self.file.ReadAt(b, off)
+	}
+	size, err := self.size()
+	if err != nil {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	n := len(b)
+	if off+int64(n) > size {
+		n = int(size - off)
+	}
+	num, err := self.file.ReadAt(b[:n], off)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	for i := num; i < n; i++ {
+		b[i] = 0
+	}
+	overlayWrites(b[:n], off, self.held)
+	if self.hidden == false {
+		overlayWrites(b[:n], off, self.writes)
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+//overlayWrites copies the parts of writes which are in b read at off.
+func overlayWrites(b []byte, off int64, writes []stagedWrite) {
+	for _, w := range writes {
+		start := w.offset
+		if start < off {
+			start = off
+		}
+		end := w.offset + int64(len(w.data))
+		if end > off+int64(len(b)) {
+			end = off + int64(len(b))
+		}
+		if start >= end {
+			continue
+		}
+		copy(b[start-off:end-off], w.data[start-w.offset:end-w.offset])
+	}
+}
+
+/*
+ WriteAt stages a write. It is written on file by commitFiles.
+ A write which continues the last one is merged into it, so rows appended in order do not make ReadAt slow.
+*/
+func (self *dataFile) WriteAt(b []byte, off int64) (int, error) {
+	n := len(self.writes)
+	if n > 0 && self.writes[n-1].offset+int64(len(self.writes[n-1].data)) == off {
+		self.writes[n-1].data = append(self.writes[n-1].data, b...)
+	} else {
+		data := make([]byte, len(b))
+		copy(data, b)
+		self.writes = append(self.writes, stagedWrite{offset: off, data: data})
+	}
+	if off+int64(len(b)) > self.stagedEnd {
+		self.stagedEnd = off + int64(len(b))
+	}
+	self.changes++
+	return len(b), nil
+}
+
+//Size returns file size including held writes and staged writes.
+func (self *dataFile) Size() (int64, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.size()
+}
+
+//size is Size while the mutex is held.
+func (self *dataFile) size() (int64, error) {
+	info, err := self.file.Stat()
+	if err != nil {
+		return -1, err
+	}
+	size := info.Size()
+	if self.heldEnd > size {
+		size = self.heldEnd
+	}
+	if self.stagedEnd > size && self.hidden == false {
+		size = self.stagedEnd
+	}
+	return size, nil
+}
+
+//Staged returns whether the file has writes which are not committed.
+func (self *dataFile) Staged() bool {
+	return len(self.writes) > 0
+}
+
+//apply writes held writes and staged writes on file. The log must be synced before.
+func (self *dataFile) apply() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	err := self.writeHeld()
+	if err != nil {
+		return err
+	}
+	for _, w := range self.writes {
+		_, err := self.file.WriteAt(w.data, w.offset)
+		if err != nil {
+			return err
+		}
+	}
+	self.discard()
+	return nil
+}
+
+//hold moves staged writes to held writes. They are written on file at the next checkpoint.
+func (self *dataFile) hold() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for _, w := range self.writes {
+		n := len(self.held)
+		if n > 0 && self.held[n-1].offset+int64(len(self.held[n-1].data)) == w.offset {
+			self.held[n-1].data = append(self.held[n-1].data, w.data...)
+		} else {
+			self.held = append(self.held, w)
+		}
+		if w.offset+int64(len(w.data)) > self.heldEnd {
+			self.heldEnd = w.offset + int64(len(w.data))
+		}
+	}
+	self.discard()
+}
+
+//heldWrites returns the number of held writes.
+func (self *dataFile) heldWrites() int {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return len(self.held)
+}
+
+//syncHeld writes held writes on file and syncs it. The log must be synced before.
+func (self *dataFile) syncHeld() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	err := self.writeHeld()
+	if err != nil {
+		return err
+	}
+	return self.file.Sync()
+}
+
+//writeHeld writes held writes on file. The mutex must be held.
+func (self *dataFile) writeHeld() error {
+	for _, w := range self.held {
+		_, err := self.file.WriteAt(w.data, w.offset)
+		if err != nil {
+			return err
+		}
+	}
+	self.held = nil
+	self.heldEnd = -1
+	return nil
+}
+
+//discard drops staged writes.
+func (self *dataFile) discard() {
+	self.writes = nil
+	self.stagedEnd = -1
+	self.changes++
+}
+
+//coalesce merges each staged write into the previous one when it continues it, so rows appended in order are written at once.
+func (self *dataFile) coalesce() {
+	result := []stagedWrite{}
+	for _, w := range self.writes {
+		n := len(result)
+		if n > 0 && result[n-1].offset+int64(len(result[n-1].data)) == w.offset {
+			result[n-1].data = append(result[n-1].data, w.data...)
+			continue
+		}
+		result = append(result, w)
+	}
+	self.writes = result
+}
+
+//savepoint returns a mark to drop later writes by rollbackTo.
+func (self *dataFile) savepoint() stagedMark {
+	mark := stagedMark{writes: len(self.writes)}
+	if mark.writes > 0 {
+		mark.size = len(self.writes[mark.writes-1].data)
+	}
+	return mark
+}
+
+//rollbackTo drops writes staged after the savepoint.
+func (self *dataFile) rollbackTo(mark stagedMark) {
+	if mark.writes > len(self.writes) {
+		return
+	}
+	if mark.writes == len(self.writes) && (mark.writes == 0 || len(self.writes[mark.writes-1].data) == mark.size) {
+		return
+	}
+	self.writes = self.writes[:mark.writes]
+	self.changes++
+	if mark.writes > 0 {
+		self.writes[mark.writes-1].data = self.writes[mark.writes-1].data[:mark.size]
+	}
+	self.stagedEnd = -1
+	for _, w := range self.writes {
+		if w.offset+int64(len(w.data)) > self.stagedEnd {
+			self.stagedEnd = w.offset + int64(len(w.data))
+		}
+	}
+}
+
+func (self *dataFile) Sync() error {
+	return self.file.Sync()
+}
+
+//Truncate cuts the file at size and syncs it. The file must not have staged writes. Held writes are cut too.
+func (self *dataFile) Truncate(size int64) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	held := []stagedWrite{}
+	self.heldEnd = -1
+	for _, w := range self.held {
+		if w.offset >= size {
+			continue
+		}
+		if w.offset+int64(len(w.data)) > size {
+			w.data = w.data[:size-w.offset]
+		}
+		held = append(held, w)
+		if w.offset+int64(len(w.data)) > self.heldEnd {
+			self.heldEnd = w.offset + int64(len(w.data))
+		}
+	}
+	self.held = held
+	self.changes++
+	err := self.file.Truncate(size)
+	if err != nil {
+		return err
+	}
+	return self.file.Sync()
+}
+
+func (self *dataFile) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.discard()
+	self.held = nil
+	self.heldEnd = -1
+	return self.file.Close()
+}
+
+/*
+ commitFiles writes staged writes of files.
+ With write-ahead log, the writes are logged and synced before files are touched.
+ The log keeps the record, so files are synced by the checkpoint when the log is full, by Flush or by Close.
+ Without the log, files are synced on each commit.
+*/
+func commitFiles(wal *writeAheadLog, files ...*dataFile) error {
+	targets := stagedFiles(files)
+	if len(targets) == 0 {
+		return nil
+	}
+	if wal != nil {
+		//Checkpoint truncates the log, so other commits wait until the files are written.
+		wal.mutex.Lock()
+		defer wal.mutex.Unlock()
+		err := wal.append(targets)
+		if err != nil {
+			rollbackFiles(targets...)
+			return err
+		}
+	}
+	for _, f := range targets {
+		err := f.apply()
+		if err != nil {
+			rollbackFiles(targets...)
+			return err
+		}
+	}
+	if wal != nil {
+		for _, f := range targets {
+			wal.dirty[f] = true
+		}
+		if wal.size >= maxWalSize {
+			return wal.checkpoint()
+		}
+		return nil
+	}
+	for _, f := range targets {
+		err := f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//stagedFiles returns files which have staged writes. Their writes are coalesced.
+func stagedFiles(files []*dataFile) []*dataFile {
+	result := []*dataFile{}
+	for _, f := range files {
+		if f != nil && f.Staged() {
+			f.coalesce()
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+//rollbackFiles drops staged writes of files.
+func rollbackFiles(files ...*dataFile) {
+	for _, f := range files {
+		if f != nil {
+			f.discard()
+		}
+	}
+}
+
+//checkWriteAheadLog returns ErrRecoveryNeeded when the log of database directory has writes to replay.
+func checkWriteAheadLog(directory string) error {
+	info, err := os.Stat(directory + "/" + walFilename)
+	if err == nil && info.Size() > 0 {
+		return ErrRecoveryNeeded
+	}
+	return nil
+}
+
+/*
+ openWriteAheadLog opens the log of database directory with the durability settings of the database.
+ Writes which are logged but may not be written on table files are replayed.
+*/
+func openWriteAheadLog(directory string, window time.Duration, interval time.Duration) (*writeAheadLog, error) {
+	f, err := os.OpenFile(directory+"/"+walFilename, os.O_RDWR, 0666)
+	if err != nil && os.IsNotExist(err) == false {
+		return nil, err
+	}
+	result := &writeAheadLog{}
+	result.directory = directory
+	result.cond = sync.NewCond(&result.mutex)
+	result.dirty = map[*dataFile]bool{}
+	result.window = window
+	result.done = make(chan bool)
+	if f != nil {
+		result.file = f
+		err = result.replay()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	go result.syncPeriodically(interval, result.done)
+	return result, nil
+}
+
+//append writes one record which has all staged writes of files and syncs the log.
+func (self *writeAheadLog) append(files []*dataFile) error {
+	err := self.write(files)
+	if err != nil {
+		return err
+	}
+	err = self.file.Sync()
+	if err != nil {
+		return err
+	}
+	self.synced = self.appended
+	return nil
+}
+
+/*
+ write writes one record which has all staged writes of files without syncing the log.
+ Record: length(8 bytes), CRC32C(4 bytes), payload.
+ Payload: number of writes, and name, offset and data of each write.
+*/
+func (self *writeAheadLog) write(files []*dataFile) error {
+	payload := []byte{}
+	b := make([]byte, binary.MaxVarintLen64)
+	count := 0
+	for _, f := range files {
+		count += len(f.writes)
+	}
+	payload = append(payload, b[:binary.PutVarint(b, int64(count))]...)
+	for _, f := range files {
+		for _, w := range f.writes {
+			payload = append(payload, b[:binary.PutVarint(b, int64(len(f.name)))]...)
+			payload = append(payload, f.name...)
+			payload = append(payload, b[:binary.PutVarint(b, w.offset)]...)
+			payload = append(payload, b[:binary.PutVarint(b, int64(len(w.data)))]...)
+			payload = append(payload, w.data...)
+		}
+	}
+	record := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint64(record, uint64(len(payload)))
+	binary.LittleEndian.PutUint32(record[8:], crc32.Checksum(payload, crcTable))
+	record = append(record, payload...)
+
+	if self.closed {
+		return os.ErrClosed
+	}
+	if self.file == nil {
+		f, err := os.OpenFile(self.directory+"/"+walFilename, os.O_RDWR+os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		self.file = f
+		err = syncDir(self.directory)
+		if err != nil {
+			return err
+		}
+	}
+	off, err := self.file.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+	_, err = self.file.WriteAt(record, off)
+	if err != nil {
+		return err
+	}
+	self.appended++
+	self.size = off + int64(len(record))
+	return nil
+}
+
+/*
+ checkpoint syncs the log, writes held writes on files, syncs them and empties the log.
+ Held writes are written after the log is synced, so a crash never leaves table files ahead of the log.
+*/
+func (self *writeAheadLog) checkpoint() error {
+	if self.synced < self.appended {
+		err := self.file.Sync()
+		if err != nil {
+			return err
+		}
+		self.synced = self.appended
+		self.cond.Broadcast()
+	}
+	for f := range self.dirty {
+		err := f.syncHeld()
+		if err != nil {
+			return err
+		}
+		delete(self.dirty, f)
+	}
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	self.size = 0
+	return self.file.Sync()
+}
+
+/*
+ replay writes all complete records on table files and empties the log.
+ A record which is torn or has wrong checksum is the last one and was not committed.
+*/
+func (self *writeAheadLog) replay() error {
+	_, err := self.file.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(self.file, scanBufferSize)
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	header := make([]byte, walHeaderSize)
+	for {
+		_, err = io.ReadFull(reader, header)
+		if err != nil {
+			break
+		}
+		size := binary.LittleEndian.Uint64(header)
+		if size > uint64(maxWalRecordSize) {
+			break
+		}
+		payload := make([]byte, size)
+		_, err = io.ReadFull(reader, payload)
+		if err != nil {
+			break
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[8:]) {
+			break
+		}
+		err = self.replayRecord(payload, files)
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		err = f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	return self.checkpoint()
+}
+
+//maxWalRecordSize is a limit to detect a broken record length.
+const maxWalRecordSize = 1 << 30
+
+//maxWalSize is the size of the log which makes a checkpoint after a commit.
+const maxWalSize = 4 << 20
+
+func (self *writeAheadLog) replayRecord(payload []byte, files map[string]*os.File) error {
+	count, num := binary.Varint(payload)
+	if num < 1 {
+		return ErrInvalidLog
+	}
+	payload = payload[num:]
+	for i := int64(0); i < count; i++ {
+		nameLen, num := binary.Varint(payload)
+		if num < 1 || int64(len(payload)-num) < nameLen || nameLen < 0 {
+			return ErrInvalidLog
+		}
+		name := string(payload[num : num+int(nameLen)])
+		payload = payload[num+int(nameLen):]
+		if name == "" || path.Base(name) != name || name == walFilename {
+			return ErrInvalidLog
+		}
+		offset, num := binary.Varint(payload)
+		if num < 1 || offset < 0 {
+			return ErrInvalidLog
+		}
+		payload = payload[num:]
+		dataLen, num := binary.Varint(payload)
+		if num < 1 || int64(len(payload)-num) < dataLen || dataLen < 0 {
+			return ErrInvalidLog
+		}
+		data := payload[num : num+int(dataLen)]
+		payload = payload[num+int(dataLen):]
+
+		f, ok := files[name]
+		if ok == false {
+			var err error
+			f, err = os.OpenFile(self.directory+"/"+name, os.O_RDWR+os.O_CREATE, 0666)
+			if err != nil {
+				return err
+			}
+			files[name] = f
+		}
+		_, err := f.WriteAt(data, offset)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *writeAheadLog) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return nil
+	}
+	self.closed = true
+	close(self.done)
+	err := self.flush()
+	var closeErr error
+	if self.file != nil {
+		closeErr = self.file.Close()
+		self.file = nil
+	}
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
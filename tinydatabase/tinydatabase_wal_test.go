@@ -0,0 +1,251 @@
+package tinydatabase
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test1_dataFile_stagedWrites(t *testing.T) {
+	directory := "./testdata/"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	f, err := os.OpenFile(directory+"staged.table", os.O_RDWR+os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("Failed to create file: %s", err)
+	}
+	_, err = f.Write([]byte("0123456789"))
+	if err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	file := newDataFile(f)
+	defer file.Close()
+
+	file.WriteAt([]byte("ab"), 2)
+	file.WriteAt([]byte("xyz"), 12)
+	size, err := file.Size()
+	if err != nil || size != 15 {
+		t.Errorf("Failed to get staged size: %d, %v", size, err)
+	}
+	b := make([]byte, 16)
+	num, err := file.ReadAt(b, 0)
+	if num != 15 || err != io.EOF {
+		t.Errorf("Failed to read staged file: %d, %v", num, err)
+	}
+	if bytes.Equal(b[:15], []byte("01ab456789\x00\x00xyz")) == false {
+		t.Errorf("Failed to read staged writes: %q", b[:15])
+	}
+
+	rollbackFiles(file)
+	size, _ = file.Size()
+	if size != 10 {
+		t.Errorf("Failed to drop staged writes: %d", size)
+	}
+
+	file.WriteAt([]byte("cd"), 4)
+	err = commitFiles(nil, file)
+	if err != nil {
+		t.Errorf("Failed to commit staged writes: %s", err)
+	}
+	b = make([]byte, 10)
+	_, err = f.ReadAt(b, 0)
+	if err != nil || string(b) != "0123cd6789" {
+		t.Errorf("Failed to write staged writes: %q, %v", b, err)
+	}
+}
+
+func Test2_WriteAheadLog_replay(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	db, err := dbList.NewDatabase("database1")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	//The log is created by the first write.
+	_, err = os.Stat(directoryJson + "database1/" + walFilename)
+	if err == nil {
+		t.Errorf("Failed to create write-ahead log on the first write")
+	}
+	columnSet := []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 0},
+	}
+	tableI, err := db.NewTable("dynamic1", "dynamic", columnSet)
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	dynamic := tableI.(*TableDynamic)
+	columnSet = []ColumnType{
+		{Name: "intline", Type: COLUMN_INT64, Size: 64},
+		{Name: "strline", Type: COLUMN_STRING, Size: 16},
+	}
+	tableI, err = db.NewTable("static1", "static", columnSet)
+	if err != nil {
+		t.Fatalf("Failed to create table: %s", err)
+	}
+	static := tableI.(*TableStatic)
+
+	_, err = dynamic.WriteRow(Row{"intline": int64(1), "strline": "committed"})
+	if err != nil {
+		t.Errorf("Failed to insert row: %s", err)
+	}
+	info, err := os.Stat(directoryJson + "database1/" + walFilename)
+	if err != nil {
+		t.Fatalf("Failed to create write-ahead log:%s", err)
+	}
+	if info.Size() == 0 {
+		t.Errorf("Failed to keep committed record until checkpoint")
+	}
+	err = db.Flush()
+	if err != nil {
+		t.Errorf("Failed to flush: %s", err)
+	}
+	info, _ = os.Stat(directoryJson + "database1/" + walFilename)
+	if info.Size() != 0 {
+		t.Errorf("Failed to checkpoint write-ahead log: %d", info.Size())
+	}
+
+	//Crash after the log is synced and before table files are written.
+	_, _, err = dynamic.writeRow(Row{"intline": int64(2), "strline": "logged"})
+	if err != nil {
+		t.Errorf("Failed to stage row: %s", err)
+	}
+	_, _, err = static.writeRow(Row{"intline": int64(3), "strline": "logged"})
+	if err != nil {
+		t.Errorf("Failed to stage row: %s", err)
+	}
+	err = db.wal.append([]*dataFile{dynamic.tablefile, dynamic.indexfile, static.tablefile})
+	if err != nil {
+		t.Errorf("Failed to append log: %s", err)
+	}
+	dynamic.rollback()
+	static.rollback()
+
+	//A torn record at the end is not committed.
+	_, _, err = static.writeRow(Row{"intline": int64(4), "strline": "torn"})
+	if err != nil {
+		t.Errorf("Failed to stage row: %s", err)
+	}
+	err = db.wal.append([]*dataFile{static.tablefile})
+	if err != nil {
+		t.Errorf("Failed to append log: %s", err)
+	}
+	static.rollback()
+	//Close empties the log, so the log left by the crash is put back after it.
+	logged, err := ioutil.ReadFile(directoryJson + "database1/" + walFilename)
+	if err != nil {
+		t.Fatalf("Failed to read write-ahead log:%s", err)
+	}
+	dbList.Close()
+	err = ioutil.WriteFile(directoryJson+"database1/"+walFilename, logged[:len(logged)-3], 0666)
+	if err != nil {
+		t.Fatalf("Failed to write write-ahead log:%s", err)
+	}
+
+	dbList, err = LoadDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to load database list:%s", err)
+	}
+	db, err = dbList.Get("database1")
+	if err != nil {
+		t.Fatalf("Failed to get database:%s", err)
+	}
+	info, _ = os.Stat(directoryJson + "database1/" + walFilename)
+	if info.Size() != 0 {
+		t.Errorf("Failed to empty write-ahead log after replay: %d", info.Size())
+	}
+	tableI, _ = db.GetTable("dynamic1")
+	row, err := tableI.ReadRow(1)
+	if err != nil || row["intline"] != int64(2) || row["strline"] != "logged" {
+		t.Errorf("Failed to replay dynamic row: %v, %v", row, err)
+	}
+	num, err := tableI.WriteRow(Row{"intline": int64(5), "strline": "after"})
+	if err != nil || num != 2 {
+		t.Errorf("Failed to insert row after replay: %d, %v", num, err)
+	}
+	tableI, _ = db.GetTable("static1")
+	row, err = tableI.ReadRow(0)
+	if err != nil || row["intline"] != int64(3) || row["strline"] != "logged" {
+		t.Errorf("Failed to replay static row: %v, %v", row, err)
+	}
+	_, err = tableI.ReadRow(1)
+	if err != ErrOutOfRowIndex {
+		t.Errorf("Failed to ignore torn record: %v", err)
+	}
+	dbList.Close()
+}
+
+func Test3_dataFile_coalesce(t *testing.T) {
+	directory := "./testdata/"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	f, err := os.OpenFile(directory+"staged.table", os.O_RDWR+os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("Failed to create file: %s", err)
+	}
+	file := newDataFile(f)
+	defer file.Close()
+
+	//Writes which continue the previous one are merged, and overlapping writes keep their order.
+	file.WriteAt([]byte("ab"), 0)
+	file.WriteAt([]byte("cd"), 2)
+	file.WriteAt([]byte("ef"), 4)
+	file.WriteAt([]byte("x"), 1)
+	file.WriteAt([]byte("y"), 2)
+	file.coalesce()
+	if len(file.writes) != 2 || string(file.writes[0].data) != "abcdef" || string(file.writes[1].data) != "xy" {
+		t.Errorf("Failed to coalesce staged writes: %v", file.writes)
+	}
+	err = commitFiles(nil, file)
+	if err != nil {
+		t.Errorf("Failed to commit staged writes: %s", err)
+	}
+	b := make([]byte, 6)
+	_, err = f.ReadAt(b, 0)
+	if err != nil || string(b) != "axydef" {
+		t.Errorf("Failed to write coalesced writes: %q, %v", b, err)
+	}
+}
+
+func Test4_dataFile_savepoint(t *testing.T) {
+	directory := "./testdata/"
+	os.RemoveAll(directory)
+	os.Mkdir(directory, 0777)
+
+	f, err := os.OpenFile(directory+"staged.table", os.O_RDWR+os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("Failed to create file: %s", err)
+	}
+	file := newDataFile(f)
+	defer file.Close()
+
+	//Appended writes are merged when they are staged, and a savepoint keeps the size of the last write.
+	file.WriteAt([]byte("ab"), 0)
+	mark := file.savepoint()
+	file.WriteAt([]byte("cd"), 2)
+	file.WriteAt([]byte("x"), 0)
+	if len(file.writes) != 2 || string(file.writes[0].data) != "abcd" {
+		t.Errorf("Failed to merge staged writes: %v", file.writes)
+	}
+	file.rollbackTo(mark)
+	size, _ := file.Size()
+	if len(file.writes) != 1 || string(file.writes[0].data) != "ab" || size != 2 {
+		t.Errorf("Failed to roll back to savepoint: %v, %d", file.writes, size)
+	}
+	file.WriteAt([]byte("ef"), 2)
+	b := make([]byte, 4)
+	_, err = file.ReadAt(b, 0)
+	if err != nil || string(b) != "abef" {
+		t.Errorf("Failed to read staged writes after rollback: %q, %v", b, err)
+	}
+}
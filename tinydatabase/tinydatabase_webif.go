@@ -1,6 +1,8 @@
 package tinydatabase
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -49,7 +51,7 @@ func (self *WebIF) DispatchHandlerFactory() func(http.ResponseWriter, *http.Requ
 				return
 			}
 		} else if strings.HasPrefix(req.URL.Path, self.Prefix+"databases/") {
-			afterWords := req.URL.Path[len(self.Prefix+"databases/"):]
+			afterWords := strings.TrimSuffix(req.URL.Path[len(self.Prefix+"databases/"):], "/")
 			commands := strings.Split(afterWords, "/")
 			databaseName := commands[0]
 			if regDbName.MatchString(databaseName) == false {
@@ -57,8 +59,18 @@ func (self *WebIF) DispatchHandlerFactory() func(http.ResponseWriter, *http.Requ
 				fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid database name\"}")
 				return
 			}
-			if len(commands) == 2 {
-				if commands[1] != "tables/" {
+			if len(commands) == 1 {
+				if req.Method == "DELETE" {
+					fmt.Printf("DELETE %s\n", req.URL.Path)
+					self.DropDatabase(w, databaseName)
+					return
+				} else if req.Method == "PATCH" {
+					fmt.Printf("PATCH %s\n", req.URL.Path)
+					self.RenameDatabase(w, req, databaseName)
+					return
+				}
+			} else if len(commands) == 2 {
+				if commands[1] != "tables" {
 					w.WriteHeader(http.StatusBadRequest)
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid path\"}")
 					return
@@ -72,7 +84,7 @@ func (self *WebIF) DispatchHandlerFactory() func(http.ResponseWriter, *http.Requ
 					self.CreateTable(w, req, databaseName)
 				}
 			} else if len(commands) == 3 {
-				if commands[1] != "tables/" {
+				if commands[1] != "tables" {
 					w.WriteHeader(http.StatusBadRequest)
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid path\"}")
 					return
@@ -87,9 +99,17 @@ func (self *WebIF) DispatchHandlerFactory() func(http.ResponseWriter, *http.Requ
 					fmt.Printf("GET %s\n", req.URL.Path)
 					self.GetTableDetail(w, databaseName, tableName)
 					return
+				} else if req.Method == "DELETE" {
+					fmt.Printf("DELETE %s\n", req.URL.Path)
+					self.DropTable(w, databaseName, tableName)
+					return
+				} else if req.Method == "PATCH" {
+					fmt.Printf("PATCH %s\n", req.URL.Path)
+					self.RenameTable(w, req, databaseName, tableName)
+					return
 				}
 			} else if len(commands) == 4 {
-				if commands[1] != "tables/" {
+				if commands[1] != "tables" {
 					w.WriteHeader(http.StatusBadRequest)
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid path\"}")
 					return
@@ -100,7 +120,7 @@ func (self *WebIF) DispatchHandlerFactory() func(http.ResponseWriter, *http.Requ
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid table name\"}")
 					return
 				}
-				if commands[3] != "rows/" {
+				if commands[3] != "rows" {
 					w.WriteHeader(http.StatusBadRequest)
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid path\"}")
 					return
@@ -111,7 +131,7 @@ func (self *WebIF) DispatchHandlerFactory() func(http.ResponseWriter, *http.Requ
 					return
 				}
 			} else if len(commands) == 5 {
-				if commands[1] != "tables/" {
+				if commands[1] != "tables" {
 					w.WriteHeader(http.StatusBadRequest)
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid path\"}")
 					return
@@ -122,7 +142,7 @@ func (self *WebIF) DispatchHandlerFactory() func(http.ResponseWriter, *http.Requ
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid table name\"}")
 					return
 				}
-				if commands[3] != "rows/" {
+				if commands[3] != "rows" {
 					w.WriteHeader(http.StatusBadRequest)
 					fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid path\"}")
 					return
@@ -143,7 +163,7 @@ func (self *WebIF) GetDatabaseList(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "[")
 	firstFlag := true
-	for key, _ := range self.Databases.Databases {
+	for _, key := range self.Databases.Names() {
 		if firstFlag {
 			fmt.Fprintf(w, "\"%s\"", key)
 			firstFlag = false
@@ -211,6 +231,87 @@ func (self *WebIF) CreateDatabase(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(w, "{\"status\":\"OK\"}")
 }
 
+func (self *WebIF) DropDatabase(w http.ResponseWriter, dbName string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, err := self.Databases.Get(dbName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"no database\"}")
+		return
+	}
+	err = self.Databases.DropDatabase(dbName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"internal error\"}")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "{\"status\":\"OK\"}")
+}
+
+//curl -v -H "Content-type: application/json" -X PATCH -d "{\"name\":\"newdatabase\"}"  http://localhost:8000/v1/databases/testdatabase
+
+func (self *WebIF) RenameDatabase(w http.ResponseWriter, req *http.Request, dbName string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, err := self.Databases.Get(dbName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"no database\"}")
+		return
+	}
+	newName, err := self.readNewName(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid parameter\"}")
+		return
+	}
+	if regDbName.MatchString(newName) == false || newName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid database name\"}")
+		return
+	}
+	_, err = self.Databases.Get(newName)
+	if err == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"database exists\"}")
+		return
+	}
+	err = self.Databases.RenameDatabase(dbName, newName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"internal error\"}")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "{\"status\":\"OK\"}")
+}
+
+//readNewName returns the name of request body such as {"name":"newname"}.
+func (self *WebIF) readNewName(req *http.Request) (string, error) {
+	var f interface{}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("  %s\n", string(body))
+	json.Unmarshal(body, &f)
+	m, ok := f.(map[string]interface{})
+	if ok == false {
+		return "", ErrInvalidParam
+	}
+	name, ok := m["name"].(string)
+	if ok == false {
+		return "", ErrInvalidParam
+	}
+	return name, nil
+}
+
 func (self *WebIF) GetTableList(w http.ResponseWriter, dbName string) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -219,12 +320,13 @@ func (self *WebIF) GetTableList(w http.ResponseWriter, dbName string) {
 		fmt.Printf("ERROR:%v\n", err)
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"no database\"}")
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "[")
 	firstFlag := true
-	for key, _ := range db.tables {
+	for _, key := range db.TableNames() {
 		if firstFlag {
 			firstFlag = false
 		} else {
@@ -344,7 +446,30 @@ func (self *WebIF) jsonChecker_CreateTable(body []byte) (*tableJson, error) {
 			column.Size = 64
 		} else if column.Type == COLUMN_FLOAT64 {
 			column.Size = 64
-		} else if column.Type == COLUMN_STRING {
+		} else if column.Type == COLUMN_UINT64 {
+			column.Size = 64
+		} else if column.Type == COLUMN_BOOL {
+			column.Size = 1
+		} else if column.Type == COLUMN_DECIMAL {
+			column.Size = 64
+			precisionF, ok := valMap["precision"].(float64)
+			if ok == false {
+				return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") precision is invalid")
+			}
+			column.Precision = int64(precisionF)
+			scaleI, ok := valMap["scale"]
+			if ok == true {
+				scaleF, ok := scaleI.(float64)
+				if ok == false {
+					return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") scale is invalid")
+				}
+				column.Scale = int64(scaleF)
+			}
+			_, err := column.GetBytes()
+			if err != nil {
+				return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") precision is invalid")
+			}
+		} else if column.Type == COLUMN_STRING || column.Type == COLUMN_BYTES {
 			sizeI, ok := valMap["size"]
 			if ok == false {
 				return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") size is invalid")
@@ -356,9 +481,43 @@ func (self *WebIF) jsonChecker_CreateTable(body []byte) (*tableJson, error) {
 			column.Size = int64(sizeF)
 		} else if column.Type == COLUMN_TIME {
 			column.Size = 15
+		} else if column.Type == COLUMN_JSON || column.isArray() {
+			column.Size = 0
+			_, err := column.GetBytes()
+			if err != nil {
+				return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") type is invalid")
+			}
+		} else if column.Type == COLUMN_UUID {
+			column.Size = 16
 		} else {
 			return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") type is invalid")
 		}
+		nullableI, ok := valMap["nullable"]
+		if ok == true {
+			column.Nullable, ok = nullableI.(bool)
+			if ok == false {
+				return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") nullable is invalid")
+			}
+		}
+		notnullI, ok := valMap["notnull"]
+		if ok == true {
+			column.NotNull, ok = notnullI.(bool)
+			if ok == false {
+				return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") notnull is invalid")
+			}
+		}
+		identityI, ok := valMap["identity"]
+		if ok == true {
+			column.Identity, ok = identityI.(bool)
+			if ok == false {
+				return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") identity is invalid")
+			}
+		}
+		column.Default = valMap["default"]
+		err := column.checkDefault()
+		if err != nil {
+			return nil, errors.New("column " + strconv.FormatInt(int64(i+1), 10) + "(" + column.Name + ") default is invalid")
+		}
 		result.Columns = append(result.Columns, column)
 	}
 	return result, nil
@@ -393,11 +552,105 @@ func (self *WebIF) GetTableDetail(w http.ResponseWriter, dbName string, tableNam
 		} else {
 			fmt.Fprint(w, ",")
 		}
-		fmt.Fprintf(w, "{\"name\":\"%s\",\"type\":\"%s\",\"size\":%d}", val.Name, val.Type, val.Size)
+		fmt.Fprintf(w, "{\"name\":\"%s\",\"type\":\"%s\",\"size\":%d", val.Name, val.Type, val.Size)
+		if val.Type == COLUMN_DECIMAL {
+			fmt.Fprintf(w, ",\"precision\":%d,\"scale\":%d", val.Precision, val.Scale)
+		}
+		if val.Nullable {
+			fmt.Fprint(w, ",\"nullable\":true")
+		}
+		if val.NotNull {
+			fmt.Fprint(w, ",\"notnull\":true")
+		}
+		if val.Identity {
+			fmt.Fprint(w, ",\"identity\":true")
+		}
+		if val.Default != nil {
+			b, _ := json.Marshal(val.Default)
+			fmt.Fprintf(w, ",\"default\":%s", b)
+		}
+		fmt.Fprint(w, "}")
 	}
 	fmt.Fprint(w, "]}")
 }
 
+func (self *WebIF) DropTable(w http.ResponseWriter, dbName string, tableName string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	db, err := self.Databases.Get(dbName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"no database\"}")
+		return
+	}
+	_, err = db.GetTable(tableName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"table does not exist\"}")
+		return
+	}
+	err = db.DropTable(tableName)
+	if err == ErrTableInTx {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "{\"status\":\"ERROR\",\"detail\":\"%s\"}", err)
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"internal error\"}")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "{\"status\":\"OK\"}")
+}
+
+func (self *WebIF) RenameTable(w http.ResponseWriter, req *http.Request, dbName string, tableName string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	db, err := self.Databases.Get(dbName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"no database\"}")
+		return
+	}
+	_, err = db.GetTable(tableName)
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"table does not exist\"}")
+		return
+	}
+	newName, err := self.readNewName(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid parameter\"}")
+		return
+	}
+	if regDbName.MatchString(newName) == false || newName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid table name\"}")
+		return
+	}
+	err = db.RenameTable(tableName, newName)
+	if err == ErrTableExist || err == ErrTableInTx {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "{\"status\":\"ERROR\",\"detail\":\"%s\"}", err)
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR:%v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"internal error\"}")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "{\"status\":\"OK\"}")
+}
+
 func (self *WebIF) AddRow(w http.ResponseWriter, req *http.Request, dbName string, tableName string) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -423,8 +676,11 @@ func (self *WebIF) AddRow(w http.ResponseWriter, req *http.Request, dbName strin
 		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"invalid parameter\"}")
 		return
 	}
+	//Numbers are kept as json.Number so that uint64 and decimal are not rounded.
 	var f interface{}
-	json.Unmarshal(body, &f)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	decoder.Decode(&f)
 	m, ok := f.(map[string]interface{})
 	if ok == false {
 		w.WriteHeader(http.StatusBadRequest)
@@ -434,6 +690,10 @@ func (self *WebIF) AddRow(w http.ResponseWriter, req *http.Request, dbName strin
 	tempC := table.GetColumns()
 	tableCslice := []string{}
 	for _, val := range tempC {
+		_, ok := m[val.Name]
+		if ok == false && (val.Default != nil || val.Nullable || val.Identity) {
+			continue
+		}
 		tableCslice = append(tableCslice, val.Name)
 	}
 	sort.Strings(tableCslice)
@@ -448,6 +708,10 @@ func (self *WebIF) AddRow(w http.ResponseWriter, req *http.Request, dbName strin
 		return
 	}
 	for _, val := range tempC {
+		v, ok := m[val.Name]
+		if ok == false || (v == nil && val.Nullable) {
+			continue
+		}
 		_, err = val.ConvertToBytes(m[val.Name])
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -455,14 +719,25 @@ func (self *WebIF) AddRow(w http.ResponseWriter, req *http.Request, dbName strin
 			return
 		}
 	}
-	rowNum, err := table.WriteRow(m)
+	rowNum, written, err := table.WriteRowReturning(m)
+	if err == ErrNotNull || err == ErrConstraintViolation || err == ErrIdentityValue {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "{\"status\":\"ERROR\",\"detail\":\"%s\"}", err)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, "{\"status\":\"ERROR\",\"detail\":\"internal server error\"}")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "{\"status\":\"OK\",\"rownum\":%d}", rowNum)
+	fmt.Fprintf(w, "{\"status\":\"OK\",\"rownum\":%d", rowNum)
+	for _, val := range tempC {
+		if val.Identity {
+			fmt.Fprintf(w, ",\"identity\":%d", written[val.Name])
+		}
+	}
+	fmt.Fprint(w, "}")
 }
 
 func (self *WebIF) GetRow(w http.ResponseWriter, dbName string, tableName string, rowNum string) {
@@ -506,7 +781,9 @@ func (self *WebIF) GetRow(w http.ResponseWriter, dbName string, tableName string
 			fmt.Fprintf(w, ",")
 		}
 		fmt.Fprintf(w, "\"%s\":", val.Name)
-		if val.Type == COLUMN_STRING {
+		if row[val.Name] == nil {
+			fmt.Fprint(w, "null")
+		} else if val.Type == COLUMN_STRING {
 			fmt.Fprintf(w, "\"%s\"", row[val.Name].(string))
 		} else if val.Type == COLUMN_INT64 {
 			fmt.Fprintf(w, "%d", row[val.Name].(int64))
@@ -514,6 +791,19 @@ func (self *WebIF) GetRow(w http.ResponseWriter, dbName string, tableName string
 			fmt.Fprintf(w, "%f", row[val.Name].(float64))
 		} else if val.Type == COLUMN_TIME {
 			fmt.Fprintf(w, "\"%s\"", row[val.Name].(time.Time).Format(time.RFC3339Nano))
+		} else if val.Type == COLUMN_BOOL {
+			fmt.Fprintf(w, "%t", row[val.Name].(bool))
+		} else if val.Type == COLUMN_BYTES {
+			fmt.Fprintf(w, "\"%s\"", base64.StdEncoding.EncodeToString(row[val.Name].([]byte)))
+		} else if val.Type == COLUMN_UINT64 {
+			fmt.Fprintf(w, "%d", row[val.Name].(uint64))
+		} else if val.Type == COLUMN_DECIMAL {
+			fmt.Fprint(w, row[val.Name].(Decimal).String())
+		} else if val.Type == COLUMN_UUID {
+			fmt.Fprintf(w, "\"%s\"", row[val.Name].(UUID).String())
+		} else if val.Type == COLUMN_JSON || val.isArray() {
+			b, _ := json.Marshal(row[val.Name])
+			fmt.Fprintf(w, "%s", b)
 		}
 	}
 	fmt.Fprint(w, "}")
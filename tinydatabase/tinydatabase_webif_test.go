@@ -2,6 +2,7 @@ package tinydatabase
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -294,3 +295,432 @@ func Test1_WebifFuncs_basicUsage(t *testing.T) {
 		t.Fatalf("Status Error %d,%v", r.Code, string(data))
 	}
 }
+
+func Test2_WebifFuncs_nullable(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	webIf := WebIF{}
+	webIf.Prefix = "/v1/"
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	webIf.Databases = dbList
+	defer dbList.Close()
+	_, err = dbList.NewDatabase("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+
+	r := httptest.NewRecorder()
+	jsonStr := "{\"name\":\"testtable\",\"type\":\"dynamic\",\"columns\":[{\"name\":\"column1\",\"type\":\"int64\"},{\"name\":\"column2\",\"type\":\"string\",\"size\":0,\"nullable\":true}]}"
+	req, _ := http.NewRequest("POST", "/v1/databases/testdatabase/tables/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.CreateTable(r, req, "testdatabase")
+	if r.Code != 200 {
+		t.Fatalf("Status Error %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetTableDetail(r, "testdatabase", "testtable")
+	data, _ := ioutil.ReadAll(r.Body)
+	if "{\"name\":\"testtable\",\"database\":\"testdatabase\",\"type\":\"dynamic\",\"columns\":[{\"name\":\"column1\",\"type\":\"int64\",\"size\":64},{\"name\":\"column2\",\"type\":\"string\",\"size\":0,\"nullable\":true}]}" != string(data) {
+		t.Errorf("Data Error. %v", string(data))
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":1,\"column2\":null}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	data, _ = ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"status\":\"OK\",\"rownum\":0}" != string(data) {
+		t.Errorf("Failed to add row with null: %d, %v", r.Code, string(data))
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":null,\"column2\":\"a\"}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse null for column which is not nullable: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetRow(r, "testdatabase", "testtable", "0")
+	data, _ = ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"column1\":1,\"column2\":null}" != string(data) {
+		t.Errorf("Failed to render null: %d, %v", r.Code, string(data))
+	}
+}
+
+func Test3_WebifFuncs_defaults(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	webIf := WebIF{}
+	webIf.Prefix = "/v1/"
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	webIf.Databases = dbList
+	defer dbList.Close()
+	_, err = dbList.NewDatabase("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+
+	r := httptest.NewRecorder()
+	jsonStr := "{\"name\":\"testtable\",\"type\":\"dynamic\",\"columns\":[{\"name\":\"column1\",\"type\":\"int64\",\"default\":\"autoincrement()\"},{\"name\":\"column2\",\"type\":\"string\",\"size\":0,\"notnull\":true},{\"name\":\"column3\",\"type\":\"string\",\"size\":0,\"default\":\"none\"}]}"
+	req, _ := http.NewRequest("POST", "/v1/databases/testdatabase/tables/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.CreateTable(r, req, "testdatabase")
+	if r.Code != 200 {
+		t.Fatalf("Status Error %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetTableDetail(r, "testdatabase", "testtable")
+	data, _ := ioutil.ReadAll(r.Body)
+	if "{\"name\":\"testtable\",\"database\":\"testdatabase\",\"type\":\"dynamic\",\"columns\":[{\"name\":\"column1\",\"type\":\"int64\",\"size\":64,\"default\":\"autoincrement()\"},{\"name\":\"column2\",\"type\":\"string\",\"size\":0,\"notnull\":true},{\"name\":\"column3\",\"type\":\"string\",\"size\":0,\"default\":\"none\"}]}" != string(data) {
+		t.Errorf("Data Error. %v", string(data))
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column2\":\"a\"}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	data, _ = ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"status\":\"OK\",\"rownum\":0}" != string(data) {
+		t.Errorf("Failed to add row with default values: %d, %v", r.Code, string(data))
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":5}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse row without NOT NULL column: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetRow(r, "testdatabase", "testtable", "0")
+	data, _ = ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"column1\":1,\"column2\":\"a\",\"column3\":\"none\"}" != string(data) {
+		t.Errorf("Failed to get row with default values: %d, %v", r.Code, string(data))
+	}
+}
+
+func Test4_WebifFuncs_columnTypes(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	webIf := WebIF{}
+	webIf.Prefix = "/v1/"
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	webIf.Databases = dbList
+	defer dbList.Close()
+	_, err = dbList.NewDatabase("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+
+	r := httptest.NewRecorder()
+	jsonStr := "{\"name\":\"testtable\",\"type\":\"static\",\"columns\":[{\"name\":\"column1\",\"type\":\"bool\"},{\"name\":\"column2\",\"type\":\"bytes\",\"size\":3},{\"name\":\"column3\",\"type\":\"uint64\"},{\"name\":\"column4\",\"type\":\"decimal\",\"precision\":8,\"scale\":3}]}"
+	req, _ := http.NewRequest("POST", "/v1/databases/testdatabase/tables/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.CreateTable(r, req, "testdatabase")
+	if r.Code != 200 {
+		t.Fatalf("Status Error %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetTableDetail(r, "testdatabase", "testtable")
+	data, _ := ioutil.ReadAll(r.Body)
+	if "{\"name\":\"testtable\",\"database\":\"testdatabase\",\"type\":\"static\",\"columns\":[{\"name\":\"column1\",\"type\":\"bool\",\"size\":1},{\"name\":\"column2\",\"type\":\"bytes\",\"size\":3},{\"name\":\"column3\",\"type\":\"uint64\",\"size\":64},{\"name\":\"column4\",\"type\":\"decimal\",\"size\":64,\"precision\":8,\"scale\":3}]}" != string(data) {
+		t.Errorf("Data Error. %v", string(data))
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":true,\"column2\":\"AQID\",\"column3\":18446744073709551615,\"column4\":-12345.678}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != 200 {
+		t.Errorf("Failed to add row: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":false,\"column2\":\"AQID\",\"column3\":1,\"column4\":0.0001}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse decimal with too many digits: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetRow(r, "testdatabase", "testtable", "0")
+	data, _ = ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"column1\":true,\"column2\":\"AQID\",\"column3\":18446744073709551615,\"column4\":-12345.678}" != string(data) {
+		t.Errorf("Failed to get row: %d, %v", r.Code, string(data))
+	}
+}
+
+func Test5_WebifFuncs_json(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	webIf := WebIF{}
+	webIf.Prefix = "/v1/"
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	webIf.Databases = dbList
+	defer dbList.Close()
+	_, err = dbList.NewDatabase("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+
+	r := httptest.NewRecorder()
+	jsonStr := "{\"name\":\"testtable\",\"type\":\"dynamic\",\"columns\":[{\"name\":\"column1\",\"type\":\"int64\"},{\"name\":\"column2\",\"type\":\"json\"}]}"
+	req, _ := http.NewRequest("POST", "/v1/databases/testdatabase/tables/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.CreateTable(r, req, "testdatabase")
+	if r.Code != 200 {
+		t.Fatalf("Status Error %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":1,\"column2\":{\"customer\":{\"id\":18446744073709551615,\"tags\":[\"a\",null]},\"total\":1.5}}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != 200 {
+		t.Errorf("Failed to add row: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetRow(r, "testdatabase", "testtable", "0")
+	data, _ := ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"column1\":1,\"column2\":{\"customer\":{\"id\":18446744073709551615,\"tags\":[\"a\",null]},\"total\":1.5}}" != string(data) {
+		t.Errorf("Failed to get row: %d, %v", r.Code, string(data))
+	}
+}
+
+func Test6_WebifFuncs_identity(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	webIf := WebIF{}
+	webIf.Prefix = "/v1/"
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	webIf.Databases = dbList
+	defer dbList.Close()
+	_, err = dbList.NewDatabase("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+
+	r := httptest.NewRecorder()
+	jsonStr := "{\"name\":\"testtable\",\"type\":\"static\",\"columns\":[{\"name\":\"column1\",\"type\":\"int64\",\"identity\":true},{\"name\":\"column2\",\"type\":\"uuid\"}]}"
+	req, _ := http.NewRequest("POST", "/v1/databases/testdatabase/tables/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.CreateTable(r, req, "testdatabase")
+	if r.Code != 200 {
+		t.Fatalf("Status Error %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetTableDetail(r, "testdatabase", "testtable")
+	data, _ := ioutil.ReadAll(r.Body)
+	if "{\"name\":\"testtable\",\"database\":\"testdatabase\",\"type\":\"static\",\"columns\":[{\"name\":\"column1\",\"type\":\"int64\",\"size\":64,\"identity\":true},{\"name\":\"column2\",\"type\":\"uuid\",\"size\":16}]}" != string(data) {
+		t.Errorf("Data Error. %v", string(data))
+	}
+
+	for i := 1; i <= 2; i++ {
+		r = httptest.NewRecorder()
+		jsonStr = "{\"column2\":\"123e4567-e89b-12d3-a456-426614174000\"}"
+		req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+		webIf.AddRow(r, req, "testdatabase", "testtable")
+		data, _ = ioutil.ReadAll(r.Body)
+		if r.Code != 200 || fmt.Sprintf("{\"status\":\"OK\",\"rownum\":%d,\"identity\":%d}", i-1, i) != string(data) {
+			t.Errorf("Failed to add row: %d, %v", r.Code, string(data))
+		}
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":9,\"column2\":\"123e4567-e89b-12d3-a456-426614174000\"}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse value of identity column: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetRow(r, "testdatabase", "testtable", "1")
+	data, _ = ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"column1\":2,\"column2\":\"123e4567-e89b-12d3-a456-426614174000\"}" != string(data) {
+		t.Errorf("Failed to get row: %d, %v", r.Code, string(data))
+	}
+}
+
+func Test7_WebifFuncs_array(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	webIf := WebIF{}
+	webIf.Prefix = "/v1/"
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	webIf.Databases = dbList
+	defer dbList.Close()
+	_, err = dbList.NewDatabase("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+
+	r := httptest.NewRecorder()
+	jsonStr := "{\"name\":\"testtable\",\"type\":\"dynamic\",\"columns\":[{\"name\":\"column1\",\"type\":\"[]int64\"},{\"name\":\"column2\",\"type\":\"[]string\"},{\"name\":\"column3\",\"type\":\"[]time\"}]}"
+	req, _ := http.NewRequest("POST", "/v1/databases/testdatabase/tables/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.CreateTable(r, req, "testdatabase")
+	if r.Code != 200 {
+		t.Fatalf("Status Error %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":[1,2,9007199254740993],\"column2\":[\"a\",\"b\\\"c\"],\"column3\":[\"2020-01-02T03:04:05Z\"]}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != 200 {
+		t.Errorf("Failed to add row: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	jsonStr = "{\"column1\":[\"x\"],\"column2\":[],\"column3\":[]}"
+	req, _ = http.NewRequest("POST", "/v1/databases/testdatabase/tables/testtable/rows/", bytes.NewBuffer([]byte(jsonStr)))
+	webIf.AddRow(r, req, "testdatabase", "testtable")
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse invalid element: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	webIf.GetRow(r, "testdatabase", "testtable", "0")
+	data, _ := ioutil.ReadAll(r.Body)
+	if r.Code != 200 || "{\"column1\":[1,2,9007199254740993],\"column2\":[\"a\",\"b\\\"c\"],\"column3\":[\"2020-01-02T03:04:05Z\"]}" != string(data) {
+		t.Errorf("Failed to get row: %d, %v", r.Code, string(data))
+	}
+}
+
+func Test8_WebifFuncs_dropRename(t *testing.T) {
+	directoryJson := "./testdata_json/"
+	DirParmission = 0777
+	os.RemoveAll(directoryJson)
+
+	webIf := WebIF{}
+	webIf.Prefix = "/v1/"
+	dbList, err := NewDatabaseList(directoryJson, "json")
+	if err != nil {
+		t.Fatalf("Failed to create new database list:%s", err)
+	}
+	webIf.Databases = dbList
+	defer dbList.Close()
+	db, err := dbList.NewDatabase("testdatabase")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	_, err = dbList.NewDatabase("testdatabase2")
+	if err != nil {
+		t.Fatalf("Failed to create new database:%s", err)
+	}
+	for _, name := range []string{"testtable", "testtable2"} {
+		_, err = db.NewTable(name, "static", []ColumnType{{Name: "column1", Type: COLUMN_INT64, Size: 64}})
+		if err != nil {
+			t.Fatalf("Failed to create table: %s", err)
+		}
+	}
+	handler := webIf.DispatchHandlerFactory()
+
+	r := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/v1/databases/testdatabase/tables/testtable", bytes.NewBuffer([]byte("{\"name\":\"testtable2\"}")))
+	handler(r, req)
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse existing table name: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/v1/databases/testdatabase/tables/testtable", bytes.NewBuffer([]byte("{\"name\":\"test.table\"}")))
+	handler(r, req)
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse invalid table name: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/v1/databases/testdatabase/tables/testtable", bytes.NewBuffer([]byte("{\"name\":\"renamed\"}")))
+	handler(r, req)
+	data, _ := ioutil.ReadAll(r.Body)
+	if r.Code != 200 || string(data) != "{\"status\":\"OK\"}" {
+		t.Errorf("Failed to rename table: %d, %v", r.Code, string(data))
+	}
+	_, err = db.GetTable("renamed")
+	if err != nil {
+		t.Errorf("Failed to get renamed table: %s", err)
+	}
+
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/v1/databases/testdatabase/tables/testtable2/", nil)
+	handler(r, req)
+	if r.Code != 200 {
+		t.Errorf("Failed to drop table: %d", r.Code)
+	}
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/databases/testdatabase/tables/", nil)
+	handler(r, req)
+	data, _ = ioutil.ReadAll(r.Body)
+	if r.Code != 200 || string(data) != "[\"renamed\"]" {
+		t.Errorf("Failed to get table list: %d, %v", r.Code, string(data))
+	}
+
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/v1/databases/testdatabase/tables/testtable2", nil)
+	handler(r, req)
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse dropped table: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/v1/databases/testdatabase", bytes.NewBuffer([]byte("{\"name\":\"testdatabase2\"}")))
+	handler(r, req)
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("Failed to refuse existing database name: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/v1/databases/testdatabase2", nil)
+	handler(r, req)
+	if r.Code != 200 {
+		t.Errorf("Failed to drop database: %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/v1/databases/testdatabase/", bytes.NewBuffer([]byte("{\"name\":\"renamed\"}")))
+	handler(r, req)
+	if r.Code != 200 {
+		t.Errorf("Failed to rename database: %d", r.Code)
+	}
+	r = httptest.NewRecorder()
+	webIf.GetDatabaseList(r)
+	data, _ = ioutil.ReadAll(r.Body)
+	if string(data) != "[\"renamed\"]" {
+		t.Errorf("Failed to get database list: %v", string(data))
+	}
+}